@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// aclConfig 路由配置中的IP访问控制，元素为CIDR或IP
+type aclConfig struct {
+	Allow []string `json:"allow,omitempty" yaml:"allow"` // 允许访问的网段，为空时不限制
+	Deny  []string `json:"deny,omitempty" yaml:"deny"`   // 拒绝访问的网段，优先于allow
+}
+
+// ipACL 按客户端IP的访问控制，先检查deny再检查allow
+type ipACL struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// globalACL 由-allow-cidrs和-deny-cidrs得到的访问控制，对所有路由生效，为nil时不检查
+var globalACL *ipACL
+
+// newIPACL 解析访问控制列表，allow和deny都为空时返回nil表示不检查
+func newIPACL(allow, deny []string) (*ipACL, error) {
+	a := &ipACL{}
+	var err error
+	if a.allow, err = parseIPNets(strings.Join(allow, ",")); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parseIPNets(strings.Join(deny, ",")); err != nil {
+		return nil, err
+	}
+	if len(a.allow) == 0 && len(a.deny) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// check 判断客户端IP是否允许访问，拒绝时返回命中的规则用于审计日志
+func (a *ipACL) check(addr string) (bool, string) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false, "unparseable client address"
+	}
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false, "deny " + n.String()
+		}
+	}
+	if len(a.allow) == 0 {
+		return true, ""
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true, ""
+		}
+	}
+	return false, "not in allow list"
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// checkAdminRequest 校验管理接口请求的X-Admin-Token头和请求方法，不通过时写出错误响应并返回false；
+// 令牌为空时（仅在独立的-admin-addr上允许）不校验令牌
+func checkAdminRequest(w http.ResponseWriter, r *http.Request, token string, methods ...string) bool {
+	if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(token)) != 1 {
+		logger.Warnf("Rejected admin request from %s: invalid token", clientIP(r))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// newReloadHandler 创建重新加载路由的管理接口，需通过X-Admin-Token头校验令牌。
+// 成功时返回新的路由数量，失败时返回400和错误信息，原路由表保持生效
+func newReloadHandler(token string, reload func() (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminRequest(w, r, token, http.MethodPost) {
+			return
+		}
+
+		count, err := reload()
+		if err != nil {
+			logger.Errorf("Route reload failed, keeping current routes: %v", err)
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		logger.Infof("Routes reloaded by %s: %d routes", clientIP(r), count)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"routes": count})
+	}
+}
+
+// backendStatus 状态接口中单个后端的状态
+type backendStatus struct {
+	URL       string `json:"url"`
+	Healthy   bool   `json:"healthy"`
+	InFlight  int64  `json:"in_flight"`
+	Queued    int    `json:"queued,omitempty"` // 等待后端并发名额的请求数
+	Breaker   string `json:"breaker,omitempty"`
+	LastCheck string `json:"last_check,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	Canary    bool   `json:"canary,omitempty"`
+}
+
+// routeStatus 状态接口中单条路由的状态
+type routeStatus struct {
+	Prefix   string          `json:"prefix"`
+	Hosts    []string        `json:"hosts,omitempty"`
+	Listener string          `json:"listener,omitempty"` // 路由所属的监听器，全局路由为空
+	Strategy string          `json:"strategy"`
+	Backends []backendStatus `json:"backends"`
+}
+
+// newStatusHandler 创建查看路由和后端状态的管理接口（GET /admin/status）
+func newStatusHandler(token string, status func() []routeStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminRequest(w, r, token, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"routes": status()})
+	}
+}
+
+// collectRouteStatus 汇总路由表中各后端的健康检查、熔断器、转发中和排队的请求数
+func collectRouteStatus(table *routeTable, healthy func(*url.URL) bool, breakers *breakerRegistry, limits *backendLimiters, checker *healthChecker) []routeStatus {
+	var routes []routeStatus
+	for _, rt := range table.routes {
+		rs := routeStatus{Prefix: rt.prefix, Hosts: rt.hosts, Listener: rt.listener, Strategy: rt.selector.strategy}
+		for i, b := range rt.allBackends() {
+			bs := backendStatus{URL: b.String(), Healthy: healthy == nil || healthy(b)}
+			if i < len(rt.backends) {
+				bs.InFlight = rt.selector.inflight[i].Load()
+			} else {
+				bs.InFlight = rt.canary.selector.inflight[i-len(rt.backends)].Load()
+				bs.Canary = true
+			}
+			if limits != nil {
+				if l := limits.get(b.Host); l != nil {
+					bs.Queued = l.queued()
+				}
+			}
+			if breakers != nil {
+				bs.Breaker = breakers.get(b.Host).currentState().String()
+			}
+			if checker != nil {
+				if h := checker.snapshot(b); h != nil {
+					bs.LastCheck = h.lastCheck.Format(time.RFC3339)
+					bs.LastError = h.lastError
+				}
+			}
+			rs.Backends = append(rs.Backends, bs)
+		}
+		routes = append(routes, rs)
+	}
+	return routes
+}
+
+// connTracker 记录当前打开的客户端连接，协议升级后交出的连接不再跟踪
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]*connStatus
+}
+
+// connStatus 连接接口中单个客户端连接的状态
+type connStatus struct {
+	Remote string    `json:"remote"`
+	State  string    `json:"state"`
+	Since  time.Time `json:"since"`
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[net.Conn]*connStatus)}
+}
+
+// trackConnState 作为http.Server.ConnState更新连接状态
+func (t *connTracker) trackConnState(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch state {
+	case http.StateNew:
+		t.conns[c] = &connStatus{Remote: c.RemoteAddr().String(), State: state.String(), Since: time.Now()}
+	case http.StateActive, http.StateIdle:
+		if cs, ok := t.conns[c]; ok {
+			cs.State = state.String()
+		}
+	case http.StateClosed, http.StateHijacked:
+		delete(t.conns, c)
+	}
+}
+
+// snapshot 返回按建立时间排序的连接列表
+func (t *connTracker) snapshot() []connStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns := make([]connStatus, 0, len(t.conns))
+	for _, cs := range t.conns {
+		conns = append(conns, *cs)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Since.Before(conns[j].Since) })
+	return conns
+}
+
+// newConnectionsHandler 创建查看客户端连接和转发中请求数的管理接口（GET /admin/connections）
+func newConnectionsHandler(token string, tracker *connTracker, inFlight func() int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminRequest(w, r, token, http.MethodGet) {
+			return
+		}
+		conns := tracker.snapshot()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"open":        len(conns),
+			"in_flight":   inFlight(),
+			"connections": conns,
+		})
+	}
+}
+
+// secretFlags 配置接口和启动日志中不输出取值的参数
+var secretFlags = map[string]bool{"admin-token": true, "capture-token": true, "otlp-headers": true, "consul-token": true}
+
+// displayFlagValue 返回对外展示的参数取值，令牌不输出，-set-header只输出请求头名称，上游代理地址不输出密码
+func displayFlagValue(f *flag.Flag) string {
+	value := f.Value.String()
+	switch {
+	case secretFlags[f.Name] && value != "":
+		value = "<redacted>"
+	case f.Name == "set-header":
+		var names []string
+		for _, h := range setHeaders {
+			names = append(names, h.name+"=<redacted>")
+		}
+		value = strings.Join(names, ",")
+	case f.Name == "upstream-proxy", f.Name == "mirror-backend":
+		value = redactURL(value)
+	case f.Name == "backend":
+		var backends []string
+		for _, backend := range strings.Split(value, ",") {
+			backends = append(backends, redactURL(backend))
+		}
+		value = strings.Join(backends, ",")
+	case f.Name == "upstream-proxy-backend":
+		var rules []string
+		for _, rule := range upstreamProxyRules {
+			if host, proxy, ok := strings.Cut(rule, "="); ok {
+				rule = host + "=" + redactURL(proxy)
+			}
+			rules = append(rules, rule)
+		}
+		value = strings.Join(rules, ",")
+	}
+	return value
+}
+
+// redactURL 后端或上游代理地址中的密码显示为xxxxx
+func redactURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		if strings.Contains(raw, "@") {
+			return "<redacted>"
+		}
+		return raw
+	}
+	return u.Redacted()
+}
+
+// flagStatus 配置接口中单个参数的取值和来源
+type flagStatus struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// newConfigHandler 创建查看当前生效参数及其来源的管理接口（GET /admin/config），
+// 令牌不输出，-set-header只输出请求头名称
+func newConfigHandler(token string, fs *flag.FlagSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminRequest(w, r, token, http.MethodGet) {
+			return
+		}
+		var flags []flagStatus
+		fs.VisitAll(func(f *flag.Flag) {
+			flags = append(flags, flagStatus{Name: f.Name, Value: displayFlagValue(f), Source: flagSources[f.Name]})
+		})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"config_file": configPath, "flags": flags})
+	}
+}
+
+// newLogLevelHandler 创建查看和修改日志级别的管理接口：GET返回当前级别，POST ?level=debug修改，重启后恢复默认
+func newLogLevelHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminRequest(w, r, token, http.MethodGet, http.MethodPost) {
+			return
+		}
+		if r.Method == http.MethodPost {
+			level, err := logrus.ParseLevel(r.FormValue("level"))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+				return
+			}
+			logger.Warnf("Log level changed from %s to %s by %s", logger.GetLevel(), level, clientIP(r))
+			logger.SetLevel(level)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"level": logger.GetLevel().String()})
+	}
+}
+
+// newDrainHandler 创建触发优雅关闭的管理接口（POST /admin/drain），效果与收到SIGTERM相同，
+// 再次调用时强制关闭剩余连接
+func newDrainHandler(token string, drain func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminRequest(w, r, token, http.MethodPost) {
+			return
+		}
+		logger.Warnf("Drain requested by %s", clientIP(r))
+		drain()
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"draining": true})
+	}
+}
+
+// newUpgradeHandler 创建触发二进制升级的管理接口（POST /admin/upgrade），效果与收到SIGUSR2相同：启动磁盘上的新可执行文件并传递监听套接字，
+// 新进程就绪后返回其PID，当前进程随后优雅退出；新进程启动失败时返回500，当前进程继续服务
+func newUpgradeHandler(token string, upgrade func(source string) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminRequest(w, r, token, http.MethodPost) {
+			return
+		}
+		pid, err := upgrade("admin API from " + clientIP(r))
+		if errors.Is(err, errUpgradeInProgress) {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"pid": pid, "draining": true})
+	}
+}
+
+// writeJSON 以JSON格式写出响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
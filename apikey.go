@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// apiKeyEntry API Key文件中的一个Key
+type apiKeyEntry struct {
+	Name        string  `json:"name,omitempty" yaml:"name"`                 // Key的名称，用于日志、指标和转发给后端的请求头
+	Key         string  `json:"key,omitempty" yaml:"key"`                   // Key的明文
+	KeySHA256   string  `json:"key_sha256,omitempty" yaml:"key_sha256"`     // Key的SHA-256（十六进制），文件中不想保存明文时使用
+	Rate        float64 `json:"rate,omitempty" yaml:"rate"`                 // 该Key每秒允许的请求数，0表示不限速
+	Burst       int     `json:"burst,omitempty" yaml:"burst"`               // 令牌桶容量，为0时取rate向上取整
+	Quota       int64   `json:"quota,omitempty" yaml:"quota"`               // 每个配额周期允许的请求数，0表示不限制
+	QuotaPeriod string  `json:"quota_period,omitempty" yaml:"quota_period"` // 配额周期，如24h，按UTC对齐，为空时为24h
+}
+
+// apiKeysFile API Key文件格式，YAML或JSON
+type apiKeysFile struct {
+	Keys []apiKeyEntry `json:"keys" yaml:"keys"`
+}
+
+// apiKey 一个有效的API Key及其限额
+type apiKey struct {
+	name        string
+	rate        float64
+	burst       int
+	quota       int64
+	quotaPeriod time.Duration
+}
+
+// apiKeyStore 按Key的SHA-256查找API Key，内存中不保存明文
+type apiKeyStore struct {
+	keys  map[[sha256.Size]byte]*apiKey
+	names map[string]bool
+}
+
+func newAPIKeyStore() *apiKeyStore {
+	return &apiKeyStore{keys: map[[sha256.Size]byte]*apiKey{}, names: map[string]bool{}}
+}
+
+// add 校验并加入一个Key，名称和Key都不能重复
+func (s *apiKeyStore) add(e apiKeyEntry) error {
+	if e.Name == "" {
+		return fmt.Errorf("key without name")
+	}
+	if s.names[e.Name] {
+		return fmt.Errorf("duplicate key name %s", e.Name)
+	}
+	s.names[e.Name] = true
+	var sum [sha256.Size]byte
+	switch {
+	case e.Key != "" && e.KeySHA256 != "":
+		return fmt.Errorf("key %s: key and key_sha256 cannot be used together", e.Name)
+	case e.Key != "":
+		sum = sha256.Sum256([]byte(e.Key))
+	case e.KeySHA256 != "":
+		b, err := hex.DecodeString(e.KeySHA256)
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("key %s: invalid key_sha256", e.Name)
+		}
+		copy(sum[:], b)
+	default:
+		return fmt.Errorf("key %s: key or key_sha256 is required", e.Name)
+	}
+	if _, dup := s.keys[sum]; dup {
+		return fmt.Errorf("key %s: same key is already used", e.Name)
+	}
+	if e.Rate < 0 || e.Burst < 0 || e.Quota < 0 {
+		return fmt.Errorf("key %s: rate, burst and quota must not be negative", e.Name)
+	}
+	k := &apiKey{name: e.Name, rate: e.Rate, burst: e.Burst, quota: e.Quota, quotaPeriod: 24 * time.Hour}
+	if k.burst == 0 {
+		k.burst = int(e.Rate + 0.999)
+	}
+	if e.QuotaPeriod != "" {
+		d, err := time.ParseDuration(e.QuotaPeriod)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("key %s: invalid quota_period %q", e.Name, e.QuotaPeriod)
+		}
+		k.quotaPeriod = d
+	}
+	s.keys[sum] = k
+	return nil
+}
+
+// loadAPIKeys 从文件和环境变量加载API Key，环境变量的格式为逗号分隔的name:key
+func loadAPIKeys(path, envName string) (*apiKeyStore, error) {
+	store := newAPIKeyStore()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var file apiKeysFile
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&file); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		for _, e := range file.Keys {
+			if err := store.add(e); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+	if envName != "" {
+		for _, item := range splitValues(os.Getenv(envName)) {
+			name, key, ok := strings.Cut(item, ":")
+			if !ok {
+				return nil, fmt.Errorf("%s: expected name:key, got %q", envName, item)
+			}
+			if err := store.add(apiKeyEntry{Name: name, Key: key}); err != nil {
+				return nil, fmt.Errorf("%s: %w", envName, err)
+			}
+		}
+	}
+	if len(store.keys) == 0 {
+		return nil, fmt.Errorf("no API keys configured")
+	}
+	return store, nil
+}
+
+// lookup 返回Key对应的API Key，Key无效时返回nil
+func (s *apiKeyStore) lookup(key string) *apiKey {
+	return s.keys[sha256.Sum256([]byte(key))]
+}
+
+// apiKeyFromRequest 从请求头或查询参数中取出API Key，并从请求中移除，不转发给后端
+func apiKeyFromRequest(r *http.Request, header, param string) string {
+	key := ""
+	if header != "" {
+		key = r.Header.Get(header)
+		r.Header.Del(header)
+	}
+	if param != "" {
+		if q := r.URL.Query(); q.Has(param) {
+			if key == "" {
+				key = q.Get(param)
+			}
+			q.Del(param)
+			r.URL.RawQuery = q.Encode()
+		}
+	}
+	return strings.TrimSpace(key)
+}
+
+// quotaTracker 按Key名称统计配额周期内的请求数。周期按UTC对齐，重新加载Key文件后计数保留
+type quotaTracker struct {
+	mu      sync.Mutex
+	windows map[string]*quotaWindow
+}
+
+type quotaWindow struct {
+	start time.Time
+	used  int64
+}
+
+func newQuotaTracker() *quotaTracker {
+	return &quotaTracker{windows: map[string]*quotaWindow{}}
+}
+
+// take 消耗一次配额，返回是否允许、剩余次数和距离下个周期的时间
+func (q *quotaTracker) take(k *apiKey, now time.Time) (bool, int64, time.Duration) {
+	start := now.Truncate(k.quotaPeriod)
+	reset := start.Add(k.quotaPeriod).Sub(now)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w, ok := q.windows[k.name]
+	if !ok || !w.start.Equal(start) {
+		w = &quotaWindow{start: start}
+		q.windows[k.name] = w
+	}
+	if w.used >= k.quota {
+		return false, 0, reset
+	}
+	w.used++
+	return true, k.quota - w.used, reset
+}
+
+// API Key检查结果，用于日志和指标
+const (
+	apiKeyMissing       = "missing"
+	apiKeyInvalid       = "invalid"
+	apiKeyAllowed       = "allowed"
+	apiKeyRateLimited   = "rate_limited"
+	apiKeyQuotaExceeded = "quota_exceeded"
+)
+
+// apiKeyAuth 校验请求的API Key，并按Key限速和限制配额
+type apiKeyAuth struct {
+	header     string // 携带Key的请求头，为空时不从请求头读取
+	param      string // 携带Key的查询参数，为空时不从查询参数读取
+	nameHeader string // 将Key名称转发给后端的请求头，为空时不转发
+	limiter    *rateLimiter
+	quotas     *quotaTracker
+	metrics    *proxyMetrics
+}
+
+// check 校验请求，不通过时写出401或429响应并返回false
+func (a *apiKeyAuth) check(w http.ResponseWriter, r *http.Request, store *apiKeyStore) bool {
+	info := getRequestInfo(r)
+	key := apiKeyFromRequest(r, a.header, a.param)
+	if a.nameHeader != "" {
+		r.Header.Del(a.nameHeader)
+	}
+	k := (*apiKey)(nil)
+	if key != "" && store != nil {
+		k = store.lookup(key)
+	}
+	result := apiKeyAllowed
+	var wait time.Duration
+	switch {
+	case key == "":
+		result = apiKeyMissing
+	case k == nil:
+		result = apiKeyInvalid
+	default:
+		now := time.Now()
+		if k.rate > 0 {
+			if ok, retry := a.limiter.store.take("apikey|"+k.name, k.rate, k.burst, now); !ok {
+				result, wait = apiKeyRateLimited, retry
+			}
+		}
+		if result == apiKeyAllowed && k.quota > 0 {
+			ok, remaining, reset := a.quotas.take(k, now)
+			w.Header().Set("X-Quota-Limit", fmt.Sprint(k.quota))
+			w.Header().Set("X-Quota-Remaining", fmt.Sprint(remaining))
+			if !ok {
+				result, wait = apiKeyQuotaExceeded, reset
+			}
+		}
+	}
+
+	name := ""
+	if k != nil {
+		name = k.name
+	}
+	if a.metrics != nil {
+		a.metrics.observeAPIKey(name, result)
+	}
+	switch result {
+	case apiKeyMissing, apiKeyInvalid:
+		info.log.Warnf("API key %s for %s %s from %s", result, r.Method, r.URL.Path, clientIP(r))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	case apiKeyRateLimited, apiKeyQuotaExceeded:
+		info.log.Warnf("API key %s %s, rejecting %s %s", name, strings.ReplaceAll(result, "_", " "), r.Method, r.URL.Path)
+		w.Header().Set("Retry-After", retryAfterSeconds(wait))
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return false
+	}
+	info.log.Infof("API key %s accepted", name)
+	if a.nameHeader != "" {
+		r.Header.Set(a.nameHeader, name)
+	}
+	return true
+}
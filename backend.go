@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// versionPlaceholder 后端地址中的版本号占位符
+const versionPlaceholder = "{version}"
+
+// expandBackendVersion 将后端地址中的{version}替换为指定版本号
+func expandBackendVersion(backends, version string) (string, error) {
+	if !strings.Contains(backends, versionPlaceholder) {
+		if version != "" {
+			return "", fmt.Errorf("-backend-version %q is set but backend URL %q has no %s placeholder", version, backends, versionPlaceholder)
+		}
+		return backends, nil
+	}
+	if version == "" {
+		return "", fmt.Errorf("backend URL %q contains %s but -backend-version is empty", backends, versionPlaceholder)
+	}
+	if strings.ContainsAny(version, "/?#") {
+		return "", fmt.Errorf("invalid backend version %q", version)
+	}
+	return strings.ReplaceAll(backends, versionPlaceholder, version), nil
+}
+
+// backendWeightSuffix 后端地址后的权重标记，如 https://a.example.com/;weight=3
+const backendWeightSuffix = ";weight="
+
+// parseBackends 解析逗号分隔的后端地址列表，并确保每个地址以斜杠结尾；
+// 地址后可用;weight=N指定weighted策略使用的权重，未指定时为1
+func parseBackends(list string) ([]*url.URL, []int, error) {
+	var backends []*url.URL
+	var weights []int
+	for _, raw := range strings.Split(list, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		weight := 1
+		if i := strings.LastIndex(raw, backendWeightSuffix); i >= 0 {
+			w, err := strconv.Atoi(raw[i+len(backendWeightSuffix):])
+			if err != nil || w <= 0 {
+				return nil, nil, fmt.Errorf("invalid backend weight in %q: must be a positive integer", raw)
+			}
+			raw, weight = raw[:i], w
+		}
+		if isDiscoveryBackend(raw) {
+			u, err := url.Parse(raw)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid backend URL %q: %w", raw, err)
+			}
+			if !strings.HasSuffix(u.Path, "/") {
+				u.Path += "/"
+			}
+			expanded, expandedWeights, err := discovery.expand(u, weight)
+			if err != nil {
+				return nil, nil, err
+			}
+			backends = append(backends, expanded...)
+			weights = append(weights, expandedWeights...)
+			continue
+		}
+		if strings.HasPrefix(raw, unixBackendPrefix) {
+			u, err := parseUnixBackend(raw)
+			if err != nil {
+				return nil, nil, err
+			}
+			backends = append(backends, u)
+			weights = append(weights, weight)
+			continue
+		}
+		if !strings.HasSuffix(raw, "/") {
+			raw = raw + "/"
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid backend URL %q: %w", raw, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, nil, fmt.Errorf("invalid backend URL %q: scheme and host are required", raw)
+		}
+		backends = append(backends, u)
+		weights = append(weights, weight)
+	}
+	if len(backends) == 0 {
+		return nil, nil, fmt.Errorf("no backend URL configured")
+	}
+	return backends, weights, nil
+}
+
+// joinURLs 将URL列表拼接为逗号分隔的字符串
+func joinURLs(urls []*url.URL) string {
+	parts := make([]string, len(urls))
+	for i, u := range urls {
+		parts[i] = u.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// hashRingReplicas 每个后端在一致性哈希环上的虚拟节点数
+const hashRingReplicas = 160
+
+// hashRing 后端一致性哈希环，增删后端时只有相邻区间的请求会被重新映射
+type hashRing struct {
+	points []uint32
+	owners map[uint32]int
+}
+
+func newHashRing(backends []*url.URL) *hashRing {
+	r := &hashRing{owners: make(map[uint32]int)}
+	for i, b := range backends {
+		for v := 0; v < hashRingReplicas; v++ {
+			point := crc32.ChecksumIEEE([]byte(b.String() + "#" + strconv.Itoa(v)))
+			if _, exists := r.owners[point]; exists {
+				continue
+			}
+			r.owners[point] = i
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// lookup 从key的哈希位置顺时针查找第一个健康的后端，没有可用后端时返回-1
+func (r *hashRing) lookup(key string, healthy func(int) bool) int {
+	if len(r.points) == 0 {
+		return -1
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	tried := map[int]bool{}
+	for i := 0; i < len(r.points); i++ {
+		owner := r.owners[r.points[(start+i)%len(r.points)]]
+		if tried[owner] {
+			continue
+		}
+		if healthy(owner) {
+			return owner
+		}
+		tried[owner] = true
+	}
+	return -1
+}
+
+// 负载均衡策略
+const (
+	lbFirst      = "first"       // 按配置顺序使用第一个健康的后端，其余作为故障转移
+	lbRoundRobin = "round-robin" // 依次轮流使用健康的后端
+	lbLeastConn  = "least-conn"  // 使用当前转发中请求数最少的健康后端
+	lbRandom     = "random"      // 随机选择健康的后端
+	lbWeighted   = "weighted"    // 按权重平滑轮询（同nginx的加权轮询）
+)
+
+// validateStrategy 校验负载均衡策略名称
+func validateStrategy(strategy string) error {
+	switch strategy {
+	case lbFirst, lbRoundRobin, lbLeastConn, lbRandom, lbWeighted:
+		return nil
+	}
+	return fmt.Errorf("unknown load balancing strategy %q, expected one of %s, %s, %s, %s, %s",
+		strategy, lbFirst, lbRoundRobin, lbLeastConn, lbRandom, lbWeighted)
+}
+
+// backendSelector 一条路由的后端池，为每个请求选择后端：
+// 配置了哈希请求头且请求携带该头时按一致性哈希选择；启用会话保持时按客户端IP的一致性哈希或会话Cookie选择；
+// 否则按负载均衡策略在健康的后端中选择
+type backendSelector struct {
+	backends   []*url.URL
+	weights    []int
+	strategy   string
+	ring       *hashRing
+	hashHeader string
+	healthy    func(*url.URL) bool
+	sticky     *stickySessions // 会话保持策略，为nil时不保持
+	stickyIDs  []string        // 每个后端在会话Cookie中的标识
+
+	next     atomic.Uint64  // round-robin的下一个位置
+	inflight []atomic.Int64 // 每个后端正在转发的请求数
+
+	mu             sync.Mutex
+	currentWeights []int // weighted策略的当前权重
+}
+
+func newBackendSelector(backends []*url.URL, weights []int, strategy, hashHeader string, healthy func(*url.URL) bool) *backendSelector {
+	s := &backendSelector{
+		backends:       backends,
+		weights:        weights,
+		strategy:       strategy,
+		hashHeader:     hashHeader,
+		healthy:        healthy,
+		inflight:       make([]atomic.Int64, len(backends)),
+		currentWeights: make([]int, len(backends)),
+	}
+	if hashHeader != "" {
+		s.ring = newHashRing(backends)
+	}
+	for _, b := range backends {
+		s.stickyIDs = append(s.stickyIDs, stickyID(b))
+	}
+	return s
+}
+
+// setSticky 设置会话保持策略，ip方式需要一致性哈希环
+func (s *backendSelector) setSticky(sticky *stickySessions) {
+	s.sticky = sticky
+	if sticky != nil && sticky.mode == stickyIP && s.ring == nil {
+		s.ring = newHashRing(s.backends)
+	}
+}
+
+// pick 返回本次请求使用的后端，所有后端都不健康时返回nil；
+// 返回的后端在请求结束后需调用release
+func (s *backendSelector) pick(r *http.Request) *url.URL {
+	healthy := func(i int) bool {
+		return s.healthy == nil || s.healthy(s.backends[i])
+	}
+
+	i := -1
+	key := s.hashKey(r)
+	switch {
+	case key != "":
+		i = s.ring.lookup(key, healthy)
+	case s.sticky != nil && s.sticky.mode == stickyIP:
+		i = s.ring.lookup(clientIP(r), healthy)
+	default:
+		// 会话Cookie指向的后端不可用时按策略重新选择，persist会签发新的Cookie
+		if s.sticky != nil {
+			i = s.stickyBackend(r, healthy)
+		}
+		if i < 0 {
+			i = s.pickByStrategy(healthy)
+		}
+	}
+	if i < 0 {
+		return nil
+	}
+	s.inflight[i].Add(1)
+	return s.backends[i]
+}
+
+// acquire 在指定后端健康时选中它，返回的后端同样需要release；后端不健康时返回nil
+func (s *backendSelector) acquire(backend *url.URL) *url.URL {
+	for i, b := range s.backends {
+		if b == backend {
+			if s.healthy != nil && !s.healthy(b) {
+				return nil
+			}
+			s.inflight[i].Add(1)
+			return b
+		}
+	}
+	return nil
+}
+
+// hashKey 返回一致性哈希使用的请求头值，未启用或请求不带该头时返回空字符串
+func (s *backendSelector) hashKey(r *http.Request) string {
+	if s.ring == nil || s.hashHeader == "" {
+		return ""
+	}
+	return r.Header.Get(s.hashHeader)
+}
+
+// pickByStrategy 按负载均衡策略选择健康的后端，没有可用后端时返回-1
+func (s *backendSelector) pickByStrategy(healthy func(int) bool) int {
+	n := len(s.backends)
+	switch s.strategy {
+	case lbRoundRobin:
+		start := int(s.next.Add(1)-1) % n
+		for k := 0; k < n; k++ {
+			if i := (start + k) % n; healthy(i) {
+				return i
+			}
+		}
+	case lbRandom:
+		start := rand.Intn(n)
+		for k := 0; k < n; k++ {
+			if i := (start + k) % n; healthy(i) {
+				return i
+			}
+		}
+	case lbLeastConn:
+		best := -1
+		for i := 0; i < n; i++ {
+			if healthy(i) && (best < 0 || s.inflight[i].Load() < s.inflight[best].Load()) {
+				best = i
+			}
+		}
+		return best
+	case lbWeighted:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		best, total := -1, 0
+		for i := 0; i < n; i++ {
+			if !healthy(i) {
+				continue
+			}
+			s.currentWeights[i] += s.weights[i]
+			total += s.weights[i]
+			if best < 0 || s.currentWeights[i] > s.currentWeights[best] {
+				best = i
+			}
+		}
+		if best >= 0 {
+			s.currentWeights[best] -= total
+		}
+		return best
+	default:
+		for i := 0; i < n; i++ {
+			if healthy(i) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// release 请求结束后减少后端的转发中请求数
+func (s *backendSelector) release(backend *url.URL) {
+	for i, b := range s.backends {
+		if b == backend {
+			s.inflight[i].Add(-1)
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// basicAuthConfig 路由配置中的HTTP Basic认证设置，未写出的字段使用-basic-auth-*参数
+type basicAuthConfig struct {
+	Disabled     bool              `json:"disabled,omitempty" yaml:"disabled"`           // 关闭该路由的Basic认证
+	Realm        string            `json:"realm,omitempty" yaml:"realm"`                 // WWW-Authenticate中的realm
+	HtpasswdFile string            `json:"htpasswd_file,omitempty" yaml:"htpasswd_file"` // htpasswd格式的用户文件，只支持bcrypt（htpasswd -B）
+	Users        map[string]string `json:"users,omitempty" yaml:"users"`                 // 用户名 -> bcrypt哈希，与htpasswd_file中的用户合并
+}
+
+// merge 返回用override中已设置的字段覆盖后的配置
+func (c basicAuthConfig) merge(override basicAuthConfig) basicAuthConfig {
+	c.Disabled = override.Disabled
+	if override.Realm != "" {
+		c.Realm = override.Realm
+	}
+	if override.HtpasswdFile != "" || override.Users != nil {
+		c.HtpasswdFile, c.Users = override.HtpasswdFile, override.Users
+	}
+	return c
+}
+
+// defaultBasicAuthConfig 由-basic-auth-*参数得到的Basic认证设置，路由的basic_auth配置在此基础上覆盖
+var defaultBasicAuthConfig basicAuthConfig
+
+// basicAuthCacheSize 缓存的已验证凭据数，避免每个请求都计算bcrypt
+const basicAuthCacheSize = 1024
+
+// basicAuthPolicy 一条路由生效的Basic认证策略
+type basicAuthPolicy struct {
+	realm string
+	users map[string][]byte // 用户名 -> bcrypt哈希
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]bool // 验证通过的用户名、密码和哈希的摘要
+}
+
+// dummyBcryptHash 用户不存在时参与比较的哈希，使响应时间不暴露用户是否存在
+var dummyBcryptHash, _ = bcrypt.GenerateFromPassword([]byte("st_proxy"), bcrypt.DefaultCost)
+
+// newBasicAuthPolicy 读取用户并创建认证策略，关闭或未配置任何用户来源时返回nil表示不认证
+func newBasicAuthPolicy(c basicAuthConfig) (*basicAuthPolicy, error) {
+	if c.Disabled || (c.HtpasswdFile == "" && c.Users == nil) {
+		return nil, nil
+	}
+	p := &basicAuthPolicy{realm: c.Realm, users: map[string][]byte{}, verified: map[[sha256.Size]byte]bool{}}
+	if p.realm == "" {
+		p.realm = "st_proxy"
+	}
+	add := func(user, hash string) error {
+		if user == "" || strings.Contains(user, ":") {
+			return fmt.Errorf("invalid user name %q", user)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("user %s: password must be a bcrypt hash: %w", user, err)
+		}
+		p.users[user] = []byte(hash)
+		return nil
+	}
+	if c.HtpasswdFile != "" {
+		data, err := os.ReadFile(c.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			user, hash, ok := strings.Cut(text, ":")
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: expected user:hash", c.HtpasswdFile, line)
+			}
+			if err := add(user, hash); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", c.HtpasswdFile, line, err)
+			}
+		}
+	}
+	for user, hash := range c.Users {
+		if err := add(user, hash); err != nil {
+			return nil, err
+		}
+	}
+	if len(p.users) == 0 {
+		return nil, fmt.Errorf("no users configured")
+	}
+	return p, nil
+}
+
+// authenticate 校验请求的Basic凭据，成功时返回用户名
+func (p *basicAuthPolicy) authenticate(r *http.Request) (string, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	hash, known := p.users[user]
+	if !known {
+		bcrypt.CompareHashAndPassword(dummyBcryptHash, []byte(password))
+		return "", false
+	}
+
+	sum := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + string(hash)))
+	p.mu.Lock()
+	cached := p.verified[sum]
+	p.mu.Unlock()
+	if cached {
+		return user, true
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return "", false
+	}
+	p.mu.Lock()
+	if len(p.verified) >= basicAuthCacheSize {
+		p.verified = map[[sha256.Size]byte]bool{}
+	}
+	p.verified[sum] = true
+	p.mu.Unlock()
+	return user, true
+}
+
+// challenge 返回WWW-Authenticate头的值
+func (p *basicAuthPolicy) challenge() string {
+	return fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", p.realm)
+}
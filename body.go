@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// bodyReadCloser 组合解码后的Reader和原始Body的关闭逻辑
+type bodyReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *bodyReadCloser) Close() error {
+	var firstErr error
+	for _, c := range b.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// hasResponseBody 判断响应是否携带响应体（HEAD、1xx、204、304均没有）
+func hasResponseBody(resp *http.Response) bool {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return false
+	}
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	switch {
+	case resp.StatusCode >= 100 && resp.StatusCode < 200:
+		return false
+	case resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// decompressResponse 透明解压gzip/deflate响应体，
+// 解压后移除Content-Encoding，并删除已失效的Content-Length改为分块传输
+func decompressResponse(resp *http.Response) error {
+	if !hasResponseBody(resp) {
+		return nil
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	var decoded io.ReadCloser
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to decode gzip response body: %w", err)
+		}
+		decoded = gz
+	case "deflate":
+		// HTTP规范中deflate为zlib格式，但部分服务器发送的是原始deflate数据
+		br := bufio.NewReader(resp.Body)
+		header, _ := br.Peek(2)
+		if isZlibHeader(header) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return fmt.Errorf("failed to decode deflate response body: %w", err)
+			}
+			decoded = zr
+		} else {
+			decoded = flate.NewReader(br)
+		}
+	default:
+		return nil
+	}
+
+	resp.Body = &bodyReadCloser{Reader: decoded, closers: []io.Closer{decoded, resp.Body}}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	getRequestInfo(resp.Request).log.Infof("Decompressed %s response body for %s", encoding, resp.Request.URL.Path)
+	return nil
+}
+
+// isZlibHeader 检查数据是否以合法的zlib头开始
+func isZlibHeader(b []byte) bool {
+	if len(b) < 2 {
+		return false
+	}
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// ndjsonArrayReader 将换行分隔的JSON记录流式转换为JSON数组：
+// 先输出"["，记录之间以","分隔，结束时输出"]"，不缓存整个响应体
+type ndjsonArrayReader struct {
+	src     *bufio.Reader
+	buf     []byte
+	started bool
+	records int
+	done    bool
+}
+
+func newNDJSONArrayReader(r io.Reader) *ndjsonArrayReader {
+	return &ndjsonArrayReader{src: bufio.NewReader(r)}
+}
+
+func (r *ndjsonArrayReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fill 读取下一条记录并写入待输出缓冲
+func (r *ndjsonArrayReader) fill() error {
+	if !r.started {
+		r.started = true
+		r.buf = append(r.buf, '[')
+		return nil
+	}
+
+	line, err := r.src.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if record := bytes.TrimSpace(line); len(record) > 0 {
+		if r.records > 0 {
+			r.buf = append(r.buf, ',')
+		}
+		r.buf = append(r.buf, record...)
+		r.records++
+	}
+	if err == io.EOF {
+		r.buf = append(r.buf, ']')
+		r.done = true
+	}
+	return nil
+}
+
+// matchContentType 判断响应的Content-Type（忽略参数）是否在列表中
+func matchContentType(header http.Header, types []string) bool {
+	mediaType, _, _ := strings.Cut(header.Get("Content-Type"), ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, t := range types {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// isEventStream 判断响应是否为Server-Sent Events事件流
+func isEventStream(header http.Header) bool {
+	return matchContentType(header, []string{"text/event-stream"})
+}
+
+// reframeNDJSON 将匹配类型的NDJSON响应体转换为JSON数组，已压缩的响应体不处理
+func reframeNDJSON(resp *http.Response, contentTypes []string) {
+	if !hasResponseBody(resp) || !matchContentType(resp.Header, contentTypes) {
+		return
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		getRequestInfo(resp.Request).log.Warnf("Skipping NDJSON reframing for %s: body is %s encoded", resp.Request.URL.Path, encoding)
+		return
+	}
+
+	resp.Body = &bodyReadCloser{Reader: newNDJSONArrayReader(resp.Body), closers: []io.Closer{resp.Body}}
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	getRequestInfo(resp.Request).log.Infof("Reframing NDJSON response body as JSON array for %s", resp.Request.URL.Path)
+}
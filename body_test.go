@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestNDJSONArrayReader(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty body", "", "[]"},
+		{"only blank lines", "\n\n  \n", "[]"},
+		{"single record", "{\"a\":1}\n", `[{"a":1}]`},
+		{"multiple records", "{\"a\":1}\n{\"b\":2}\n{\"c\":3}\n", `[{"a":1},{"b":2},{"c":3}]`},
+		{"blank lines between records", "\n{\"a\":1}\n\n\r\n{\"b\":2}\n\n", `[{"a":1},{"b":2}]`},
+		{"missing trailing newline", "{\"a\":1}\n{\"b\":2}", `[{"a":1},{"b":2}]`},
+		{"crlf line endings", "{\"a\":1}\r\n{\"b\":2}\r\n", `[{"a":1},{"b":2}]`},
+		{"scalar records", "1\n\"two\"\nnull\n", `[1,"two",null]`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// 逐字节读取源数据，同时以1字节的缓冲读取输出，覆盖记录跨多次Read的情况
+			out, err := io.ReadAll(iotest.OneByteReader(newNDJSONArrayReader(iotest.OneByteReader(strings.NewReader(tc.in)))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tc.want {
+				t.Fatalf("got %s, want %s", out, tc.want)
+			}
+			if !json.Valid(out) {
+				t.Fatalf("output is not valid JSON: %s", out)
+			}
+		})
+	}
+}
+
+func TestNDJSONArrayReaderSourceError(t *testing.T) {
+	r := newNDJSONArrayReader(iotest.TimeoutReader(strings.NewReader("{\"a\":1}\n{\"b\":2}\n")))
+	if _, err := io.ReadAll(r); err != iotest.ErrTimeout {
+		t.Fatalf("got error %v, want %v", err, iotest.ErrTimeout)
+	}
+}
+
+// TestNDJSONArrayReaderStreams 后端每隔一段时间写出一条记录：每条记录写出后客户端即可读到，不等整个响应体结束
+func TestNDJSONArrayReaderStreams(t *testing.T) {
+	src, backend := io.Pipe()
+	r := bufio.NewReader(newNDJSONArrayReader(src))
+
+	readUntil := func(delim byte) string {
+		t.Helper()
+		type result struct {
+			s   string
+			err error
+		}
+		ch := make(chan result, 1)
+		go func() {
+			s, err := r.ReadString(delim)
+			ch <- result{s, err}
+		}()
+		select {
+		case res := <-ch:
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			return res.s
+		case <-time.After(2 * time.Second):
+			t.Fatalf("record was not streamed before the next one was written")
+			return ""
+		}
+	}
+
+	go backend.Write([]byte("{\"seq\":1}\n"))
+	if got := readUntil('}'); got != `[{"seq":1}` {
+		t.Fatalf("first chunk = %q", got)
+	}
+	go backend.Write([]byte("{\"seq\":2}\n"))
+	if got := readUntil('}'); got != `,{"seq":2}` {
+		t.Fatalf("second chunk = %q", got)
+	}
+	backend.Close()
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "]" {
+		t.Fatalf("tail = %q, want \"]\"", rest)
+	}
+}
+
+func TestReframeNDJSON(t *testing.T) {
+	types := []string{"application/x-ndjson"}
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		encoding    string
+		method      string
+		reframed    bool
+	}{
+		{"matching type", "application/x-ndjson; charset=utf-8", "", http.MethodGet, true},
+		{"other type", "application/json", "", http.MethodGet, false},
+		{"compressed body", "application/x-ndjson", "gzip", http.MethodGet, false},
+		{"head request", "application/x-ndjson", "", http.MethodHead, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := "{\"a\":1}\n{\"b\":2}\n"
+			resp := &http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        http.Header{"Content-Type": {tc.contentType}, "Content-Length": {"16"}},
+				Body:          io.NopCloser(strings.NewReader(body)),
+				ContentLength: int64(len(body)),
+				Request:       httptest.NewRequest(tc.method, "/stream", nil),
+			}
+			if tc.encoding != "" {
+				resp.Header.Set("Content-Encoding", tc.encoding)
+			}
+			reframeNDJSON(resp, types)
+
+			out, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.reframed {
+				if string(out) != body || resp.Header.Get("Content-Type") != tc.contentType {
+					t.Fatalf("response changed: %s %q", resp.Header.Get("Content-Type"), out)
+				}
+				return
+			}
+			if string(out) != `[{"a":1},{"b":2}]` {
+				t.Fatalf("body = %s", out)
+			}
+			if got := resp.Header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			if resp.Header.Get("Content-Length") != "" || resp.ContentLength != -1 {
+				t.Errorf("Content-Length not cleared: %q %d", resp.Header.Get("Content-Length"), resp.ContentLength)
+			}
+		})
+	}
+}
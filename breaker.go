@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 熔断器状态
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// breakerSettings 熔断器参数
+type breakerSettings struct {
+	threshold int           // 连续失败多少次后打开，0表示不熔断
+	window    time.Duration // 连续失败的统计窗口
+	cooldown  time.Duration // 打开后直接拒绝请求的时间
+	probes    int           // 半开状态下连续成功多少个探测请求后关闭
+}
+
+// circuitBreaker 单个后端主机的熔断器
+type circuitBreaker struct {
+	host string
+	breakerSettings
+
+	mu          sync.Mutex
+	state       breakerState
+	failures    int
+	lastFailure time.Time
+	openedAt    time.Time
+	probing     bool
+	probeOK     int // 半开状态下已成功的探测请求数
+}
+
+// allow 判断当前请求是否可以发往后端
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		// 冷却期结束，进入半开状态，放行一个探测请求
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		b.probeOK = 0
+		return true
+	case breakerHalfOpen:
+		// 半开状态下同一时间只允许一个探测请求
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// available 判断后端当前是否可用（熔断器未打开或冷却期已过），不改变熔断器状态
+func (b *circuitBreaker) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state != breakerOpen || time.Since(b.openedAt) >= b.cooldown
+}
+
+// retryAfter 返回熔断器打开时距离下次探测的剩余时间
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if remaining := b.cooldown - time.Since(b.openedAt); b.state == breakerOpen && remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// currentState 返回熔断器当前状态
+func (b *circuitBreaker) currentState() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// success 记录一次成功的后端调用
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	if b.state == breakerHalfOpen {
+		// 连续成功足够多的探测请求后才关闭，期间继续逐个放行探测请求
+		if b.probeOK++; b.probeOK < b.probes {
+			return
+		}
+	}
+	if b.state != breakerClosed {
+		b.setState(breakerClosed)
+	}
+}
+
+// failure 记录一次失败的后端调用
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.probing = false
+
+	// 探测请求失败，重新打开熔断器
+	if b.state == breakerHalfOpen {
+		b.openedAt = now
+		b.setState(breakerOpen)
+		return
+	}
+
+	// 超出统计窗口的失败不再计为连续失败
+	if b.window > 0 && !b.lastFailure.IsZero() && now.Sub(b.lastFailure) > b.window {
+		b.failures = 0
+	}
+	b.failures++
+	b.lastFailure = now
+
+	if b.state == breakerClosed && b.threshold > 0 && b.failures >= b.threshold {
+		b.openedAt = now
+		b.setState(breakerOpen)
+	}
+}
+
+// abort 放弃本次调用结果（如客户端主动断开），不计入成功或失败
+func (b *circuitBreaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// breakerCall 一个请求在熔断器上放行的一次调用，结果只记录一次：
+// ModifyResponse已记录结果后即使再返回错误，ErrorHandler也不会重复计入失败
+type breakerCall struct {
+	breaker *circuitBreaker
+	done    bool
+}
+
+// success 记录调用成功，已记录过结果时忽略
+func (c *breakerCall) success() {
+	if c != nil && !c.done {
+		c.done = true
+		c.breaker.success()
+	}
+}
+
+// failure 记录调用失败，已记录过结果时忽略
+func (c *breakerCall) failure() {
+	if c != nil && !c.done {
+		c.done = true
+		c.breaker.failure()
+	}
+}
+
+// abort 放弃本次调用，已记录过结果时忽略
+func (c *breakerCall) abort() {
+	if c != nil && !c.done {
+		c.done = true
+		c.breaker.abort()
+	}
+}
+
+// setState 切换状态并记录日志，调用方需持有锁
+func (b *circuitBreaker) setState(state breakerState) {
+	logger.Warnf("Circuit breaker for %s: %s -> %s (consecutive failures: %d)", b.host, b.state, state, b.failures)
+	b.state = state
+}
+
+// breakerRegistry 按后端主机维护熔断器，保证多个后端互不影响
+type breakerRegistry struct {
+	defaults  breakerSettings
+	overrides map[string]breakerSettings // 按后端主机单独配置的参数
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newBreakerRegistry(defaults breakerSettings, overrides map[string]breakerSettings) *breakerRegistry {
+	return &breakerRegistry{
+		defaults:  defaults,
+		overrides: overrides,
+		breakers:  make(map[string]*circuitBreaker),
+	}
+}
+
+// get 返回指定主机的熔断器，不存在时创建
+func (r *breakerRegistry) get(host string) *circuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.breakers[host]
+	if !ok {
+		settings, ok := r.overrides[host]
+		if !ok {
+			settings = r.defaults
+		}
+		b = &circuitBreaker{host: host, breakerSettings: settings}
+		r.breakers[host] = b
+	}
+	return b
+}
+
+// retryAfter 返回一组后端中最早结束冷却的剩余时间，没有打开的熔断器时返回fallback
+func (r *breakerRegistry) retryAfter(backends []*url.URL, fallback time.Duration) time.Duration {
+	shortest := time.Duration(0)
+	for _, u := range backends {
+		if d := r.get(u.Host).retryAfter(); d > 0 && (shortest == 0 || d < shortest) {
+			shortest = d
+		}
+	}
+	if shortest == 0 {
+		return fallback
+	}
+	return shortest
+}
+
+// retryAfterSeconds 将等待时间格式化为Retry-After头的秒数，向上取整且至少为1
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(d.Seconds()))))
+}
+
+// parseBreakerOverrides 解析按后端主机设置的熔断参数，格式为 host;threshold=N;window=D;cooldown=D;probes=N，
+// host为后端地址中的主机和端口，未写出的参数沿用全局设置
+func parseBreakerOverrides(rules []string, defaults breakerSettings) (map[string]breakerSettings, error) {
+	overrides := map[string]breakerSettings{}
+	for _, rule := range rules {
+		parts := strings.Split(rule, ";")
+		host := strings.TrimSpace(parts[0])
+		if host == "" || strings.Contains(host, "/") {
+			return nil, fmt.Errorf("invalid breaker rule %q, expected host;key=value", rule)
+		}
+		if _, dup := overrides[host]; dup {
+			return nil, fmt.Errorf("duplicate breaker rule for %s", host)
+		}
+		settings := defaults
+		for _, part := range parts[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid breaker rule %q: %q is not key=value", rule, part)
+			}
+			var err error
+			switch key {
+			case "threshold":
+				settings.threshold, err = strconv.Atoi(value)
+			case "probes":
+				settings.probes, err = strconv.Atoi(value)
+			case "window":
+				settings.window, err = time.ParseDuration(value)
+			case "cooldown":
+				settings.cooldown, err = time.ParseDuration(value)
+			default:
+				return nil, fmt.Errorf("invalid breaker rule %q: unknown key %q", rule, key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid breaker rule %q: %s: %w", rule, key, err)
+			}
+		}
+		if err := settings.validate(); err != nil {
+			return nil, fmt.Errorf("invalid breaker rule %q: %w", rule, err)
+		}
+		overrides[host] = settings
+	}
+	return overrides, nil
+}
+
+// validate 检查熔断参数的取值范围
+func (s breakerSettings) validate() error {
+	if s.threshold < 0 {
+		return fmt.Errorf("threshold must not be negative")
+	}
+	if s.window < 0 || s.cooldown <= 0 {
+		return fmt.Errorf("window must not be negative and cooldown must be positive")
+	}
+	if s.probes < 1 {
+		return fmt.Errorf("probes must be at least 1")
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getStatus 经代理发出GET请求，返回状态码
+func getStatus(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestBreakerRecordsOutcomeOnce 后端返回5xx且响应解压失败：ModifyResponse已记录一次失败，
+// ErrorHandler不再重复计入，阈值为2时单个请求不会打开熔断器
+func TestBreakerRecordsOutcomeOnce(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "not gzip")
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	addr := startProxy(t, "-backend", backend.URL+"/", "-prefix", "/api/",
+		"-decompress-body", "-breaker-threshold", "2")
+
+	if got := getStatus(t, "http://"+addr+"/api/broken"); got != http.StatusBadGateway {
+		t.Fatalf("broken response: got %d, want %d", got, http.StatusBadGateway)
+	}
+	if got := getStatus(t, "http://"+addr+"/api/ok"); got != http.StatusOK {
+		t.Fatalf("after one failed request: got %d, want %d", got, http.StatusOK)
+	}
+}
+
+// breakerArgs 熔断器在一次失败后打开，冷却时间较短，便于测试半开状态
+var breakerArgs = []string{"-breaker-threshold", "1", "-breaker-cooldown", "200ms"}
+
+// newFlakyBackend 启动一个后端：/fail返回500，其他路径返回200
+func newFlakyBackend(t *testing.T) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// slowBackend 收到的请求一直挂起，直到调用release
+type slowBackend struct {
+	*httptest.Server
+	started chan struct{}
+	release chan struct{}
+}
+
+func newSlowBackend(t *testing.T) *slowBackend {
+	b := &slowBackend{started: make(chan struct{}, 1), release: make(chan struct{})}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.started <- struct{}{}
+		<-b.release
+		io.WriteString(w, "slow")
+	}))
+	t.Cleanup(func() {
+		// 测试中途失败时放行仍挂起的请求，避免Close一直等待
+		select {
+		case <-b.release:
+		default:
+			close(b.release)
+		}
+		b.Close()
+	})
+	return b
+}
+
+// openBreaker 经代理请求/api/fail打开熔断器，等待冷却结束进入半开状态
+func openBreaker(t *testing.T, addr string) {
+	t.Helper()
+	if got := getStatus(t, "http://"+addr+"/api/fail"); got != http.StatusInternalServerError {
+		t.Fatalf("failing request: got %d, want %d", got, http.StatusInternalServerError)
+	}
+	if got := getStatus(t, "http://"+addr+"/api/ok"); got != http.StatusServiceUnavailable {
+		t.Fatalf("breaker did not open: got %d, want %d", got, http.StatusServiceUnavailable)
+	}
+	time.Sleep(300 * time.Millisecond)
+}
+
+// testProbeRejectedWhileBusy 慢请求占满并发名额时，熔断器半开后放行的探测请求被拒绝：
+// 探测名额应被归还，慢请求结束后的下一个请求仍作为探测请求转发给后端
+func testProbeRejectedWhileBusy(t *testing.T, addr string, slow *slowBackend) {
+	openBreaker(t, addr)
+
+	done := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow/")
+		if err != nil {
+			done <- 0
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	<-slow.started
+
+	if got := getStatus(t, "http://"+addr+"/api/ok"); got != http.StatusServiceUnavailable {
+		t.Fatalf("probe while busy: got %d, want %d", got, http.StatusServiceUnavailable)
+	}
+	close(slow.release)
+	if got := <-done; got != http.StatusOK {
+		t.Fatalf("slow request: got %d, want %d", got, http.StatusOK)
+	}
+
+	if got := getStatus(t, "http://"+addr+"/api/ok"); got != http.StatusOK {
+		t.Fatalf("probe after rejection: got %d, want %d", got, http.StatusOK)
+	}
+}
+
+// TestBreakerProbeRejectedByLimiter 半开的探测请求被自适应并发限制拒绝后不会让熔断器一直拒绝请求
+func TestBreakerProbeRejectedByLimiter(t *testing.T) {
+	backend := newFlakyBackend(t)
+	slow := newSlowBackend(t)
+	args := append([]string{"-backend", backend.URL + "/", "-prefix", "/api/", "-route", "/slow/=" + slow.URL + "/",
+		"-adaptive-concurrency", "-adaptive-initial-limit", "1", "-adaptive-min-limit", "1", "-adaptive-max-limit", "1"}, breakerArgs...)
+	testProbeRejectedWhileBusy(t, startProxy(t, args...), slow)
+}
+
+// TestBreakerProbeRejectedByConcurrency 半开的探测请求因达到-max-concurrent被拒绝后不会让熔断器一直拒绝请求
+func TestBreakerProbeRejectedByConcurrency(t *testing.T) {
+	backend := newFlakyBackend(t)
+	slow := newSlowBackend(t)
+	args := append([]string{"-backend", backend.URL + "/", "-prefix", "/api/", "-route", "/slow/=" + slow.URL + "/",
+		"-max-concurrent", "1", "-max-queue", "0"}, breakerArgs...)
+	testProbeRejectedWhileBusy(t, startProxy(t, args...), slow)
+}
+
+// TestBreakerProbeFaultInjected 半开的探测请求命中故障注入直接返回错误后，下一个请求仍作为探测请求转发给后端
+func TestBreakerProbeFaultInjected(t *testing.T) {
+	backend := newFlakyBackend(t)
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	routes := "routes:\n" +
+		"  - prefix: /faulty/\n" +
+		"    backend: " + backend.URL + "/\n" +
+		"    fault:\n" +
+		"      error_percent: 100\n"
+	if err := os.WriteFile(config, []byte(routes), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args := append([]string{"-backend", backend.URL + "/", "-prefix", "/api/", "-config", config}, breakerArgs...)
+	addr := startProxy(t, args...)
+
+	openBreaker(t, addr)
+	if got := getStatus(t, "http://"+addr+"/faulty/ok"); got != http.StatusInternalServerError {
+		t.Fatalf("injected fault: got %d, want %d", got, http.StatusInternalServerError)
+	}
+	if got := getStatus(t, "http://"+addr+"/api/ok"); got != http.StatusOK {
+		t.Fatalf("probe after injected fault: got %d, want %d", got, http.StatusOK)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheJanitorInterval 清理过期缓存项的间隔
+const cacheJanitorInterval = time.Minute
+
+// cacheEntry 缓存的一个后端响应
+type cacheEntry struct {
+	key          string
+	base         string   // 基础缓存键，同一URL的各个Vary变体相同
+	vary         []string // 响应的Vary请求头
+	status       int
+	header       http.Header
+	body         []byte
+	stored       time.Time
+	expires      time.Time
+	etag         string
+	lastModified string
+}
+
+// fresh 判断缓存项是否仍在有效期内
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return now.Before(e.expires)
+}
+
+// revalidatable 判断过期后能否用条件请求向后端确认缓存项仍然有效
+func (e *cacheEntry) revalidatable() bool {
+	return e.etag != "" || e.lastModified != ""
+}
+
+// responseCache GET响应的内存缓存，按有效期过期并按最近最少使用淘汰。
+// 缓存键为路由+主机+方法+路径+查询参数，后端返回Vary时再加上对应请求头的值
+type responseCache struct {
+	maxEntries int
+	maxBody    int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List                // 表头为最近使用
+	vary    map[string]*cacheVariants // 基础缓存键对应的Vary请求头，该键的缓存项全部删除后移除
+}
+
+// cacheVariants 同一基础缓存键的Vary请求头（由最近一次写入的响应决定）及当前缓存的变体数
+type cacheVariants struct {
+	names []string
+	count int
+}
+
+func newResponseCache(maxEntries int, maxBody int64) *responseCache {
+	c := &responseCache{
+		maxEntries: maxEntries,
+		maxBody:    maxBody,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		vary:       make(map[string]*cacheVariants),
+	}
+	go c.janitor()
+	return c
+}
+
+// cacheBaseKey 基础缓存键：路由名称+主机+方法+路径+查询参数，
+// 不同虚拟主机、监听器和租户的相同路径不共用缓存项
+func cacheBaseKey(r *http.Request, rt *route) string {
+	return rt.name() + " " + normalizeHost(r.Host) + " " + r.Method + " " + r.URL.RequestURI()
+}
+
+// variantKey 在基础缓存键后加上Vary请求头的值
+func variantKey(base string, names []string, header http.Header) string {
+	if len(names) == 0 {
+		return base
+	}
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range names {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// key 返回请求的缓存键，按该URL最近一次响应的Vary取请求头的值
+func (c *responseCache) key(r *http.Request, rt *route) string {
+	base := cacheBaseKey(r, rt)
+	var names []string
+	c.mu.Lock()
+	if v := c.vary[base]; v != nil {
+		names = v.names
+	}
+	c.mu.Unlock()
+	return variantKey(base, names, r.Header)
+}
+
+// isCacheableRequest 只缓存不带Authorization的GET请求，协议升级请求不缓存
+func isCacheableRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.Header.Get("Authorization") == "" && !isUpgradeRequest(r)
+}
+
+// requestNoCache 判断客户端是否要求不使用未经确认的缓存（Cache-Control: no-cache或Pragma: no-cache）
+func requestNoCache(r *http.Request) bool {
+	return hasCacheDirective(r.Header, "no-cache") || strings.EqualFold(r.Header.Get("Pragma"), "no-cache")
+}
+
+// cacheDirectives 解析Cache-Control头，返回小写的指令名到取值的映射
+func cacheDirectives(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(strings.Join(header.Values("Cache-Control"), ","), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			directives[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return directives
+}
+
+func hasCacheDirective(header http.Header, name string) bool {
+	_, ok := cacheDirectives(header)[name]
+	return ok
+}
+
+// responseVary 返回响应的Vary请求头列表（规范化并排序），带Content-Encoding时总是包含Accept-Encoding；
+// Vary为*时返回false，表示响应不可缓存
+func responseVary(header http.Header) ([]string, bool) {
+	seen := map[string]bool{}
+	for _, part := range strings.Split(strings.Join(header.Values("Vary"), ","), ",") {
+		name := strings.TrimSpace(part)
+		if name == "*" {
+			return nil, false
+		}
+		if name != "" {
+			seen[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	if header.Get("Content-Encoding") != "" {
+		seen["Accept-Encoding"] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// freshnessLifetime 按后端的Cache-Control（s-maxage优先于max-age）或Expires确定有效期，
+// 都未声明时使用ttl；no-cache表示每次使用前都需要向后端确认，有效期为0
+func freshnessLifetime(header http.Header, ttl time.Duration) time.Duration {
+	directives := cacheDirectives(header)
+	if _, ok := directives["no-cache"]; ok {
+		return 0
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[name]; ok {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second
+			}
+			return 0
+		}
+	}
+	if value := header.Get("Expires"); value != "" {
+		expires, err := http.ParseTime(value)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		if lifetime := expires.Sub(date); lifetime > 0 {
+			return lifetime
+		}
+		return 0
+	}
+	return ttl
+}
+
+// isCacheableResponse 只缓存200响应，后端声明no-store/private、设置Cookie、返回事件流或Vary: *时不缓存；
+// 有效期为0（如no-cache）的响应只有带ETag或Last-Modified时才缓存，每次使用前向后端确认
+func isCacheableResponse(resp *http.Response, lifetime time.Duration) bool {
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	directives := cacheDirectives(resp.Header)
+	for _, name := range []string{"no-store", "private"} {
+		if _, ok := directives[name]; ok {
+			return false
+		}
+	}
+	if isEventStream(resp.Header) || len(resp.Header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	if _, ok := responseVary(resp.Header); !ok {
+		return false
+	}
+	return lifetime > 0 || resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// get 返回缓存项（可能已过期，由调用方决定直接使用还是向后端确认），并将其移到最近使用位置
+func (c *responseCache) get(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !entry.fresh(time.Now()) && !entry.revalidatable() {
+		c.removeElement(elem)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return entry
+}
+
+// set 写入缓存项，超出容量时淘汰最近最少使用的项
+func (c *responseCache) set(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v := c.vary[entry.base]
+	if v == nil {
+		v = &cacheVariants{}
+		c.vary[entry.base] = v
+	}
+	v.names = entry.vary
+
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	v.count++
+	for c.lru.Len() > c.maxEntries {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// removeElement 删除缓存项，该基础缓存键已没有缓存项时一并删除其Vary记录，调用方需持有锁
+func (c *responseCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	if v := c.vary[entry.base]; v != nil {
+		if v.count--; v.count <= 0 {
+			delete(c.vary, entry.base)
+		}
+	}
+}
+
+// janitor 定期清理已过期且无法向后端确认的缓存项，带ETag或Last-Modified的过期项保留到被LRU淘汰
+func (c *responseCache) janitor() {
+	ticker := time.NewTicker(cacheJanitorInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		c.mu.Lock()
+		for _, elem := range c.entries {
+			if entry := elem.Value.(*cacheEntry); !entry.fresh(now) && !entry.revalidatable() {
+				c.removeElement(elem)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// notModified 判断客户端的条件请求是否与缓存项匹配
+func (e *cacheEntry) notModified(r *http.Request) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if e.etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			if tag = strings.TrimSpace(tag); tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(e.etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && e.lastModified != "" {
+		since, err := http.ParseTime(ims)
+		modified, err2 := http.ParseTime(e.lastModified)
+		return err == nil && err2 == nil && !modified.After(since)
+	}
+	return false
+}
+
+// serve 将缓存的响应写给客户端，客户端的ETag或修改时间与缓存一致时返回304
+func (c *responseCache) serve(w http.ResponseWriter, r *http.Request, entry *cacheEntry) {
+	for name, values := range entry.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(entry.stored).Seconds())))
+	w.Header().Set("X-Cache", "HIT")
+	if entry.notModified(r) {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(entry.body)))
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
+
+// revalidated 后端对条件请求返回304时，用304响应中的新有效期刷新缓存项，
+// 并将响应改写为缓存的完整响应返回给客户端
+func (c *responseCache) revalidated(entry *cacheEntry, resp *http.Response, ttl time.Duration) {
+	now := time.Now()
+	header := entry.header.Clone()
+	for _, name := range []string{"Cache-Control", "Expires", "Date", "ETag", "Last-Modified"} {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	refreshed := *entry
+	refreshed.header = header
+	refreshed.stored = now
+	refreshed.expires = now.Add(freshnessLifetime(header, ttl))
+	c.set(&refreshed)
+
+	resp.StatusCode = entry.status
+	resp.Status = strconv.Itoa(entry.status) + " " + http.StatusText(entry.status)
+	resp.Header = header.Clone()
+	resp.Header.Set("Content-Length", strconv.Itoa(len(entry.body)))
+	resp.ContentLength = int64(len(entry.body))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(entry.body))
+}
+
+// capture 在响应体完整读完后写入缓存，缓存键由client请求头和响应的Vary决定；
+// 响应体超过上限或读取出错时放弃缓存，响应仍然以流式方式返回给客户端
+func (c *responseCache) capture(base string, client http.Header, resp *http.Response, lifetime time.Duration) {
+	if !hasResponseBody(resp) {
+		return
+	}
+	if resp.ContentLength > c.maxBody {
+		return
+	}
+	names, _ := responseVary(resp.Header)
+	key := variantKey(base, names, client)
+	header := resp.Header.Clone()
+	header.Del("X-Cache")
+	resp.Body = &cachingBody{
+		ReadCloser: resp.Body,
+		limit:      c.maxBody,
+		onComplete: func(body []byte) {
+			now := time.Now()
+			c.set(&cacheEntry{
+				key:          key,
+				base:         base,
+				vary:         names,
+				status:       resp.StatusCode,
+				header:       header,
+				body:         body,
+				stored:       now,
+				expires:      now.Add(lifetime),
+				etag:         header.Get("ETag"),
+				lastModified: header.Get("Last-Modified"),
+			})
+			getRequestInfo(resp.Request).log.Infof("Cached response for %s (%d bytes, ttl %s)", strings.ReplaceAll(key, "\n", " | "), len(body), lifetime)
+		},
+	}
+}
+
+// cachingBody 转发响应体的同时保存一份副本，读到EOF时回调
+type cachingBody struct {
+	io.ReadCloser
+	buf        bytes.Buffer
+	limit      int64
+	overflow   bool
+	onComplete func([]byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.overflow {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.overflow = true
+			b.buf.Reset()
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.overflow && b.onComplete != nil {
+		b.onComplete(b.buf.Bytes())
+		b.onComplete = nil
+	}
+	return n, err
+}
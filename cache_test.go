@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestResponseCacheDropsVary 某个URL的缓存项全部被淘汰后不再保留其Vary记录
+func TestResponseCacheDropsVary(t *testing.T) {
+	c := newResponseCache(2, 1024)
+	expires := time.Now().Add(time.Minute)
+	for _, e := range []*cacheEntry{
+		{key: "a\nAccept: text/html", base: "a", vary: []string{"Accept"}, expires: expires},
+		{key: "a\nAccept: application/json", base: "a", vary: []string{"Accept"}, expires: expires},
+		{key: "b", base: "b", expires: expires},
+	} {
+		c.set(e)
+	}
+	if v := c.vary["a"]; v == nil || v.count != 1 {
+		t.Fatalf("vary[a] = %+v, want one remaining variant", v)
+	}
+
+	c.set(&cacheEntry{key: "c", base: "c", expires: expires})
+	if _, ok := c.vary["a"]; ok {
+		t.Fatal("vary[a] kept after all its entries were evicted")
+	}
+	if len(c.vary) != 2 {
+		t.Fatalf("len(vary) = %d, want 2", len(c.vary))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net/http"
+	"net/url"
+)
+
+// canaryConfig 路由配置中的金丝雀发布设置：按比例将请求分给金丝雀后端，其余请求使用路由的backend
+type canaryConfig struct {
+	Backend string  `json:"backend" yaml:"backend"`         // 金丝雀后端，多个后端以逗号分隔，按路由的负载均衡策略选择
+	Percent float64 `json:"percent" yaml:"percent"`         // 分给金丝雀后端的请求百分比
+	Header  string  `json:"header,omitempty" yaml:"header"` // 按该请求头的值分流，同一值始终落在同一侧
+	Cookie  string  `json:"cookie,omitempty" yaml:"cookie"` // 按该Cookie的值分流，请求头和Cookie都配置时先看请求头
+}
+
+// canaryRelease 一条路由生效的金丝雀分流
+type canaryRelease struct {
+	backends []*url.URL
+	selector *backendSelector
+	percent  float64
+	header   string
+	cookie   string
+}
+
+// newCanaryRelease 创建金丝雀分流，金丝雀后端使用与路由相同的负载均衡策略
+func newCanaryRelease(c canaryConfig, strategy, hashHeader string, healthy func(*url.URL) bool) (*canaryRelease, error) {
+	if c.Percent < 0 || c.Percent > 100 {
+		return nil, fmt.Errorf("percent must be between 0 and 100")
+	}
+	backends, weights, err := parseBackends(c.Backend)
+	if err != nil {
+		return nil, err
+	}
+	return &canaryRelease{
+		backends: backends,
+		selector: newBackendSelector(backends, weights, strategy, hashHeader, healthy),
+		percent:  c.Percent,
+		header:   c.Header,
+		cookie:   c.Cookie,
+	}, nil
+}
+
+// choose 判断请求是否分给金丝雀后端。请求带有配置的请求头或Cookie时按其值的哈希分桶，
+// 同一用户始终落在同一侧，调整比例时只有边界上的用户换边；否则随机分流
+func (c *canaryRelease) choose(r *http.Request) bool {
+	key := ""
+	if c.header != "" {
+		key = r.Header.Get(c.header)
+	}
+	if key == "" && c.cookie != "" {
+		if cookie, err := r.Cookie(c.cookie); err == nil {
+			key = cookie.Value
+		}
+	}
+	if key == "" {
+		return rand.Float64()*100 < c.percent
+	}
+	return float64(crc32.ChecksumIEEE([]byte(key))%10000) < c.percent*100
+}
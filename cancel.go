@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statusClientClosedRequest 客户端在响应前断开或取消请求时记录的状态码（沿用nginx的499）
+const statusClientClosedRequest = 499
+
+// errRequestCancelled 请求被所属会话的取消请求终止
+var errRequestCancelled = errors.New("request cancelled by session cancel request")
+
+// inFlightRequest 一个正在转发的请求
+type inFlightRequest struct {
+	id      string
+	session string
+	route   string
+	method  string
+	path    string
+	start   time.Time
+	cancel  context.CancelCauseFunc
+}
+
+// sessionRegistry 按会话记录正在转发的请求，供取消接口终止同一会话的后端请求，
+// 如客户端停止生成时取消仍在进行的长对话请求
+type sessionRegistry struct {
+	header string // 标识会话的请求头，优先于cookie
+	cookie string // 标识会话的Cookie
+
+	mu       sync.Mutex
+	sessions map[string]map[*inFlightRequest]struct{}
+}
+
+func newSessionRegistry(header, cookie string) *sessionRegistry {
+	return &sessionRegistry{header: header, cookie: cookie, sessions: map[string]map[*inFlightRequest]struct{}{}}
+}
+
+// sessionID 返回请求所属的会话，未配置会话标识或请求没有携带时为空
+func (s *sessionRegistry) sessionID(r *http.Request) string {
+	if s.header != "" {
+		if id := r.Header.Get(s.header); id != "" {
+			return id
+		}
+	}
+	if s.cookie != "" {
+		if c, err := r.Cookie(s.cookie); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// track 为请求创建可取消的上下文，处理函数返回时调用release立即取消仍未结束的后端请求；
+// 请求属于某个会话时记录下来，直到release
+func (s *sessionRegistry) track(r *http.Request, info *requestInfo) (*http.Request, func()) {
+	ctx, cancel := context.WithCancelCause(r.Context())
+	r = r.WithContext(ctx)
+	session := s.sessionID(r)
+	if session == "" {
+		return r, func() { cancel(nil) }
+	}
+	req := &inFlightRequest{id: info.id, session: session, route: info.route.name(), method: r.Method, path: r.URL.Path, start: info.start, cancel: cancel}
+	s.mu.Lock()
+	if s.sessions[session] == nil {
+		s.sessions[session] = map[*inFlightRequest]struct{}{}
+	}
+	s.sessions[session][req] = struct{}{}
+	s.mu.Unlock()
+	return r, func() {
+		s.mu.Lock()
+		delete(s.sessions[session], req)
+		if len(s.sessions[session]) == 0 {
+			delete(s.sessions, session)
+		}
+		s.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancel 取消会话中正在转发的请求，requestID不为空时只取消该请求，返回被取消的请求ID
+func (s *sessionRegistry) cancel(session, requestID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []string{}
+	for req := range s.sessions[session] {
+		if requestID == "" || req.id == requestID {
+			req.cancel(errRequestCancelled)
+			ids = append(ids, req.id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// inFlightStatus 管理接口中单个正在转发的请求
+type inFlightStatus struct {
+	RequestID string `json:"request_id"`
+	Session   string `json:"session"`
+	Route     string `json:"route"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Started   string `json:"started"`
+}
+
+// list 返回属于会话的全部正在转发的请求，按开始时间排序
+func (s *sessionRegistry) list() []inFlightStatus {
+	s.mu.Lock()
+	var reqs []*inFlightRequest
+	for _, session := range s.sessions {
+		for req := range session {
+			reqs = append(reqs, req)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].start.Before(reqs[j].start) })
+	status := []inFlightStatus{}
+	for _, req := range reqs {
+		status = append(status, inFlightStatus{
+			RequestID: req.id,
+			Session:   req.session,
+			Route:     req.route,
+			Method:    req.method,
+			Path:      req.path,
+			Started:   req.start.Format(time.RFC3339),
+		})
+	}
+	return status
+}
+
+// serveCancel 由客户端取消自己会话中正在转发的请求（POST -cancel-path），会话取自该请求的会话头或Cookie，
+// 可用request_id参数只取消其中一个请求
+func (s *sessionRegistry) serveCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session := s.sessionID(r)
+	if session == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "missing session"})
+		return
+	}
+	ids := s.cancel(session, r.FormValue("request_id"))
+	if len(ids) > 0 {
+		logger.Infof("Cancelled %d in-flight requests of a session on request from %s: %v", len(ids), clientIP(r), ids)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"cancelled": ids})
+}
+
+// newCancelHandler 创建查看和取消会话中正在转发的请求的管理接口：GET列出请求，
+// POST /admin/cancel?session=ID[&request_id=ID] 取消该会话的全部或指定请求
+func newCancelHandler(token string, s *sessionRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminRequest(w, r, token, http.MethodGet, http.MethodPost) {
+			return
+		}
+		if r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"requests": s.list()})
+			return
+		}
+		session := r.FormValue("session")
+		if session == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "session is required"})
+			return
+		}
+		ids := s.cancel(session, r.FormValue("request_id"))
+		logger.Warnf("Cancelled %d in-flight requests of session %s by %s: %v", len(ids), session, clientIP(r), ids)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"cancelled": ids})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// bodyCapture 调试模式下记录请求和响应体的前若干字节，请求结束时输出到日志
+type bodyCapture struct {
+	max      int64
+	request  *captureReader
+	response *captureReader
+
+	requestType    string
+	responseType   string
+	responseEnc    string
+	responseHeader http.Header
+}
+
+// captureReader 在读取时保留前max字节，并统计总字节数
+type captureReader struct {
+	io.ReadCloser
+	max   int64
+	buf   bytes.Buffer
+	total int64
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		if room := c.max - int64(c.buf.Len()); room > 0 {
+			c.buf.Write(p[:min(int64(n), room)])
+		}
+		c.total += int64(n)
+	}
+	return n, err
+}
+
+// redactor 按名称隐藏日志中的敏感请求头和请求体字段
+type redactor struct {
+	headers map[string]bool
+	json    *regexp.Regexp // 匹配JSON中敏感字段的值
+	form    *regexp.Regexp // 匹配表单和查询串中敏感字段的值
+}
+
+// redactedValue 替换敏感值的占位符
+const redactedValue = "[REDACTED]"
+
+// newRedactor 创建redactor，字段名不区分大小写
+func newRedactor(headers, fields []string) *redactor {
+	r := &redactor{headers: map[string]bool{}}
+	for _, h := range headers {
+		r.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	if len(fields) > 0 {
+		quoted := make([]string, len(fields))
+		for i, f := range fields {
+			quoted[i] = regexp.QuoteMeta(f)
+		}
+		names := strings.Join(quoted, "|")
+		r.json = regexp.MustCompile(`(?i)("(?:` + names + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+		r.form = regexp.MustCompile(`(?i)((?:^|&)(?:` + names + `)=)([^&]*)`)
+	}
+	return r
+}
+
+// header 返回隐藏了敏感值的请求头副本
+func (r *redactor) header(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for name, values := range h {
+		if r.headers[name] {
+			out[name] = []string{redactedValue}
+			continue
+		}
+		out[name] = values
+	}
+	return out
+}
+
+// value 返回日志中一个请求头或响应头的值，敏感头返回占位符
+func (r *redactor) value(name, value string) string {
+	if r.headers[http.CanonicalHeaderKey(name)] {
+		return redactedValue
+	}
+	return value
+}
+
+// setCookie 返回日志中的Set-Cookie值，Set-Cookie为敏感头时只隐藏Cookie的值，保留名称和属性便于排查
+func (r *redactor) setCookie(value string) string {
+	if !r.headers["Set-Cookie"] {
+		return value
+	}
+	pair, attrs, _ := strings.Cut(value, ";")
+	name, _, _ := strings.Cut(pair, "=")
+	if attrs != "" {
+		attrs = ";" + attrs
+	}
+	return strings.TrimSpace(name) + "=" + redactedValue + attrs
+}
+
+// body 隐藏JSON和表单请求体中敏感字段的值，按文本匹配，截断的请求体同样适用
+func (r *redactor) body(contentType string, body string) string {
+	if r.json == nil {
+		return body
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		return r.form.ReplaceAllString(body, "${1}"+redactedValue)
+	}
+	return r.json.ReplaceAllString(body, `${1}"`+redactedValue+`"`)
+}
+
+// url 返回日志中的URL，隐藏查询参数中敏感字段的值
+func (r *redactor) url(u *url.URL) string {
+	if r.form == nil || u.RawQuery == "" {
+		return u.String()
+	}
+	masked := *u
+	masked.RawQuery = r.form.ReplaceAllString(u.RawQuery, "${1}"+redactedValue)
+	return masked.String()
+}
+
+// isTextContent 判断Content-Type是否为可直接写入日志的文本
+func isTextContent(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType == ""
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") || strings.HasSuffix(mediaType, "xml") ||
+		mediaType == "application/x-www-form-urlencoded" || mediaType == "application/x-ndjson" || mediaType == "application/javascript"
+}
+
+// captureRequest 包装请求体，在转发时记录
+func (c *bodyCapture) captureRequest(r *http.Request) {
+	c.requestType = r.Header.Get("Content-Type")
+	if r.Body != nil && r.Body != http.NoBody {
+		c.request = &captureReader{ReadCloser: r.Body, max: c.max}
+		r.Body = c.request
+	}
+}
+
+// captureResponse 包装后端响应体，在转发给客户端时记录
+func (c *bodyCapture) captureResponse(resp *http.Response) {
+	c.responseHeader = resp.Header
+	c.responseType = resp.Header.Get("Content-Type")
+	c.responseEnc = resp.Header.Get("Content-Encoding")
+	if resp.Body != nil && resp.Body != http.NoBody {
+		c.response = &captureReader{ReadCloser: resp.Body, max: c.max}
+		resp.Body = c.response
+	}
+}
+
+// describe 返回写入日志的请求体内容，非文本或压缩的内容只记录长度
+func (c *bodyCapture) describe(cr *captureReader, contentType, encoding string, rd *redactor) string {
+	switch {
+	case cr == nil || cr.total == 0:
+		return "(empty)"
+	case encoding != "" && encoding != "identity":
+		return fmt.Sprintf("(%d bytes %s-encoded, enable -decompress-body to capture)", cr.total, encoding)
+	case !isTextContent(contentType):
+		return fmt.Sprintf("(%d bytes of %s)", cr.total, contentType)
+	}
+	// 截断处可能落在多字节字符中间，丢弃不完整的字节
+	body := rd.body(contentType, strings.ToValidUTF8(cr.buf.String(), ""))
+	if cr.total > int64(cr.buf.Len()) {
+		body += fmt.Sprintf("... (%d bytes total)", cr.total)
+	}
+	return body
+}
+
+// log 输出记录的请求和响应体，请求头按redactor隐藏敏感值
+func (c *bodyCapture) log(info *requestInfo, r *http.Request, rd *redactor) {
+	info.log.WithFields(logrus.Fields{
+		"capture": "request",
+		"headers": rd.header(r.Header),
+		"bytes":   capturedBytes(c.request),
+	}).Infof("Captured request body: %s", c.describe(c.request, c.requestType, "", rd))
+	if c.responseHeader != nil {
+		info.log.WithFields(logrus.Fields{
+			"capture": "response",
+			"status":  info.upstreamStatus,
+			"headers": rd.header(c.responseHeader),
+			"bytes":   capturedBytes(c.response),
+		}).Infof("Captured response body: %s", c.describe(c.response, c.responseType, c.responseEnc, rd))
+	}
+}
+
+func capturedBytes(cr *captureReader) int64 {
+	if cr == nil {
+		return 0
+	}
+	return cr.total
+}
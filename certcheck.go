@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"time"
+)
+
+// certMonitor 定期连接各HTTPS后端，检查证书有效期并在临近过期时告警
+type certMonitor struct {
+	backends  func() []*url.URL
+	tlsConfig *tls.Config
+	interval  time.Duration
+	warning   time.Duration
+	metrics   *proxyMetrics // 不为nil时导出证书过期时间指标
+}
+
+func newCertMonitor(backends func() []*url.URL, tlsConfig *tls.Config, interval, warning time.Duration, metrics *proxyMetrics) *certMonitor {
+	return &certMonitor{
+		backends:  backends,
+		tlsConfig: tlsConfig,
+		interval:  interval,
+		warning:   warning,
+		metrics:   metrics,
+	}
+}
+
+// run 立即检查一次，之后按间隔周期检查
+func (m *certMonitor) run() {
+	m.checkAll()
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.checkAll()
+	}
+}
+
+func (m *certMonitor) checkAll() {
+	checked := map[string]bool{}
+	for _, b := range m.backends() {
+		if b.Scheme != "https" || checked[b.Host] {
+			continue
+		}
+		checked[b.Host] = true
+		m.check(b)
+	}
+}
+
+// check 与后端完成TLS握手，按证书链中最早的过期时间告警
+func (m *certMonitor) check(backend *url.URL) {
+	addr := backend.Host
+	if backend.Port() == "" {
+		addr = net.JoinHostPort(backend.Hostname(), "443")
+	}
+
+	config := m.tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = backend.Hostname()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	raw, err := backendDial(&net.Dialer{Timeout: 10 * time.Second})(ctx, "tcp", addr)
+	if err != nil {
+		logger.Warnf("Certificate check for %s failed: %v", backend.Host, err)
+		return
+	}
+	defer raw.Close()
+	conn := tls.Client(raw, config)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		logger.Warnf("Certificate check for %s failed: %v", backend.Host, err)
+		return
+	}
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		logger.Warnf("Certificate check for %s: no peer certificates", backend.Host)
+		return
+	}
+	earliest := certs[0]
+	for _, cert := range certs[1:] {
+		if cert.NotAfter.Before(earliest.NotAfter) {
+			earliest = cert
+		}
+	}
+
+	if m.metrics != nil {
+		m.metrics.setCertExpiry(backend.Host, earliest.NotAfter)
+	}
+
+	remaining := time.Until(earliest.NotAfter)
+	switch {
+	case remaining <= 0:
+		logger.Errorf("Backend certificate for %s (%s) expired at %s", backend.Host, earliest.Subject.CommonName, earliest.NotAfter.Format(time.RFC3339))
+	case remaining <= m.warning:
+		logger.Warnf("Backend certificate for %s (%s) expires in %s at %s", backend.Host, earliest.Subject.CommonName, remaining.Round(time.Hour), earliest.NotAfter.Format(time.RFC3339))
+	default:
+		logger.Infof("Backend certificate for %s expires at %s", backend.Host, earliest.NotAfter.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// clientCertVerifier 在HTTPS监听器上要求并校验客户端证书（双向TLS），
+// CA和吊销列表收到SIGHUP时重新读取，无需重启即可更换
+type clientCertVerifier struct {
+	caFile   string
+	crlFile  string
+	patterns []*regexp.Regexp // 允许的证书身份，为空时接受CA签发的任意证书
+
+	mu      sync.RWMutex
+	pool    *x509.CertPool
+	revoked map[string]bool // 已吊销的证书，键为 签发者DN|序列号
+}
+
+func newClientCertVerifier(caFile, crlFile string, allowed []string) (*clientCertVerifier, error) {
+	v := &clientCertVerifier{caFile: caFile, crlFile: crlFile}
+	for _, pattern := range allowed {
+		// 除*匹配任意字符外按字面匹配
+		re, err := regexp.Compile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid subject pattern %q: %w", pattern, err)
+		}
+		v.patterns = append(v.patterns, re)
+	}
+	if err := v.reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// reload 重新读取CA证书和吊销列表，失败时继续使用原来的设置
+func (v *clientCertVerifier) reload() error {
+	data, err := os.ReadFile(v.caFile)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	var cas []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse client CA file %s: %w", v.caFile, err)
+		}
+		pool.AddCert(ca)
+		cas = append(cas, ca)
+	}
+	if len(cas) == 0 {
+		return fmt.Errorf("no certificates found in client CA file %s", v.caFile)
+	}
+
+	revoked := map[string]bool{}
+	if v.crlFile != "" {
+		if revoked, err = loadRevocationLists(v.crlFile, cas); err != nil {
+			return err
+		}
+	}
+
+	v.mu.Lock()
+	v.pool, v.revoked = pool, revoked
+	v.mu.Unlock()
+	logger.Infof("Loaded client CA %s (%d certificates, %d revoked)", v.caFile, len(cas), len(revoked))
+	return nil
+}
+
+// loadRevocationLists 读取PEM或DER格式的吊销列表，只接受由CA文件中的证书签名的列表
+func loadRevocationLists(path string, cas []*x509.Certificate) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL file: %w", err)
+	}
+	var ders [][]byte
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = [][]byte{data}
+	}
+
+	revoked := map[string]bool{}
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CRL file %s: %w", path, err)
+		}
+		var signed bool
+		for _, ca := range cas {
+			if crl.CheckSignatureFrom(ca) == nil {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			return nil, fmt.Errorf("CRL in %s (issuer: %s) is not signed by any certificate in the client CA file", path, crl.Issuer)
+		}
+		if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+			logger.Warnf("CRL in %s (issuer: %s) expired at %s, update it to keep revocation current", path, crl.Issuer, crl.NextUpdate.Format(time.RFC3339))
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			revoked[string(crl.RawIssuer)+"|"+entry.SerialNumber.String()] = true
+		}
+	}
+	return revoked, nil
+}
+
+// apply 在监听器的TLS设置中启用客户端证书校验。ACME的TLS-ALPN-01验证连接不携带客户端证书，不做校验
+func (v *clientCertVerifier) apply(config *tls.Config) {
+	config.ClientAuth = tls.RequestClientCert
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if cs.NegotiatedProtocol == acme.ALPNProto {
+			return nil
+		}
+		return v.verify(cs.PeerCertificates)
+	}
+}
+
+// verify 校验客户端证书链、吊销状态和证书身份
+func (v *clientCertVerifier) verify(certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return errors.New("client certificate required")
+	}
+	v.mu.RLock()
+	pool, revoked := v.pool, v.revoked
+	v.mu.RUnlock()
+
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("client certificate %q rejected: %w", leaf.Subject, err)
+	}
+	for _, cert := range chains[0] {
+		if revoked[string(cert.RawIssuer)+"|"+cert.SerialNumber.String()] {
+			return fmt.Errorf("client certificate %q rejected: certificate %q (serial %s) is revoked", leaf.Subject, cert.Subject, cert.SerialNumber)
+		}
+	}
+	if len(v.patterns) > 0 && !v.allowed(leaf) {
+		return fmt.Errorf("client certificate %q rejected: subject not allowed", leaf.Subject)
+	}
+	return nil
+}
+
+// allowed 证书的CN或任一SAN（DNS、邮箱、URI）匹配允许的模式时返回true，不区分大小写
+func (v *clientCertVerifier) allowed(cert *x509.Certificate) bool {
+	for _, identity := range certIdentities(cert) {
+		identity = strings.ToLower(identity)
+		for _, re := range v.patterns {
+			if re.MatchString(identity) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// certIdentities 返回证书的CN和全部SAN
+func certIdentities(cert *x509.Certificate) []string {
+	if cert.Subject.CommonName == "" {
+		return certSANs(cert)
+	}
+	return append([]string{cert.Subject.CommonName}, certSANs(cert)...)
+}
+
+// certSANs 返回证书的DNS、邮箱和URI类型的SAN
+func certSANs(cert *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
+// applyClientCertHeaders 移除客户端自行携带的以prefix开头的请求头，防止伪造身份，
+// 再按校验通过的客户端证书设置身份头转发给后端
+func applyClientCertHeaders(req *http.Request, prefix string) {
+	canonical := http.CanonicalHeaderKey(prefix)
+	for name := range req.Header {
+		if strings.HasPrefix(name, canonical) {
+			req.Header.Del(name)
+		}
+	}
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return
+	}
+	cert := req.TLS.PeerCertificates[0]
+	fingerprint := sha256.Sum256(cert.Raw)
+	req.Header.Set(prefix+"Subject", cert.Subject.String())
+	req.Header.Set(prefix+"Issuer", cert.Issuer.String())
+	req.Header.Set(prefix+"Serial", cert.SerialNumber.Text(16))
+	req.Header.Set(prefix+"Fingerprint", hex.EncodeToString(fingerprint[:]))
+	req.Header.Set(prefix+"Not-After", cert.NotAfter.UTC().Format(time.RFC3339))
+	if sans := certSANs(cert); len(sans) > 0 {
+		req.Header.Set(prefix+"SAN", strings.Join(sans, ","))
+	}
+}
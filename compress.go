@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// 支持的响应压缩算法
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// compressor 按客户端的Accept-Encoding压缩未压缩的文本响应
+type compressor struct {
+	minSize int64
+	types   []string // 允许压缩的Content-Type，支持 text/* 形式的通配
+
+	gzipPool   sync.Pool
+	brotliPool sync.Pool
+}
+
+func newCompressor(minSize int64, types []string) *compressor {
+	c := &compressor{minSize: minSize, types: types}
+	c.gzipPool.New = func() interface{} { return gzip.NewWriter(io.Discard) }
+	c.brotliPool.New = func() interface{} { return brotli.NewWriter(io.Discard) }
+	return c
+}
+
+// negotiateEncoding 按Accept-Encoding选择压缩算法，权重相同时优先brotli，客户端都不接受时返回空
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if name == "*" {
+			name = encodingBrotli
+		}
+		if (name != encodingBrotli && name != encodingGzip) || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == encodingBrotli) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressibleType 判断Content-Type是否在允许压缩的列表中；事件流逐条推送，不压缩
+func (c *compressor) compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	for _, t := range c.types {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap 为请求包装ResponseWriter，encoding为空表示客户端不接受压缩，此时只补充Vary头
+func (c *compressor) wrap(w http.ResponseWriter, r *http.Request) *compressWriter {
+	encoding := ""
+	if r.Method != http.MethodHead {
+		encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	}
+	return &compressWriter{ResponseWriter: w, c: c, encoding: encoding}
+}
+
+// compressWriter 在写出响应头时决定是否压缩，压缩后去掉Content-Length并将ETag改为弱校验
+type compressWriter struct {
+	http.ResponseWriter
+	c        *compressor
+	encoding string
+
+	decided bool
+	enc     io.WriteCloser
+}
+
+// shouldCompress 判断是否压缩即将写出的响应：必须是允许的类型、未被压缩过、
+// 未声明no-transform、不是部分内容且长度已知时不小于下限
+func (w *compressWriter) shouldCompress(code int) bool {
+	h := w.Header()
+	if code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" || !w.c.compressibleType(h.Get("Content-Type")) {
+		return false
+	}
+	if hasCacheDirective(h, "no-transform") {
+		return false
+	}
+	// 可压缩的响应内容随Accept-Encoding变化，下游缓存需要区分
+	h.Add("Vary", "Accept-Encoding")
+	if w.encoding == "" {
+		return false
+	}
+	if length, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil && length < w.c.minSize {
+		return false
+	}
+	return true
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	// 1xx为临时响应，在最终响应时再决定
+	if code < 200 || w.decided {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.decided = true
+	if w.shouldCompress(code) {
+		h := w.Header()
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		switch w.encoding {
+		case encodingBrotli:
+			bw := w.c.brotliPool.Get().(*brotli.Writer)
+			bw.Reset(w.ResponseWriter)
+			w.enc = bw
+		case encodingGzip:
+			gw := w.c.gzipPool.Get().(*gzip.Writer)
+			gw.Reset(w.ResponseWriter)
+			w.enc = gw
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush 先刷新压缩器中缓冲的数据，保证流式响应及时送达客户端
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch enc := w.enc.(type) {
+	case *gzip.Writer:
+		enc.Flush()
+	case *brotli.Writer:
+		enc.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack 协议升级时直接交出底层连接，不做压缩
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap 供http.ResponseController访问底层ResponseWriter
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close 写出压缩数据的结尾并归还压缩器，请求处理结束时调用
+func (w *compressWriter) Close() {
+	if w.enc == nil {
+		return
+	}
+	w.enc.Close()
+	switch enc := w.enc.(type) {
+	case *gzip.Writer:
+		enc.Reset(io.Discard)
+		w.c.gzipPool.Put(enc)
+	case *brotli.Writer:
+		enc.Reset(io.Discard)
+		w.c.brotliPool.Put(enc)
+	}
+	w.enc = nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// concurrencyLimiter 基于缓冲channel的并发信号量，限制同时转发到后端的请求数。
+// 达到上限时请求可在有界队列中等待空闲名额，队列已满或等待超时则被拒绝
+type concurrencyLimiter struct {
+	slots   chan struct{}
+	queue   chan struct{}
+	timeout time.Duration
+}
+
+func newConcurrencyLimiter(maxConcurrent, maxQueue int, timeout time.Duration) *concurrencyLimiter {
+	l := &concurrencyLimiter{
+		slots:   make(chan struct{}, maxConcurrent),
+		timeout: timeout,
+	}
+	if maxQueue > 0 {
+		l.queue = make(chan struct{}, maxQueue)
+	}
+	return l
+}
+
+// acquire 获取一个并发名额，队列已满、等待超时或请求被取消时返回false
+func (l *concurrencyLimiter) acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if l.queue == nil {
+		return false
+	}
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-l.queue }()
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release 释放一个并发名额
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+}
+
+// inFlight 返回当前正在转发的请求数
+func (l *concurrencyLimiter) inFlight() int {
+	return len(l.slots)
+}
+
+// queued 返回当前排队等待的请求数
+func (l *concurrencyLimiter) queued() int {
+	return len(l.queue)
+}
+
+// backendLimitSettings 单个后端的并发限制参数
+type backendLimitSettings struct {
+	maxConcurrent int           // 同时转发到该后端的最大请求数，0表示不限制
+	maxQueue      int           // 达到上限后允许排队的请求数
+	timeout       time.Duration // 排队等待的最长时间
+}
+
+// backendLimiters 按后端主机维护并发限制，重新加载路由后同一主机沿用原来的计数
+type backendLimiters struct {
+	defaults  backendLimitSettings
+	overrides map[string]backendLimitSettings // 按后端主机单独配置的参数
+
+	mu       sync.Mutex
+	limiters map[string]*concurrencyLimiter
+}
+
+func newBackendLimiters(defaults backendLimitSettings, overrides map[string]backendLimitSettings) *backendLimiters {
+	return &backendLimiters{
+		defaults:  defaults,
+		overrides: overrides,
+		limiters:  make(map[string]*concurrencyLimiter),
+	}
+}
+
+// get 返回指定主机的并发限制，该主机不限制并发时返回nil
+func (l *backendLimiters) get(host string) *concurrencyLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.limiters[host]
+	if !ok {
+		settings, ok := l.overrides[host]
+		if !ok {
+			settings = l.defaults
+		}
+		if settings.maxConcurrent > 0 {
+			limiter = newConcurrencyLimiter(settings.maxConcurrent, settings.maxQueue, settings.timeout)
+		}
+		l.limiters[host] = limiter
+	}
+	return limiter
+}
+
+// parseBackendLimitOverrides 解析按后端主机设置的并发限制，格式为 host;max=N;queue=N;timeout=D，
+// host为后端地址中的主机和端口，未写出的参数沿用全局设置
+func parseBackendLimitOverrides(rules []string, defaults backendLimitSettings) (map[string]backendLimitSettings, error) {
+	overrides := map[string]backendLimitSettings{}
+	for _, rule := range rules {
+		parts := strings.Split(rule, ";")
+		host := strings.TrimSpace(parts[0])
+		if host == "" || strings.Contains(host, "/") {
+			return nil, fmt.Errorf("invalid backend concurrency rule %q, expected host;key=value", rule)
+		}
+		if _, dup := overrides[host]; dup {
+			return nil, fmt.Errorf("duplicate backend concurrency rule for %s", host)
+		}
+		settings := defaults
+		for _, part := range parts[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid backend concurrency rule %q: %q is not key=value", rule, part)
+			}
+			var err error
+			switch key {
+			case "max":
+				settings.maxConcurrent, err = strconv.Atoi(value)
+			case "queue":
+				settings.maxQueue, err = strconv.Atoi(value)
+			case "timeout":
+				settings.timeout, err = time.ParseDuration(value)
+			default:
+				return nil, fmt.Errorf("invalid backend concurrency rule %q: unknown key %q", rule, key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid backend concurrency rule %q: %s: %w", rule, key, err)
+			}
+		}
+		if err := settings.validate(); err != nil {
+			return nil, fmt.Errorf("invalid backend concurrency rule %q: %w", rule, err)
+		}
+		overrides[host] = settings
+	}
+	return overrides, nil
+}
+
+// validate 检查并发限制参数
+func (s backendLimitSettings) validate() error {
+	if s.maxConcurrent < 0 || s.maxQueue < 0 {
+		return fmt.Errorf("max and queue must not be negative")
+	}
+	if s.maxQueue > 0 && s.timeout <= 0 {
+		return fmt.Errorf("timeout must be positive when queue is set")
+	}
+	return nil
+}
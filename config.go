@@ -0,0 +1,260 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sourceConfig 参数值来自配置文件
+const sourceConfig = "config"
+
+// configRoutesKey 配置文件中路由列表的字段名，其余字段与命令行参数同名
+const configRoutesKey = "routes"
+
+// configTenantsKey 配置文件中租户列表的字段名
+const configTenantsKey = "tenants"
+
+// configError 带文件名、行号和字段名的配置错误
+type configError struct {
+	path  string
+	line  int
+	field string
+	msg   string
+}
+
+func (e *configError) Error() string {
+	if e.field == "" {
+		return fmt.Sprintf("%s:%d: %s", e.path, e.line, e.msg)
+	}
+	return fmt.Sprintf("%s:%d: field %q: %s", e.path, e.line, e.field, e.msg)
+}
+
+// readConfigFile 读取YAML或JSON格式的配置文件（JSON是YAML的子集），返回顶层映射节点
+func readConfigFile(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode}, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, &configError{path: path, line: root.Line, msg: "top level must be a mapping"}
+	}
+	return root, nil
+}
+
+// applyConfigFile 将配置文件中的值应用到仍为默认值的参数上，
+// 因此优先级为：命令行参数 > 环境变量 > 配置文件 > 默认值
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	root, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		name := key.Value
+		if name == configRoutesKey || name == configTenantsKey {
+			// 路由和租户在构建路由表时单独读取
+			continue
+		}
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			return &configError{path: path, line: key.Line, field: name, msg: "unknown field"}
+		}
+		if flagSources[name] != sourceDefault {
+			continue
+		}
+
+		switch value.Kind {
+		case yaml.ScalarNode:
+			if err := fs.Set(name, value.Value); err != nil {
+				return &configError{path: path, line: value.Line, field: name, msg: fmt.Sprintf("invalid value %q: %v", value.Value, err)}
+			}
+		case yaml.SequenceNode:
+			if _, repeatable := f.Value.(*stringSliceFlag); !repeatable {
+				return &configError{path: path, line: value.Line, field: name, msg: "expected a single value, got a list"}
+			}
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					return &configError{path: path, line: item.Line, field: name, msg: "list items must be scalar values"}
+				}
+				if err := fs.Set(name, item.Value); err != nil {
+					return &configError{path: path, line: item.Line, field: name, msg: fmt.Sprintf("invalid value %q: %v", item.Value, err)}
+				}
+			}
+		default:
+			return &configError{path: path, line: value.Line, field: name, msg: "expected a scalar value or a list"}
+		}
+		flagSources[name] = sourceConfig
+	}
+	return nil
+}
+
+// loadConfigDefaultRoute 重新读取配置文件中默认路由的prefix和backend，
+// 只替换来源为配置文件的参数，命令行参数和环境变量设置的取值保持不变
+func loadConfigDefaultRoute(path, prefix, backend string) (string, string, error) {
+	if flagSources["prefix"] != sourceConfig && flagSources["backend"] != sourceConfig {
+		return prefix, backend, nil
+	}
+	root, err := readConfigFile(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	values := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		values[root.Content[i].Value] = root.Content[i+1]
+	}
+	reload := func(name string, current *string) error {
+		if flagSources[name] != sourceConfig {
+			return nil
+		}
+		value := values[name]
+		if value == nil {
+			return &configError{path: path, line: root.Line, field: name, msg: "removed from config file, restart to use the default value"}
+		}
+		if value.Kind != yaml.ScalarNode || strings.TrimSpace(value.Value) == "" {
+			return &configError{path: path, line: value.Line, field: name, msg: "must not be empty"}
+		}
+		*current = strings.TrimSpace(value.Value)
+		return nil
+	}
+	if err := reload("prefix", &prefix); err != nil {
+		return "", "", err
+	}
+	if err := reload("backend", &backend); err != nil {
+		return "", "", err
+	}
+	return prefix, backend, nil
+}
+
+// loadConfigRoutes 读取配置文件中的路由列表
+func loadConfigRoutes(path string) ([]routeConfig, error) {
+	root, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != configRoutesKey {
+			continue
+		}
+		if value.Kind != yaml.SequenceNode {
+			return nil, &configError{path: path, line: value.Line, field: configRoutesKey, msg: "expected a list of routes"}
+		}
+		var routes []routeConfig
+		for idx, item := range value.Content {
+			field := fmt.Sprintf("%s[%d]", configRoutesKey, idx)
+			var rc routeConfig
+			if err := checkKnownFields(path, field, item, rc); err != nil {
+				return nil, err
+			}
+			if err := item.Decode(&rc); err != nil {
+				return nil, &configError{path: path, line: item.Line, field: field, msg: err.Error()}
+			}
+			if strings.TrimSpace(rc.Prefix) == "" && rc.Host == "" {
+				return nil, &configError{path: path, line: item.Line, field: field + ".prefix", msg: "must not be empty"}
+			}
+			if strings.TrimSpace(rc.Backend) == "" {
+				return nil, &configError{path: path, line: item.Line, field: field + ".backend", msg: "must not be empty"}
+			}
+			routes = append(routes, rc)
+		}
+		return routes, nil
+	}
+	return nil, nil
+}
+
+// loadConfigTenants 读取配置文件中的租户列表，租户的路由在expandTenants中校验
+func loadConfigTenants(path string) ([]tenantConfig, error) {
+	root, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != configTenantsKey {
+			continue
+		}
+		if value.Kind != yaml.SequenceNode {
+			return nil, &configError{path: path, line: value.Line, field: configTenantsKey, msg: "expected a list of tenants"}
+		}
+		var tenants []tenantConfig
+		for idx, item := range value.Content {
+			field := fmt.Sprintf("%s[%d]", configTenantsKey, idx)
+			if err := checkKnownFields(path, field, item, tenantConfig{}); err != nil {
+				return nil, err
+			}
+			// 租户的路由和API Key是列表，逐项检查字段
+			for j := 0; j+1 < len(item.Content); j += 2 {
+				name, list := item.Content[j].Value, item.Content[j+1]
+				if (name != "routes" && name != "api_keys") || list.Kind != yaml.SequenceNode {
+					continue
+				}
+				for k, entry := range list.Content {
+					var v interface{} = routeConfig{}
+					if name == "api_keys" {
+						v = apiKeyEntry{}
+					}
+					if err := checkKnownFields(path, fmt.Sprintf("%s.%s[%d]", field, name, k), entry, v); err != nil {
+						return nil, err
+					}
+				}
+			}
+			var tc tenantConfig
+			if err := item.Decode(&tc); err != nil {
+				return nil, &configError{path: path, line: item.Line, field: field, msg: err.Error()}
+			}
+			tenants = append(tenants, tc)
+		}
+		return tenants, nil
+	}
+	return nil, nil
+}
+
+// checkKnownFields 检查映射节点中的字段都在结构体的yaml标签中声明过，嵌套的结构体字段递归检查
+func checkKnownFields(path, field string, node *yaml.Node, v interface{}) error {
+	return checkKnownFieldsOf(path, field, node, reflect.TypeOf(v))
+}
+
+func checkKnownFieldsOf(path, field string, node *yaml.Node, t reflect.Type) error {
+	if node.Kind != yaml.MappingNode {
+		return &configError{path: path, line: node.Line, field: field, msg: "expected a mapping"}
+	}
+	known := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		if tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); tag != "" && tag != "-" {
+			known[tag] = t.Field(i).Type
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		ft, ok := known[key.Value]
+		if !ok {
+			return &configError{path: path, line: key.Line, field: field + "." + key.Value, msg: "unknown field"}
+		}
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct {
+			if err := checkKnownFieldsOf(path, field+"."+key.Value, value, ft); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+)
+
+// countingConn 记录单个后端连接已承载的请求数
+type countingConn struct {
+	net.Conn
+	requests atomic.Int64
+}
+
+// countingDialer 包装DialContext，使拨出的连接都带有请求计数
+func countingDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: conn}, nil
+	}
+}
+
+// unwrapCountingConn 从Transport交出的连接中找到countingConn（TLS连接需先解包）
+func unwrapCountingConn(conn net.Conn) *countingConn {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	c, _ := conn.(*countingConn)
+	return c
+}
+
+// maxRequestsTransport 限制每个后端连接最多承载的请求数，
+// 达到上限的请求以Close发出（带"Connection: close"），由后端响应后关闭连接，
+// 从而强制定期重新建立连接（如后端VIP轮换时）。仅对HTTP/1.x连接生效。
+type maxRequestsTransport struct {
+	base        http.RoundTripper
+	maxRequests int64
+}
+
+func (t *maxRequestsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// 只修改本次发出的请求副本，不改动调用方的请求，重试时的新副本也不带上Close
+	var out *http.Request
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c := unwrapCountingConn(info.Conn)
+			if c == nil {
+				return
+			}
+			// 协议升级请求需要保留Connection: Upgrade，升级后的连接不再复用
+			if n := c.requests.Add(1); n >= t.maxRequests && !isUpgradeRequest(req) {
+				logger.Infof("Backend connection %s reached %d requests, closing after this response", c.RemoteAddr(), n)
+				out.Close = true
+			}
+		},
+	}
+	out = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return t.base.RoundTrip(out)
+}
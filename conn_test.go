@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestMaxRequestsTransport 每个后端连接承载maxRequests个请求后关闭，调用方的请求不被修改
+func TestMaxRequestsTransport(t *testing.T) {
+	var mu sync.Mutex
+	conns := map[string]int{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns[r.RemoteAddr]++
+		mu.Unlock()
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	base := &http.Transport{DialContext: countingDialer((&net.Dialer{}).DialContext)}
+	defer base.CloseIdleConnections()
+	rt := &maxRequestsTransport{base: base, maxRequests: 3}
+
+	for i := 0; i < 7; i++ {
+		req, err := http.NewRequest(http.MethodGet, backend.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if req.Close || req.Header.Get("Connection") != "" {
+			t.Fatalf("request %d: caller's request was modified (Close=%t, Connection=%q)", i, req.Close, req.Header.Get("Connection"))
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(conns) != 3 {
+		t.Fatalf("used %d connections, want 3: %v", len(conns), conns)
+	}
+	for addr, n := range conns {
+		if n > 3 {
+			t.Errorf("connection %s carried %d requests, want at most 3", addr, n)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// cookieConfig 路由配置中Set-Cookie属性的改写规则，未写出的属性保持后端返回的值
+type cookieConfig struct {
+	Domain   *string `json:"domain,omitempty" yaml:"domain"`       // 替换Domain，为空字符串时删除Domain使Cookie只属于代理的主机
+	Path     *string `json:"path,omitempty" yaml:"path"`           // 替换Path，为空字符串时删除Path
+	MapPath  bool    `json:"map_path,omitempty" yaml:"map_path"`   // 将以后端基础路径开头的Path替换为路由前缀
+	Secure   *bool   `json:"secure,omitempty" yaml:"secure"`       // 添加或删除Secure
+	SameSite string  `json:"same_site,omitempty" yaml:"same_site"` // 替换SameSite: lax、strict或none
+}
+
+// validate 检查改写规则
+func (c cookieConfig) validate() error {
+	switch strings.ToLower(c.SameSite) {
+	case "", "lax", "strict":
+	case "none":
+		if c.Secure != nil && !*c.Secure {
+			return fmt.Errorf("same_site none requires secure")
+		}
+	default:
+		return fmt.Errorf("same_site must be lax, strict or none")
+	}
+	if c.MapPath && c.Path != nil {
+		return fmt.Errorf("path and map_path cannot be used together")
+	}
+	return nil
+}
+
+// rewriteSetCookie 按规则改写一个Set-Cookie头的属性，backendPath和publicPrefix用于map_path。
+// 按文本逐个处理属性，保留规则未涉及的属性（包括Go不认识的属性）原样不变
+func (c *cookieConfig) rewriteSetCookie(value, backendPath, publicPrefix string) string {
+	parts := strings.Split(value, ";")
+	out := []string{strings.TrimSpace(parts[0])}
+	sameSite := strings.ToLower(c.SameSite)
+	for _, part := range parts[1:] {
+		attr := strings.TrimSpace(part)
+		name, val, _ := strings.Cut(attr, "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "domain":
+			if c.Domain != nil {
+				continue
+			}
+		case "path":
+			if c.Path != nil {
+				continue
+			}
+			if base := strings.TrimSuffix(backendPath, "/"); c.MapPath && (val == base || strings.HasPrefix(val, base+"/")) {
+				attr = "Path=" + publicPrefix + strings.TrimPrefix(strings.TrimPrefix(val, base), "/")
+			}
+		case "secure":
+			if c.Secure != nil || sameSite == "none" {
+				continue
+			}
+		case "samesite":
+			if sameSite != "" {
+				continue
+			}
+		case "":
+			continue
+		}
+		out = append(out, attr)
+	}
+	if c.Domain != nil && *c.Domain != "" {
+		out = append(out, "Domain="+*c.Domain)
+	}
+	if c.Path != nil && *c.Path != "" {
+		out = append(out, "Path="+*c.Path)
+	}
+	if c.Secure != nil && *c.Secure || sameSite == "none" {
+		out = append(out, "Secure")
+	}
+	if sameSite != "" {
+		out = append(out, "SameSite="+strings.ToUpper(sameSite[:1])+sameSite[1:])
+	}
+	return strings.Join(out, "; ")
+}
+
+// rewriteCookies 改写响应中的全部Set-Cookie头
+func (c *cookieConfig) rewriteCookies(header http.Header, backendPath, publicPrefix string) {
+	cookies := header.Values("Set-Cookie")
+	if len(cookies) == 0 {
+		return
+	}
+	rewritten := make([]string, len(cookies))
+	for i, cookie := range cookies {
+		rewritten[i] = c.rewriteSetCookie(cookie, backendPath, publicPrefix)
+	}
+	header["Set-Cookie"] = rewritten
+}
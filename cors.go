@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsConfig 路由配置中的CORS设置
+type corsConfig struct {
+	Origins     []string `json:"origins" yaml:"origins"`                   // 允许的来源，*表示任意来源，支持 https://*.example.com 形式的子域名通配
+	Methods     []string `json:"methods,omitempty" yaml:"methods"`         // 预检请求允许的方法，为空时使用常用方法
+	Headers     []string `json:"headers,omitempty" yaml:"headers"`         // 预检请求允许的请求头，*表示允许客户端请求的任意头
+	Expose      []string `json:"expose,omitempty" yaml:"expose"`           // 允许前端读取的响应头
+	Credentials bool     `json:"credentials,omitempty" yaml:"credentials"` // 是否允许携带Cookie等凭据
+	MaxAge      int      `json:"max_age,omitempty" yaml:"max_age"`         // 预检结果的缓存秒数，0表示不返回Access-Control-Max-Age
+}
+
+// defaultCORSMethods 未配置methods时预检请求允许的方法
+var defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// corsPolicy 一条路由生效的CORS策略，由代理直接应答预检请求并在响应中设置Access-Control-*头
+type corsPolicy struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	suffixes    []string // 子域名通配的来源，如 https://*.example.com 对应 scheme "https://" 和后缀 ".example.com"
+	schemes     []string
+	methods     string
+	anyHeader   bool
+	headers     string
+	expose      string
+	credentials bool
+	maxAge      int
+}
+
+// defaultCORSPolicy 由-cors-*参数得到的CORS策略，用于未单独配置cors的路由
+var defaultCORSPolicy *corsPolicy
+
+// newCORSPolicy 校验并创建CORS策略，未配置任何来源时返回nil表示不处理CORS
+func newCORSPolicy(c corsConfig) (*corsPolicy, error) {
+	if len(c.Origins) == 0 {
+		return nil, nil
+	}
+	if c.MaxAge < 0 {
+		return nil, fmt.Errorf("max_age must not be negative")
+	}
+	p := &corsPolicy{origins: map[string]bool{}, credentials: c.Credentials, maxAge: c.MaxAge}
+	for _, origin := range c.Origins {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "*")
+			p.schemes = append(p.schemes, scheme)
+			p.suffixes = append(p.suffixes, strings.ToLower(host))
+		case strings.Contains(origin, "://"):
+			p.origins[strings.ToLower(origin)] = true
+		default:
+			return nil, fmt.Errorf("invalid origin %q, expected scheme://host[:port]", origin)
+		}
+	}
+	var methods []string
+	for _, m := range c.Methods {
+		methods = append(methods, strings.ToUpper(strings.TrimSpace(m)))
+	}
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	p.methods = strings.Join(methods, ", ")
+	var headers []string
+	for _, h := range c.Headers {
+		if h = strings.TrimSpace(h); h == "*" {
+			p.anyHeader = true
+		} else if h != "" {
+			headers = append(headers, h)
+		}
+	}
+	p.headers = strings.Join(headers, ", ")
+	p.expose = strings.Join(c.Expose, ", ")
+	return p, nil
+}
+
+// allowOrigin 判断请求的来源是否被允许
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for i, suffix := range p.suffixes {
+		if strings.HasPrefix(origin, p.schemes[i]) && strings.HasSuffix(origin, suffix) && len(origin) > len(p.schemes[i])+len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPreflight 判断是否为CORS预检请求
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// setOriginHeaders 设置预检和实际请求共用的响应头。允许任意来源且不带凭据时返回*，否则回显请求的来源
+func (p *corsPolicy) setOriginHeaders(h http.Header, origin string) {
+	if p.anyOrigin && !p.credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	}
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight 直接应答预检请求，来源不被允许时返回403
+func (p *corsPolicy) preflight(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if !p.allowOrigin(origin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	h := w.Header()
+	p.setOriginHeaders(h, origin)
+	h.Set("Access-Control-Allow-Methods", p.methods)
+	if p.anyHeader {
+		if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			h.Set("Access-Control-Allow-Headers", requested)
+		}
+		h.Add("Vary", "Access-Control-Request-Headers")
+	} else if p.headers != "" {
+		h.Set("Access-Control-Allow-Headers", p.headers)
+	}
+	if p.maxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(p.maxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apply 为来源被允许的实际请求设置响应头，在转发前写入，后端返回的Access-Control-*头会被忽略
+func (p *corsPolicy) apply(h http.Header, origin string) {
+	if origin == "" || !p.allowOrigin(origin) {
+		return
+	}
+	p.setOriginHeaders(h, origin)
+	if p.expose != "" {
+		h.Set("Access-Control-Expose-Headers", p.expose)
+	}
+}
+
+// stripCORSHeaders 删除后端返回的CORS响应头，由代理的策略统一设置
+func stripCORSHeaders(h http.Header) {
+	for name := range h {
+		if strings.HasPrefix(name, "Access-Control-") {
+			delete(h, name)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsLookupTimeout 单次DNS查询的超时时间
+const dnsLookupTimeout = 5 * time.Second
+
+// 动态发现后端的方式，写在后端地址的协议前，如 dns+http://：
+// dns按A/AAAA记录展开为每个IP一个后端，srv按SRV记录展开为每个目标一个后端，
+// consul和etcd从服务注册中心读取实例并跟踪实例的注册和注销
+const (
+	discoveryDNS    = "dns"
+	discoverySRV    = "srv"
+	discoveryConsul = "consul"
+	discoveryEtcd   = "etcd"
+)
+
+var discoveryKinds = []string{discoveryDNS, discoverySRV, discoveryConsul, discoveryEtcd}
+
+// discoveredAddr 一条解析结果，host为展开后的后端主机（IP或SRV目标，带端口时为host:port）
+type discoveredAddr struct {
+	host   string
+	weight int
+}
+
+// discoveredName 一个需要定期重新解析的后端名称
+type discoveredName struct {
+	kind     string
+	name     string     // A/AAAA或SRV查询的名称，或注册中心中的服务名
+	port     string     // A/AAAA后端地址中的端口，为空时使用协议默认端口
+	query    url.Values // 后端地址中的查询参数，consul支持tag和dc
+	original string     // Host头和TLS验证使用的原始主机，SRV时为去掉_service._proto的名称；注册中心的实例为空，使用实例地址
+	addrs    []discoveredAddr
+	index    uint64        // Consul的X-Consul-Index或etcd的revision，watch从这里开始
+	build    uint64        // 最近一次使用该名称的路由构建
+	stop     chan struct{} // 关闭时停止watch，只用于consul和etcd
+}
+
+// backendDiscovery 按DNS或服务注册中心展开后端地址，定期重新解析DNS并watch注册中心。路由构建时使用最近一次成功解析的结果，
+// 结果变化时重建路由表，使新的实例加入负载均衡，消失的实例不再接收新请求
+type backendDiscovery struct {
+	mu    sync.Mutex
+	names map[string]*discoveredName // 查询键 -> 解析结果
+	hosts map[string]string          // 展开后的后端主机 -> 原始主机，用作Host头和TLS的SNI
+	build uint64                     // 当前路由构建的序号
+
+	changed chan struct{} // watch发现注册中心的实例变化
+}
+
+var discovery = &backendDiscovery{names: map[string]*discoveredName{}, hosts: map[string]string{}, changed: make(chan struct{}, 1)}
+
+// isDiscoveryBackend 判断后端地址是否需要动态发现
+func isDiscoveryBackend(raw string) bool {
+	for _, kind := range discoveryKinds {
+		if strings.HasPrefix(raw, kind+"+") {
+			return true
+		}
+	}
+	return false
+}
+
+// expand 将 dns+http(s)://host:port/base/、srv+http(s)://_service._tcp.name/base/、
+// consul+http(s)://service/base/?tag=v2&dc=dc1 或 etcd+http(s)://service/base/ 展开为后端列表。
+// A/AAAA记录的每个IP使用weight作为权重；SRV只使用优先级最高（数值最小）的记录，权重为记录的weight；
+// Consul使用实例的Weights.Passing，etcd使用实例值中的weight
+func (d *backendDiscovery) expand(u *url.URL, weight int) ([]*url.URL, []int, error) {
+	kind, scheme, _ := strings.Cut(u.Scheme, "+")
+	if scheme != "http" && scheme != "https" {
+		return nil, nil, fmt.Errorf("invalid backend URL %q: expected %s+http or %s+https", u, kind, kind)
+	}
+	n := &discoveredName{kind: kind, name: u.Hostname(), port: u.Port(), query: u.Query()}
+	switch kind {
+	case discoveryDNS:
+		n.original = u.Host
+	case discoverySRV:
+		n.original = srvServiceName(n.name)
+	}
+	if kind != discoveryDNS && n.port != "" {
+		return nil, nil, fmt.Errorf("invalid backend URL %q: the port of %s+ backends comes from %s", u, kind, kind)
+	}
+	for option := range n.query {
+		if kind != discoveryConsul || option != "tag" && option != "dc" {
+			return nil, nil, fmt.Errorf("invalid backend URL %q: unknown option %q", u, option)
+		}
+	}
+	key := u.Scheme + "://" + u.Host
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+
+	d.mu.Lock()
+	cached, ok := d.names[key]
+	if ok {
+		cached.build = d.build
+	}
+	build := d.build
+	d.mu.Unlock()
+	if !ok {
+		addrs, index, err := n.resolve()
+		if err != nil {
+			return nil, nil, fmt.Errorf("backend %s: %w", u, err)
+		}
+		n.addrs, n.index, n.build = addrs, index, build
+		logger.Infof("Backend %s resolved to %s", key, n)
+		d.mu.Lock()
+		if cached, ok = d.names[key]; !ok {
+			d.names[key], cached = n, n
+			d.startWatch(key, n)
+		}
+		d.mu.Unlock()
+	}
+
+	d.mu.Lock()
+	addrs := cached.addrs
+	if cached.original != "" {
+		for _, a := range addrs {
+			d.hosts[a.host] = cached.original
+		}
+	}
+	d.mu.Unlock()
+
+	var backends []*url.URL
+	var weights []int
+	for _, a := range addrs {
+		b := *u
+		b.Scheme, b.Host, b.RawQuery = scheme, a.host, ""
+		backends = append(backends, &b)
+		if kind == discoveryDNS {
+			weights = append(weights, weight)
+		} else {
+			weights = append(weights, a.weight)
+		}
+	}
+	return backends, weights, nil
+}
+
+// startWatch 为注册中心中的服务启动watch，调用时需持有d.mu
+func (d *backendDiscovery) startWatch(key string, n *discoveredName) {
+	switch n.kind {
+	case discoveryConsul:
+		n.stop = make(chan struct{})
+		go d.watchConsul(key, n)
+	case discoveryEtcd:
+		n.stop = make(chan struct{})
+		go d.watchEtcd(key, n)
+	}
+}
+
+// update 记录watch得到的新实例列表，有变化时通知重建路由表；实例全部注销时保留原列表，路由至少需要一个后端
+func (d *backendDiscovery) update(key string, n *discoveredName, addrs []discoveredAddr) {
+	if len(addrs) == 0 {
+		logger.Warnf("Backend %s has no instances, keeping %s", key, n)
+		return
+	}
+	sortDiscoveredAddrs(addrs)
+	d.mu.Lock()
+	if sameDiscoveredAddrs(n.addrs, addrs) {
+		d.mu.Unlock()
+		return
+	}
+	previous := n.String()
+	n.addrs = addrs
+	logger.Infof("Backend %s changed: %s -> %s", key, previous, n)
+	d.mu.Unlock()
+	select {
+	case d.changed <- struct{}{}:
+	default:
+	}
+}
+
+// resolve 查询DNS或注册中心，返回按主机排序的结果和注册中心的索引；没有记录时返回错误
+func (n *discoveredName) resolve() ([]discoveredAddr, uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+	var addrs []discoveredAddr
+	var index uint64
+	switch n.kind {
+	case discoveryConsul:
+		var err error
+		if addrs, index, err = consulService(context.Background(), n.name, n.query, 0); err != nil {
+			return nil, 0, err
+		}
+	case discoveryEtcd:
+		found, revision, err := etcdService(context.Background(), n.name)
+		if err != nil {
+			return nil, 0, err
+		}
+		addrs, index = found, uint64(revision)
+	case discoverySRV:
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", n.name)
+		if err != nil {
+			return nil, 0, err
+		}
+		best := -1
+		for _, r := range records {
+			if best < 0 || int(r.Priority) < best {
+				best = int(r.Priority)
+			}
+		}
+		for _, r := range records {
+			if int(r.Priority) != best {
+				continue
+			}
+			weight := int(r.Weight)
+			if weight == 0 {
+				weight = 1
+			}
+			target := strings.TrimSuffix(r.Target, ".")
+			addrs = append(addrs, discoveredAddr{host: net.JoinHostPort(target, strconv.Itoa(int(r.Port))), weight: weight})
+		}
+	default:
+		ips, err := net.DefaultResolver.LookupHost(ctx, n.name)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, ip := range ips {
+			host := ip
+			if n.port != "" {
+				host = net.JoinHostPort(ip, n.port)
+			} else if strings.Contains(ip, ":") {
+				host = "[" + ip + "]"
+			}
+			addrs = append(addrs, discoveredAddr{host: host, weight: 1})
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, 0, fmt.Errorf("no %s records for %s", n.kind, n.name)
+	}
+	sortDiscoveredAddrs(addrs)
+	return addrs, index, nil
+}
+
+func sortDiscoveredAddrs(addrs []discoveredAddr) {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].host < addrs[j].host })
+}
+
+func (n *discoveredName) String() string {
+	hosts := make([]string, len(n.addrs))
+	for i, a := range n.addrs {
+		hosts[i] = a.host
+		if n.kind != discoveryDNS {
+			hosts[i] += fmt.Sprintf(";weight=%d", a.weight)
+		}
+	}
+	return strings.Join(hosts, ",")
+}
+
+// srvServiceName 去掉SRV名称开头的_service._proto标签，如 _http._tcp.api.default.svc -> api.default.svc
+func srvServiceName(name string) string {
+	for strings.HasPrefix(name, "_") {
+		_, rest, ok := strings.Cut(name, ".")
+		if !ok {
+			break
+		}
+		name = rest
+	}
+	return name
+}
+
+// startBuild 开始一次路由构建，之后构建中未使用的名称在finishBuild时不再解析
+func (d *backendDiscovery) startBuild() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.build++
+}
+
+// finishBuild 路由构建成功后删除新路由表不再使用的名称和已消失的后端主机
+func (d *backendDiscovery) finishBuild() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hosts = map[string]string{}
+	for key, n := range d.names {
+		if n.build != d.build {
+			if n.stop != nil {
+				close(n.stop)
+			}
+			delete(d.names, key)
+			continue
+		}
+		if n.original == "" {
+			continue
+		}
+		for _, a := range n.addrs {
+			d.hosts[a.host] = n.original
+		}
+	}
+}
+
+// refresh 重新解析全部DNS名称，返回解析结果是否有变化；解析失败时保留上次的结果。注册中心的服务由watch跟踪
+func (d *backendDiscovery) refresh() bool {
+	d.mu.Lock()
+	names := make(map[string]*discoveredName, len(d.names))
+	for key, n := range d.names {
+		if n.stop == nil {
+			names[key] = n
+		}
+	}
+	d.mu.Unlock()
+
+	changed := false
+	for key, n := range names {
+		addrs, _, err := n.resolve()
+		if err != nil {
+			logger.Warnf("Failed to re-resolve backend %s, keeping %s: %v", key, n, err)
+			continue
+		}
+		d.mu.Lock()
+		if !sameDiscoveredAddrs(n.addrs, addrs) {
+			previous := n.String()
+			n.addrs = addrs
+			logger.Infof("Backend %s changed: %s -> %s", key, previous, n)
+			changed = true
+		}
+		d.mu.Unlock()
+	}
+	return changed
+}
+
+func sameDiscoveredAddrs(a, b []discoveredAddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// run 按interval重新解析DNS名称，interval为0时不重新解析；解析结果或注册中心的实例变化时调用rebuild重建路由表
+func (d *backendDiscovery) run(interval time.Duration, rebuild func() error) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			if !d.refresh() {
+				continue
+			}
+		case <-d.changed:
+		}
+		if err := rebuild(); err != nil {
+			logger.Errorf("Failed to rebuild routes after backend discovery change, keeping current routes: %v", err)
+		}
+	}
+}
+
+// originalHost 返回由DNS展开的后端主机对应的原始主机，不是展开的后端时返回false
+func (d *backendDiscovery) originalHost(host string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	original, ok := d.hosts[host]
+	return original, ok
+}
+
+// serverName 返回由DNS展开的HTTPS后端验证证书使用的主机名；一条路由的后端来自多个不同主机名时返回错误
+func (d *backendDiscovery) serverName(backends []*url.URL) (string, error) {
+	name := ""
+	for _, b := range backends {
+		if b.Scheme != "https" {
+			continue
+		}
+		original, ok := d.originalHost(b.Host)
+		if !ok {
+			continue
+		}
+		if h, _, err := net.SplitHostPort(original); err == nil {
+			original = h
+		}
+		if name != "" && name != original {
+			return "", fmt.Errorf("https backends discovered from %s and %s need separate routes", name, original)
+		}
+		name = original
+	}
+	return name, nil
+}
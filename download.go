@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errResponseTooLarge 后端的响应体超过路由允许的大小
+var errResponseTooLarge = errors.New("response body too large")
+
+// isResponseTooLarge 判断转发过程中的错误是否由响应体超过上限引起
+func isResponseTooLarge(err error) bool {
+	return errors.Is(err, errResponseTooLarge)
+}
+
+// limitResponseBody 限制转发给客户端的响应体大小：声明的Content-Length超过上限时返回errResponseTooLarge，
+// 由ErrorHandler返回502；长度未知的响应在读取到超限时中断，此时响应头已发出，只能断开客户端连接
+func limitResponseBody(resp *http.Response, limit int64) error {
+	if limit <= 0 || !hasResponseBody(resp) {
+		return nil
+	}
+	if resp.ContentLength > limit {
+		return errResponseTooLarge
+	}
+	resp.Body = &limitedResponseBody{ReadCloser: resp.Body, remaining: limit, limit: limit, req: resp.Request}
+	return nil
+}
+
+// limitedResponseBody 读取超过limit字节时返回errResponseTooLarge
+type limitedResponseBody struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+	req       *http.Request
+}
+
+func (b *limitedResponseBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+	// 多读一个字节，恰好等于上限的响应体不误判为超限
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		getRequestInfo(b.req).log.Warnf("Response body for %s %s exceeds %d bytes, aborting response", b.req.Method, b.req.URL.Path, b.limit)
+		return n + int(b.remaining), errResponseTooLarge
+	}
+	return n, err
+}
+
+// throttleChunksPerSecond 限速写出时每秒分成的块数，块越小发送越平滑
+const throttleChunksPerSecond = 10
+
+// throttleMinChunk 限速写出的最小块字节数
+const throttleMinChunk = 512
+
+// connBandwidth 一个客户端连接的下载带宽预约，HTTP/2同一连接上的各请求共用，合计不超过路由的限速
+type connBandwidth struct {
+	mu   sync.Mutex
+	next time.Time // 已预约的带宽用完的时间
+}
+
+// reserve 按rate字节/秒预约n字节，返回写出前需要等待的时间
+func (b *connBandwidth) reserve(n int, rate int64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	// 空闲过的连接不积累额度，避免空闲后以远超限速的速度突发
+	if b.next.Before(now) {
+		b.next = now
+	}
+	wait := b.next.Sub(now)
+	b.next = b.next.Add(time.Duration(float64(n) / float64(rate) * float64(time.Second)))
+	return wait
+}
+
+// withConnBandwidth 在连接的上下文中保存该连接的带宽预约，由http.Server.ConnContext调用
+func withConnBandwidth(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bandwidthKey, &connBandwidth{})
+}
+
+// throttledWriter 按路由的下载限速写出响应体；rate在匹配路由后设置，为0时不限速
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx  context.Context
+	conn *connBandwidth
+	rate int64
+}
+
+// newThrottledWriter 返回写出时共用连接带宽预约的限速ResponseWriter，连接没有预约（如HTTP/3）时按请求单独限速
+func newThrottledWriter(w http.ResponseWriter, r *http.Request) *throttledWriter {
+	conn, _ := r.Context().Value(bandwidthKey).(*connBandwidth)
+	if conn == nil {
+		conn = &connBandwidth{}
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: r.Context(), conn: conn}
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	if w.rate <= 0 {
+		return w.ResponseWriter.Write(p)
+	}
+	chunk := int(w.rate / throttleChunksPerSecond)
+	if chunk < throttleMinChunk {
+		chunk = throttleMinChunk
+	}
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > chunk {
+			n = chunk
+		}
+		if wait := w.conn.reserve(n, w.rate); wait > 0 {
+			// 等待前先发出已写的数据，客户端按限速持续收到数据而不是成批收到
+			http.NewResponseController(w.ResponseWriter).Flush()
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-w.ctx.Done():
+				timer.Stop()
+				return written, w.ctx.Err()
+			}
+		}
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Unwrap 供http.ResponseController访问底层ResponseWriter（Flush、Hijack等）
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix 参数对应环境变量的前缀，如 -backend 对应 ST_PROXY_BACKEND
+const envPrefix = "ST_PROXY_"
+
+// 参数值来源
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceDefault = "default"
+)
+
+// flagSources 记录每个参数最终取值的来源，启动时输出到日志
+var flagSources = map[string]string{}
+
+// envName 返回参数对应的环境变量名
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvFallback 对命令行未显式设置的参数，使用对应环境变量的值，命令行优先
+func applyEnvFallback(fs *flag.FlagSet) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		if explicit[f.Name] {
+			flagSources[f.Name] = sourceFlag
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			flagSources[f.Name] = sourceDefault
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), setErr)
+			return
+		}
+		flagSources[f.Name] = sourceEnv
+	})
+	return err
+}
+
+// logFlagSources 输出每个参数的取值及其来源
+func logFlagSources(fs *flag.FlagSet) {
+	logger.Info("Configuration sources:")
+	fs.VisitAll(func(f *flag.Flag) {
+		source := flagSources[f.Name]
+		switch source {
+		case sourceEnv:
+			source = fmt.Sprintf("%s (%s)", source, envName(f.Name))
+		case sourceConfig:
+			source = fmt.Sprintf("%s (%s)", source, configPath)
+		}
+		logger.Infof("  -%s=%s [%s]", f.Name, displayFlagValue(f), source)
+	})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// 可以自定义的错误页，文件名为 <页面>.html 或 <页面>.json
+var errorPageNames = []string{"502", "503", "504", "maintenance"}
+
+// errorPageData 错误页模板的数据
+type errorPageData struct {
+	Status     int
+	StatusText string
+	Message    string
+	RequestID  string
+	Method     string
+	Path       string
+	Route      string
+}
+
+// errorPageTemplate html/template和text/template共用的执行接口
+type errorPageTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// errorPages 从目录加载的错误页模板，HTML模板自动转义，JSON模板中的字符串用{{json .Message}}输出
+type errorPages struct {
+	html map[string]errorPageTemplate
+	json map[string]errorPageTemplate
+}
+
+// jsonString 将值编码为JSON，供JSON模板输出带引号和转义的字符串
+func jsonString(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// loadErrorPages 读取目录中的错误页模板，目录中没有任何模板时返回错误
+func loadErrorPages(dir string) (*errorPages, error) {
+	p := &errorPages{html: map[string]errorPageTemplate{}, json: map[string]errorPageTemplate{}}
+	for _, name := range errorPageNames {
+		if data, err := os.ReadFile(filepath.Join(dir, name+".html")); err == nil {
+			t, err := htmltemplate.New(name + ".html").Parse(string(data))
+			if err != nil {
+				return nil, err
+			}
+			p.html[name] = t
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if data, err := os.ReadFile(filepath.Join(dir, name+".json")); err == nil {
+			t, err := template.New(name + ".json").Funcs(template.FuncMap{"json": jsonString}).Parse(string(data))
+			if err != nil {
+				return nil, err
+			}
+			p.json[name] = t
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if len(p.html) == 0 && len(p.json) == 0 {
+		return nil, fmt.Errorf("no error pages found in %s (expected %s.html or .json)", dir, strings.Join(errorPageNames, ", "))
+	}
+	return p, nil
+}
+
+// prefersJSON 判断客户端是否要求JSON：Accept中有JSON类型且未同时接受HTML
+func prefersJSON(r *http.Request) bool {
+	accept := strings.ToLower(r.Header.Get("Accept"))
+	return strings.Contains(accept, "json") && !strings.Contains(accept, "text/html")
+}
+
+// errorPageSet 由-error-pages-dir加载的错误页，为nil时使用纯文本错误
+var errorPageSet *errorPages
+
+// writeErrorPage 按page对应的模板写出错误响应：客户端要求JSON且有JSON模板时返回JSON，否则优先HTML；
+// 没有对应模板或模板执行失败时与http.Error相同，返回纯文本message
+func writeErrorPage(w http.ResponseWriter, r *http.Request, status int, page, message string) {
+	if errorPageSet != nil {
+		t, contentType := errorPageSet.html[page], "text/html; charset=utf-8"
+		if j, ok := errorPageSet.json[page]; ok && (t == nil || prefersJSON(r)) {
+			t, contentType = j, "application/json"
+		}
+		if t != nil {
+			info := getRequestInfo(r)
+			data := errorPageData{
+				Status:     status,
+				StatusText: http.StatusText(status),
+				Message:    message,
+				RequestID:  info.id,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+			}
+			if info.route != nil {
+				data.Route = info.route.name()
+			}
+			var buf bytes.Buffer
+			if err := t.Execute(&buf, data); err != nil {
+				info.log.Errorf("Failed to render %s error page: %v", page, err)
+				http.Error(w, message, status)
+				return
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(status)
+			w.Write(buf.Bytes())
+			return
+		}
+	}
+	http.Error(w, message, status)
+}
+
+// maintenanceSwitch 路由的维护模式开关：路由配置决定初始状态，管理接口的设置覆盖配置并在重新加载路由后保留
+type maintenanceSwitch struct {
+	mu       sync.RWMutex
+	all      *bool           // 对全部路由的设置，为nil时未设置
+	override map[string]bool // 路由名称 -> 管理接口设置的状态
+}
+
+func newMaintenanceSwitch() *maintenanceSwitch {
+	return &maintenanceSwitch{override: map[string]bool{}}
+}
+
+// enabled 判断路由当前是否处于维护模式
+func (m *maintenanceSwitch) enabled(rt *route) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if on, ok := m.override[rt.name()]; ok {
+		return on
+	}
+	if m.all != nil {
+		return *m.all
+	}
+	return rt.maintenance
+}
+
+// set 设置一条路由的维护模式，name为空时设置全部路由并清除各路由单独的设置
+func (m *maintenanceSwitch) set(name string, on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name == "" {
+		m.all = &on
+		m.override = map[string]bool{}
+		return
+	}
+	m.override[name] = on
+}
+
+// maintenanceStatus 维护模式接口中单条路由的状态
+type maintenanceStatus struct {
+	Route       string `json:"route"`
+	Maintenance bool   `json:"maintenance"`
+}
+
+// newMaintenanceHandler 创建查看和切换维护模式的管理接口：GET返回各路由的状态，
+// POST ?route=/api/&enabled=true切换一条路由，不带route时切换全部路由，重启后恢复配置中的状态
+func newMaintenanceHandler(token string, m *maintenanceSwitch, routes func() *routeTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminRequest(w, r, token, http.MethodGet, http.MethodPost) {
+			return
+		}
+		table := routes()
+		if r.Method == http.MethodPost {
+			on, err := strconv.ParseBool(r.FormValue("enabled"))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "enabled must be true or false"})
+				return
+			}
+			name := r.FormValue("route")
+			if name != "" {
+				found := false
+				for _, rt := range table.routes {
+					found = found || rt.name() == name
+				}
+				if !found {
+					writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "unknown route " + name})
+					return
+				}
+			}
+			m.set(name, on)
+			if name == "" {
+				name = "all routes"
+			}
+			logger.Warnf("Maintenance mode for %s set to %t by %s", name, on, clientIP(r))
+		}
+		var status []maintenanceStatus
+		for _, rt := range table.routes {
+			status = append(status, maintenanceStatus{Route: rt.name(), Maintenance: m.enabled(rt)})
+		}
+		sort.Slice(status, func(i, j int) bool { return status[i].Route < status[j].Route })
+		writeJSON(w, http.StatusOK, map[string]interface{}{"routes": status})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMaintenanceFlagAppliesToConfigRoutes -maintenance对配置文件中的路由同样生效，路由的maintenance设置可单独关闭
+func TestMaintenanceFlagAppliesToConfigRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	routes := "routes:\n" +
+		"  - prefix: /orders/\n" +
+		"    backend: " + backend.URL + "/\n" +
+		"  - prefix: /status/\n" +
+		"    backend: " + backend.URL + "/\n" +
+		"    maintenance: false\n"
+	if err := os.WriteFile(config, []byte(routes), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	addr := startProxy(t, "-backend", backend.URL+"/", "-prefix", "/api/", "-config", config, "-maintenance")
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/api/x", http.StatusServiceUnavailable},
+		{"/orders/x", http.StatusServiceUnavailable},
+		{"/status/x", http.StatusOK},
+	} {
+		if got := getStatus(t, "http://"+addr+tc.path); got != tc.want {
+			t.Errorf("GET %s: got %d, want %d", tc.path, got, tc.want)
+		}
+	}
+}
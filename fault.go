@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// faultConfig 路由配置中的故障注入设置，用于测试前端对后端故障的容错；各比例为0-100的百分比
+type faultConfig struct {
+	ErrorPercent float64  `json:"error_percent,omitempty" yaml:"error_percent"`         // 不请求后端、直接返回错误的请求比例
+	ErrorStatus  int      `json:"error_status,omitempty" yaml:"error_status"`           // 注入错误的状态码，默认500
+	Delay        string   `json:"delay,omitempty" yaml:"delay"`                         // 转发前增加的延迟，如500ms
+	DelayPercent *float64 `json:"delay_percent,omitempty" yaml:"delay_percent"`         // 增加延迟的请求比例，为空时设置了delay的请求全部延迟
+	AbortPercent float64  `json:"abort_percent,omitempty" yaml:"abort_percent"`         // 响应中途断开连接的请求比例
+	AbortAfter   int64    `json:"abort_after_bytes,omitempty" yaml:"abort_after_bytes"` // 断开前写给客户端的响应体字节数，0表示发出响应头后立即断开
+}
+
+// faultPolicy 一条路由生效的故障注入
+type faultPolicy struct {
+	errorPercent float64
+	errorStatus  int
+	delay        time.Duration
+	delayPercent float64
+	abortPercent float64
+	abortAfter   int64
+}
+
+// newFaultPolicy 检查故障注入设置，没有注入任何故障时返回nil
+func newFaultPolicy(c faultConfig) (*faultPolicy, error) {
+	p := &faultPolicy{
+		errorPercent: c.ErrorPercent,
+		errorStatus:  c.ErrorStatus,
+		delayPercent: 100,
+		abortPercent: c.AbortPercent,
+		abortAfter:   c.AbortAfter,
+	}
+	if p.errorStatus == 0 {
+		p.errorStatus = http.StatusInternalServerError
+	}
+	if c.Delay != "" {
+		d, err := time.ParseDuration(c.Delay)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid delay %q", c.Delay)
+		}
+		p.delay = d
+	}
+	if c.DelayPercent != nil {
+		p.delayPercent = *c.DelayPercent
+	}
+	for name, v := range map[string]float64{"error_percent": p.errorPercent, "delay_percent": p.delayPercent, "abort_percent": p.abortPercent} {
+		if v < 0 || v > 100 {
+			return nil, fmt.Errorf("%s must be between 0 and 100", name)
+		}
+	}
+	if p.errorStatus < 400 || p.errorStatus > 599 {
+		return nil, fmt.Errorf("error_status must be a 4xx or 5xx status code")
+	}
+	if p.abortAfter < 0 {
+		return nil, fmt.Errorf("abort_after_bytes must not be negative")
+	}
+	if p.errorPercent == 0 && (p.delay == 0 || p.delayPercent == 0) && p.abortPercent == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// String 用于日志和管理接口
+func (p *faultPolicy) String() string {
+	if p == nil {
+		return "none"
+	}
+	return fmt.Sprintf("error=%g%%(%d) delay=%s(%g%%) abort=%g%%(after %d bytes)", p.errorPercent, p.errorStatus, p.delay, p.delayPercent, p.abortPercent, p.abortAfter)
+}
+
+// faultDecision 对一个请求抽样得到的故障
+type faultDecision struct {
+	delay time.Duration // 转发前等待的时间
+	error int           // 不为0时直接返回该状态码
+	abort bool          // 是否在响应中途断开连接
+}
+
+// decide 按各比例为请求抽样决定注入的故障
+func (p *faultPolicy) decide() faultDecision {
+	var d faultDecision
+	if p.delay > 0 && rand.Float64()*100 < p.delayPercent {
+		d.delay = p.delay
+	}
+	if rand.Float64()*100 < p.errorPercent {
+		d.error = p.errorStatus
+	} else if rand.Float64()*100 < p.abortPercent {
+		d.abort = true
+	}
+	return d
+}
+
+// sleep 等待注入的延迟，客户端断开时提前返回false
+func (d faultDecision) sleep(ctx context.Context) bool {
+	if d.delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// faultAbortWriter 写出after字节的响应体后中断响应：HTTP/1.x关闭连接，HTTP/2重置流，模拟后端在响应中途断开
+type faultAbortWriter struct {
+	http.ResponseWriter
+	after   int64
+	written int64
+}
+
+func (w *faultAbortWriter) Write(p []byte) (int, error) {
+	if remaining := w.after - w.written; int64(len(p)) > remaining {
+		n, _ := w.ResponseWriter.Write(p[:remaining])
+		w.written += int64(n)
+		http.NewResponseController(w.ResponseWriter).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap 供http.ResponseController访问底层ResponseWriter（Flush、Hijack等）
+func (w *faultAbortWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// faultSwitch 路由的故障注入开关：路由配置决定初始设置，管理接口的设置覆盖配置并在重新加载路由后保留
+type faultSwitch struct {
+	mu       sync.RWMutex
+	override map[string]*faultPolicy // 路由名称 -> 管理接口设置的故障注入，值为nil表示关闭
+}
+
+func newFaultSwitch() *faultSwitch {
+	return &faultSwitch{override: map[string]*faultPolicy{}}
+}
+
+// policy 返回路由当前生效的故障注入，没有时返回nil
+func (f *faultSwitch) policy(rt *route) *faultPolicy {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if p, ok := f.override[rt.name()]; ok {
+		return p
+	}
+	return rt.fault
+}
+
+// set 设置一条路由的故障注入，p为nil时关闭
+func (f *faultSwitch) set(name string, p *faultPolicy) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.override[name] = p
+}
+
+// reset 清除管理接口对一条路由的设置，恢复路由配置中的故障注入
+func (f *faultSwitch) reset(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.override, name)
+}
+
+// faultQueryConfig 由管理接口的查询参数生成故障注入设置，参数名与路由配置的字段相同
+func faultQueryConfig(form url.Values) (faultConfig, error) {
+	var c faultConfig
+	var err error
+	parseFloat := func(name string, v *float64) {
+		if s := form.Get(name); s != "" && err == nil {
+			if *v, err = strconv.ParseFloat(s, 64); err != nil {
+				err = fmt.Errorf("invalid %s %q", name, s)
+			}
+		}
+	}
+	parseFloat("error_percent", &c.ErrorPercent)
+	parseFloat("abort_percent", &c.AbortPercent)
+	if s := form.Get("delay_percent"); s != "" {
+		var v float64
+		parseFloat("delay_percent", &v)
+		c.DelayPercent = &v
+	}
+	if s := form.Get("error_status"); s != "" && err == nil {
+		if c.ErrorStatus, err = strconv.Atoi(s); err != nil {
+			err = fmt.Errorf("invalid error_status %q", s)
+		}
+	}
+	if s := form.Get("abort_after_bytes"); s != "" && err == nil {
+		if c.AbortAfter, err = strconv.ParseInt(s, 10, 64); err != nil {
+			err = fmt.Errorf("invalid abort_after_bytes %q", s)
+		}
+	}
+	c.Delay = form.Get("delay")
+	return c, err
+}
+
+// faultStatus 故障注入接口中单条路由的状态
+type faultStatus struct {
+	Route string `json:"route"`
+	Fault string `json:"fault"`
+}
+
+// newFaultHandler 创建查看和设置故障注入的管理接口：GET返回各路由的设置；
+// POST ?route=/api/&error_percent=10&delay=200ms 替换一条路由的设置，未带任何故障参数时关闭，
+// 带reset=true时恢复路由配置中的设置；重启后恢复配置中的设置
+func newFaultHandler(token string, f *faultSwitch, routes func() *routeTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminRequest(w, r, token, http.MethodGet, http.MethodPost) {
+			return
+		}
+		table := routes()
+		if r.Method == http.MethodPost {
+			name := r.FormValue("route")
+			found := false
+			for _, rt := range table.routes {
+				found = found || rt.name() == name
+			}
+			if !found {
+				writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "unknown route " + name})
+				return
+			}
+			if reset, _ := strconv.ParseBool(r.FormValue("reset")); reset {
+				f.reset(name)
+				logger.Warnf("Fault injection for %s reset to route config by %s", name, clientIP(r))
+			} else {
+				c, err := faultQueryConfig(r.Form)
+				var p *faultPolicy
+				if err == nil {
+					p, err = newFaultPolicy(c)
+				}
+				if err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+					return
+				}
+				f.set(name, p)
+				logger.Warnf("Fault injection for %s set to %s by %s", name, p, clientIP(r))
+			}
+		}
+		var status []faultStatus
+		for _, rt := range table.routes {
+			status = append(status, faultStatus{Route: rt.name(), Fault: f.policy(rt).String()})
+		}
+		sort.Slice(status, func(i, j int) bool { return status[i].Route < status[j].Route })
+		writeJSON(w, http.StatusOK, map[string]interface{}{"routes": status})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 转发头处理策略
+const (
+	forwardedStrip   = "strip"   // 移除全部转发头，不向后端透露客户端信息
+	forwardedReplace = "replace" // 忽略客户端传入的转发头，按本跳连接重新设置
+	forwardedAppend  = "append"  // 来自可信代理的请求保留其转发头并追加本跳，其他请求同replace
+)
+
+// trustedProxies 可信的上游代理网段，来自这些地址的X-Forwarded-*头才会被采信
+var trustedProxies []*net.IPNet
+
+// parseIPNets 解析逗号分隔的CIDR或IP列表
+func parseIPNets(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", item, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// isTrustedProxy 判断地址是否属于可信代理网段
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP 返回直接连接到代理的对端IP（不含端口）
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP 返回请求来源的客户端IP（不含端口）：对端为可信代理时，
+// 从X-Forwarded-For末尾向前跳过可信代理，取第一个不可信的地址
+func clientIP(r *http.Request) string {
+	ip := peerIP(r)
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = hops[i]
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// applyForwardedHeaders 按转发头策略设置发往后端的请求头，host为客户端请求的原始Host。
+// ReverseProxy会在Director之后将对端IP追加到X-Forwarded-For，因此这里只决定保留还是移除已有的值
+func applyForwardedHeaders(req *http.Request, host, mode string) {
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	if mode == forwardedAppend && isTrustedProxy(peerIP(req)) {
+		if req.Header.Get("X-Forwarded-Proto") == "" {
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+		if req.Header.Get("X-Forwarded-Host") == "" {
+			req.Header.Set("X-Forwarded-Host", host)
+		}
+		req.Header.Set("X-Real-IP", clientIP(req))
+		return
+	}
+
+	for _, name := range []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "X-Forwarded-Port", "X-Real-IP", "Forwarded"} {
+		req.Header.Del(name)
+	}
+	if mode == forwardedStrip {
+		// 值为nil时ReverseProxy不会再添加X-Forwarded-For
+		req.Header["X-Forwarded-For"] = nil
+		return
+	}
+	req.Header.Set("X-Forwarded-Proto", proto)
+	req.Header.Set("X-Forwarded-Host", host)
+	req.Header.Set("X-Real-IP", peerIP(req))
+}
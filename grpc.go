@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// protocolGRPC 路由的protocol为grpc时始终以HTTP/2转发，保留流式读写和trailer
+const protocolGRPC = "grpc"
+
+// grpcTransport 连接gRPC后端的Transport：https后端通过TLS协商h2，http后端使用h2c明文连接。
+// gRPC要求HTTP/2，不能像普通Transport那样在后端不支持h2时退回HTTP/1.1。
+// 连接空闲超过keep_alive时发送PING检查连接是否仍然可用
+type grpcTransport struct {
+	h2  *http2.Transport
+	h2c *http2.Transport
+}
+
+func newGRPCTransport(tlsConfig *tls.Config, timeouts routeTimeouts, pool poolSettings) *grpcTransport {
+	dial := backendDial(&net.Dialer{
+		Timeout:   timeouts.dial,
+		KeepAlive: pool.dialKeepAlive(),
+	})
+	return &grpcTransport{
+		h2: &http2.Transport{
+			TLSClientConfig: tlsConfig,
+			ReadIdleTimeout: pool.keepAlive,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				tlsConn := tls.Client(conn, cfg)
+				if err := tlsConn.HandshakeContext(ctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return tlsConn, nil
+			},
+		},
+		h2c: &http2.Transport{
+			AllowHTTP:       true,
+			ReadIdleTimeout: pool.keepAlive,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		},
+	}
+}
+
+func (t *grpcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "https" {
+		return t.h2.RoundTrip(req)
+	}
+	return t.h2c.RoundTrip(req)
+}
+
+func (t *grpcTransport) CloseIdleConnections() {
+	t.h2.CloseIdleConnections()
+	t.h2c.CloseIdleConnections()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// configureHTTP2 配置前端监听的HTTP/2：TLS监听通过ALPN协商h2，cleartext为true时明文连接也接受h2c
+// （先验知识方式和Upgrade: h2c升级方式）。enabled为false时只使用HTTP/1.1
+func configureHTTP2(server *http.Server, enabled, cleartext bool, maxStreams uint32) error {
+	if !enabled {
+		// 非nil的空TLSNextProto使ServeTLS不再自动启用HTTP/2
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return nil
+	}
+	h2s := &http2.Server{MaxConcurrentStreams: maxStreams}
+	// ConfigureServer同时注册关闭钩子，平滑关闭时向HTTP/2连接发送GOAWAY
+	if err := http2.ConfigureServer(server, h2s); err != nil {
+		return err
+	}
+	if cleartext {
+		server.Handler = h2c.NewHandler(server.Handler, h2s)
+	}
+	return nil
+}
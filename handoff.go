@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 升级时传递给新进程的环境变量：继承的监听套接字为 键=文件描述符 的逗号分隔列表，
+// 键为 tcp:地址、unix:路径 或 udp:地址；新进程开始服务后向就绪管道写入READY=1
+const (
+	inheritedFDsEnv   = envPrefix + "INHERITED_FDS"
+	upgradeReadyFDEnv = envPrefix + "UPGRADE_READY_FD"
+)
+
+// errUpgradeInProgress 已有升级正在进行
+var errUpgradeInProgress = errors.New("upgrade already in progress")
+
+// sockets 进程的监听套接字，优先使用从上一个进程继承的套接字，升级时全部传递给新进程
+var sockets = newSocketHandoff()
+
+// fileSocket 可以复制出文件描述符的监听器或UDP连接，如*net.TCPListener、*net.UnixListener、*net.UDPConn
+type fileSocket interface {
+	File() (*os.File, error)
+}
+
+// handoffSocket 正在使用的一个监听套接字
+type handoffSocket struct {
+	key    string
+	socket fileSocket
+}
+
+// socketHandoff 在升级时新旧进程之间交接监听套接字，新进程直接接受连接，
+// 不需要重新绑定端口，也不会拒绝升级期间到达的连接
+type socketHandoff struct {
+	mu        sync.Mutex
+	inherited map[string]*os.File // 从上一个进程继承、尚未使用的套接字
+	active    []handoffSocket     // 正在使用的套接字，升级时传递给新进程
+	ready     *os.File            // 由上一个进程启动时报告就绪的管道，否则为nil
+	parent    int                 // 由上一个进程启动时为其PID，否则为0
+}
+
+// newSocketHandoff 从环境变量读取上一个进程传递的套接字，并清除这些环境变量，避免传给以后启动的进程
+func newSocketHandoff() *socketHandoff {
+	h := &socketHandoff{inherited: map[string]*os.File{}}
+	if fd, err := strconv.Atoi(os.Getenv(upgradeReadyFDEnv)); err == nil {
+		h.ready = os.NewFile(uintptr(fd), "upgrade-ready")
+		h.parent = os.Getppid()
+	}
+	for _, entry := range strings.Split(os.Getenv(inheritedFDsEnv), ",") {
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			continue
+		}
+		if fd, err := strconv.Atoi(entry[i+1:]); err == nil {
+			h.inherited[entry[:i]] = os.NewFile(uintptr(fd), entry[:i])
+		}
+	}
+	os.Unsetenv(inheritedFDsEnv)
+	os.Unsetenv(upgradeReadyFDEnv)
+	return h
+}
+
+// take 取出继承的套接字，没有时返回nil
+func (h *socketHandoff) take(key string) *os.File {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f := h.inherited[key]
+	delete(h.inherited, key)
+	return f
+}
+
+// track 记录正在使用的套接字，不能复制文件描述符的套接字不参与交接
+func (h *socketHandoff) track(key string, socket interface{}) {
+	fs, ok := socket.(fileSocket)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.active = append(h.active, handoffSocket{key: key, socket: fs})
+}
+
+// listener 返回继承的监听器，没有时调用create新建，并记录下来供升级时传递
+func (h *socketHandoff) listener(network, addr string, create func() (net.Listener, error)) (net.Listener, error) {
+	key := network + ":" + addr
+	var ln net.Listener
+	if f := h.take(key); f != nil {
+		var err error
+		ln, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid inherited socket %s: %w", key, err)
+		}
+		// 继承的Unix域套接字同样在正常退出时删除套接字文件
+		if ul, ok := ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(true)
+		}
+		logger.Infof("Using inherited socket %s", key)
+	} else {
+		var err error
+		if ln, err = create(); err != nil {
+			return nil, err
+		}
+	}
+	h.track(key, ln)
+	return ln, nil
+}
+
+// packetConn 返回继承的UDP套接字，没有时新建，并记录下来供升级时传递
+func (h *socketHandoff) packetConn(addr string) (net.PacketConn, error) {
+	key := "udp:" + addr
+	var pc net.PacketConn
+	if f := h.take(key); f != nil {
+		var err error
+		pc, err = net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid inherited socket %s: %w", key, err)
+		}
+		logger.Infof("Using inherited socket %s", key)
+	} else {
+		var err error
+		if pc, err = net.ListenPacket("udp", addr); err != nil {
+			return nil, err
+		}
+	}
+	h.track(key, pc)
+	return pc, nil
+}
+
+// files 复制正在使用的套接字的文件描述符，用于传递给新进程；调用方负责关闭返回的文件
+func (h *socketHandoff) files() ([]string, []*os.File, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var keys []string
+	var files []*os.File
+	for _, s := range h.active {
+		f, err := s.socket.File()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, fmt.Errorf("socket %s: %w", s.key, err)
+		}
+		keys = append(keys, s.key)
+		files = append(files, f)
+	}
+	return keys, files, nil
+}
+
+// closeUnused 关闭继承但新配置中不再使用的套接字
+func (h *socketHandoff) closeUnused() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var keys []string
+	for key, f := range h.inherited {
+		keys = append(keys, key)
+		f.Close()
+	}
+	h.inherited = map[string]*os.File{}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		logger.Warnf("Closing inherited sockets no longer configured: %s", strings.Join(keys, ", "))
+	}
+}
+
+// notifyReady 由上一个进程启动时通过管道报告已开始服务，返回是否为升级启动的进程
+func (h *socketHandoff) notifyReady() bool {
+	if h.ready == nil {
+		return false
+	}
+	if _, err := h.ready.Write([]byte("READY=1\n")); err != nil {
+		logger.Warnf("Failed to notify previous process %d: %v", h.parent, err)
+	}
+	h.ready.Close()
+	return true
+}
+
+// keepUnixSockets 升级成功后关闭监听器时保留Unix域套接字文件，新进程继续在该文件上接受连接
+func (h *socketHandoff) keepUnixSockets() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range h.active {
+		if ul, ok := s.socket.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+}
+
+// inheritedEnv 返回传递给新进程的环境变量，文件描述符从first开始依次编号
+func inheritedEnv(keys []string, first int) string {
+	entries := make([]string, len(keys))
+	for i, key := range keys {
+		entries[i] = key + "=" + strconv.Itoa(first+i)
+	}
+	return inheritedFDsEnv + "=" + strings.Join(entries, ",")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// stringSliceFlag 可重复指定的字符串参数
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// headerValue 一条待设置的请求头
+type headerValue struct {
+	name  string
+	value string
+}
+
+// parseSetHeaders 解析 name=value 形式的请求头设置规则
+func parseSetHeaders(rules []string) ([]headerValue, error) {
+	var headers []headerValue
+	for _, rule := range rules {
+		name, value, ok := strings.Cut(rule, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header rule %q, expected name=value", rule)
+		}
+		headers = append(headers, headerValue{name: http.CanonicalHeaderKey(name), value: strings.TrimSpace(value)})
+	}
+	return headers, nil
+}
+
+// applyHeaderRules 在内置请求头处理之后移除和设置请求头，
+// 先移除后设置，因此同一个头同时出现在两者中时以设置为准；
+// 头名称按HTTP语义不区分大小写
+func applyHeaderRules(req *http.Request, remove []string, set []headerValue) {
+	for _, name := range remove {
+		req.Header.Del(name)
+	}
+	for _, h := range set {
+		// Host头由req.Host决定，直接设置Header不会生效
+		if h.name == "Host" {
+			req.Host = h.value
+			continue
+		}
+		req.Header.Set(h.name, h.value)
+	}
+}
+
+// headerOps 一组请求头或响应头操作，按移除、设置、追加的顺序执行
+type headerOps struct {
+	Set    map[string]string `json:"set,omitempty" yaml:"set"`       // 覆盖已有的同名头
+	Add    map[string]string `json:"add,omitempty" yaml:"add"`       // 保留已有的同名头并追加一个值
+	Remove []string          `json:"remove,omitempty" yaml:"remove"` // 移除的头
+}
+
+// routeHeaders 路由配置中的请求头和响应头改写规则
+type routeHeaders struct {
+	Request  headerOps `json:"request,omitempty" yaml:"request"`   // 转发到后端前处理请求头
+	Response headerOps `json:"response,omitempty" yaml:"response"` // 返回给客户端前处理响应头
+}
+
+// normalize 检查头名称和取值并规范化头名称，按HTTP语义仅大小写不同的名称视为重复
+func (o headerOps) normalize() (headerOps, error) {
+	out := headerOps{}
+	for _, name := range o.Remove {
+		if err := validHeaderName(name); err != nil {
+			return out, err
+		}
+		out.Remove = append(out.Remove, http.CanonicalHeaderKey(strings.TrimSpace(name)))
+	}
+	var err error
+	if out.Set, err = normalizeHeaderMap(o.Set); err != nil {
+		return out, err
+	}
+	if out.Add, err = normalizeHeaderMap(o.Add); err != nil {
+		return out, err
+	}
+	return out, nil
+}
+
+func normalizeHeaderMap(m map[string]string) (map[string]string, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(m))
+	for name, value := range m {
+		if err := validHeaderName(name); err != nil {
+			return nil, err
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("header %s: value must not contain line breaks", name)
+		}
+		key := http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if _, dup := out[key]; dup {
+			return nil, fmt.Errorf("duplicate header %s", key)
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+// validHeaderName 检查头名称非空且不含空白和冒号
+func validHeaderName(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	return nil
+}
+
+// normalize 规范化请求和响应两组规则
+func (h routeHeaders) normalize() (*routeHeaders, error) {
+	req, err := h.Request.normalize()
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	resp, err := h.Response.normalize()
+	if err != nil {
+		return nil, fmt.Errorf("response: %w", err)
+	}
+	return &routeHeaders{Request: req, Response: resp}, nil
+}
+
+// apply 对头集合执行移除、设置和追加
+func (o headerOps) apply(h http.Header) {
+	for _, name := range o.Remove {
+		h.Del(name)
+	}
+	for name, value := range o.Set {
+		h.Set(name, value)
+	}
+	for name, value := range o.Add {
+		h.Add(name, value)
+	}
+}
+
+// applyRequest 对请求执行规则，设置Host时改写req.Host
+func (o headerOps) applyRequest(req *http.Request) {
+	o.apply(req.Header)
+	if host, ok := o.Set["Host"]; ok {
+		req.Header.Del("Host")
+		req.Host = host
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// backendHealth 单个后端的主动健康检查状态
+type backendHealth struct {
+	healthy   bool
+	successes int // 连续成功次数
+	failures  int // 连续失败次数
+	lastCheck time.Time
+	lastError string
+}
+
+// healthChecker 定期对各后端做主动健康检查（配置了路径时发送HTTP GET，否则建立TCP连接），
+// 连续失败达到阈值的后端被移出轮询，连续成功达到阈值后重新加入
+type healthChecker struct {
+	backends           func() []*url.URL
+	client             *http.Client
+	path               string
+	interval           time.Duration
+	timeout            time.Duration
+	unhealthyThreshold int
+	healthyThreshold   int
+
+	mu     sync.Mutex
+	status map[string]*backendHealth // 按后端地址索引
+}
+
+func newHealthChecker(backends func() []*url.URL, transport http.RoundTripper, path string, interval, timeout time.Duration, unhealthyThreshold, healthyThreshold int) *healthChecker {
+	return &healthChecker{
+		backends: backends,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			// 健康检查不跟随重定向，3xx也视为健康
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		path:               path,
+		interval:           interval,
+		timeout:            timeout,
+		unhealthyThreshold: unhealthyThreshold,
+		healthyThreshold:   healthyThreshold,
+		status:             make(map[string]*backendHealth),
+	}
+}
+
+// run 立即检查一次，之后按间隔周期检查
+func (c *healthChecker) run() {
+	c.checkAll()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.checkAll()
+	}
+}
+
+// checkAll 并发检查当前路由表中的全部后端，并清理已不在路由表中的后端状态
+func (c *healthChecker) checkAll() {
+	current := map[string]*url.URL{}
+	for _, b := range c.backends() {
+		current[b.String()] = b
+	}
+
+	c.mu.Lock()
+	for key := range c.status {
+		if current[key] == nil {
+			delete(c.status, key)
+		}
+	}
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for key, b := range current {
+		wg.Add(1)
+		go func(key string, b *url.URL) {
+			defer wg.Done()
+			c.record(key, c.check(b))
+		}(key, b)
+	}
+	wg.Wait()
+}
+
+// check 对单个后端执行一次健康检查
+func (c *healthChecker) check(backend *url.URL) error {
+	if c.path == "" {
+		conn, err := dialBackend(backend, c.timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	// 以/开头的路径相对后端主机，否则相对后端基础路径
+	target := backend.ResolveReference(&url.URL{Path: c.path})
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Host = backendHostHeader(backend)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, target)
+	}
+	return nil
+}
+
+// record 记录检查结果，健康状态变化时输出日志
+func (c *healthChecker) record(key string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.status[key]
+	if !ok {
+		// 新加入的后端在首次检查前视为健康
+		h = &backendHealth{healthy: true}
+		c.status[key] = h
+	}
+	h.lastCheck = time.Now()
+
+	if err != nil {
+		h.successes = 0
+		h.failures++
+		h.lastError = err.Error()
+		if h.healthy && h.failures >= c.unhealthyThreshold {
+			h.healthy = false
+			logger.Warnf("Backend %s marked unhealthy after %d failed health checks: %v", key, h.failures, err)
+		}
+		return
+	}
+
+	h.failures = 0
+	h.successes++
+	h.lastError = ""
+	if !h.healthy && h.successes >= c.healthyThreshold {
+		h.healthy = true
+		logger.Infof("Backend %s marked healthy after %d successful health checks", key, h.successes)
+	}
+}
+
+// isHealthy 判断后端是否通过健康检查，尚未检查过的后端视为健康
+func (c *healthChecker) isHealthy(backend *url.URL) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.status[backend.String()]
+	return !ok || h.healthy
+}
+
+// snapshot 返回后端健康检查状态的副本，尚未检查过时返回nil
+func (c *healthChecker) snapshot(backend *url.URL) *backendHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.status[backend.String()]
+	if !ok {
+		return nil
+	}
+	copied := *h
+	return &copied
+}
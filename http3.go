@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server 创建与TCP监听共用地址、TLS证书和处理函数的HTTP/3服务器，监听同一端口的UDP。
+// TCP上的响应携带Alt-Svc头，支持QUIC的客户端在后续请求中切换到HTTP/3，
+// 不支持或UDP被拦截的客户端继续使用HTTP/1.1或HTTP/2
+func newHTTP3Server(server *http.Server, addr string) *http3.Server {
+	h3 := &http3.Server{
+		Addr:      addr,
+		Handler:   server.Handler,
+		TLSConfig: http3.ConfigureTLSConfig(server.TLSConfig),
+	}
+	handler := server.Handler
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			h3.SetQuicHeaders(w.Header())
+		}
+		handler.ServeHTTP(w, r)
+	})
+	return h3
+}
+
+// waitForIdle 等待进行中的请求全部完成，ctx结束时返回false
+func waitForIdle(ctx context.Context, inFlight func() int64) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for inFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
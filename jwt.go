@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtConfig 路由配置中的JWT校验设置，未写出的字段使用-jwt-*参数
+type jwtConfig struct {
+	Disabled bool              `json:"disabled,omitempty" yaml:"disabled"` // 关闭该路由的JWT校验
+	JWKSURL  string            `json:"jwks_url,omitempty" yaml:"jwks_url"` // 获取校验公钥的JWKS地址
+	KeyFile  string            `json:"key_file,omitempty" yaml:"key_file"` // 校验公钥(PEM)文件，非PEM内容视为HMAC密钥
+	Issuer   string            `json:"issuer,omitempty" yaml:"issuer"`     // 要求的iss，为空时不检查
+	Audience string            `json:"audience,omitempty" yaml:"audience"` // 要求aud中包含的值，为空时不检查
+	Claims   map[string]string `json:"claims,omitempty" yaml:"claims"`     // 转发给后端的claim，claim名 -> 请求头名
+}
+
+// merge 返回用override中已设置的字段覆盖后的配置，同时设置key_file和jwks_url时以override为准
+func (c jwtConfig) merge(override jwtConfig) jwtConfig {
+	c.Disabled = override.Disabled
+	if override.JWKSURL != "" || override.KeyFile != "" {
+		c.JWKSURL, c.KeyFile = override.JWKSURL, override.KeyFile
+	}
+	if override.Issuer != "" {
+		c.Issuer = override.Issuer
+	}
+	if override.Audience != "" {
+		c.Audience = override.Audience
+	}
+	if override.Claims != nil {
+		c.Claims = override.Claims
+	}
+	return c
+}
+
+// parseClaimHeaders 解析claim=Header格式的claim转发规则
+func parseClaimHeaders(rules []string) (map[string]string, error) {
+	claims := map[string]string{}
+	for _, rule := range rules {
+		claim, header, ok := strings.Cut(rule, "=")
+		claim, header = strings.TrimSpace(claim), strings.TrimSpace(header)
+		if !ok || claim == "" || header == "" {
+			return nil, fmt.Errorf("invalid claim header %q, expected claim=Header", rule)
+		}
+		claims[claim] = header
+	}
+	return claims, nil
+}
+
+var (
+	// jwtLeeway 校验exp、nbf和iat时允许的时钟偏差
+	jwtLeeway time.Duration
+	// jwksRefresh JWKS的刷新间隔
+	jwksRefresh time.Duration
+	// defaultJWTConfig 由-jwt-*参数得到的JWT设置，路由的jwt配置在此基础上覆盖
+	defaultJWTConfig jwtConfig
+	// defaultJWTPolicy 由defaultJWTConfig创建的校验策略，用于未单独配置jwt的路由
+	defaultJWTPolicy *jwtPolicy
+)
+
+var (
+	errMissingToken = errors.New("missing bearer token")
+	errUnknownKey   = errors.New("no key found for token")
+)
+
+// jwtKeySource 按kid提供校验签名的密钥
+type jwtKeySource interface {
+	key(kid string) (interface{}, error)
+}
+
+// staticKey 从文件读取的固定密钥，忽略kid
+type staticKey struct {
+	k interface{}
+}
+
+func (s staticKey) key(string) (interface{}, error) {
+	return s.k, nil
+}
+
+// loadJWTKey 读取校验密钥文件：PEM格式的公钥或证书，其他内容去掉首尾空白后作为HMAC密钥
+func loadJWTKey(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		secret := bytes.TrimSpace(data)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("key file %s is empty", path)
+		}
+		return secret, nil
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+}
+
+// jwksCache 缓存JWKS中的公钥，超过刷新间隔或遇到未知kid时重新获取；
+// 获取失败时继续使用上次的公钥
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+	triedAt   time.Time
+}
+
+// jwksCaches 按地址共享的JWKS缓存，重新加载路由时不必重新获取
+var (
+	jwksCachesMu sync.Mutex
+	jwksCaches   = map[string]*jwksCache{}
+)
+
+func getJWKSCache(url string) *jwksCache {
+	jwksCachesMu.Lock()
+	defer jwksCachesMu.Unlock()
+	c, ok := jwksCaches[url]
+	if !ok {
+		c = &jwksCache{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+		jwksCaches[url] = c
+	}
+	return c
+}
+
+func (c *jwksCache) key(kid string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	_, known := c.keys[kid]
+	// 未知kid可能是密钥轮换，最多每10秒重新获取一次，避免伪造的kid导致频繁请求
+	if (now.Sub(c.fetchedAt) > jwksRefresh || !known) && now.Sub(c.triedAt) > 10*time.Second {
+		c.triedAt = now
+		if keys, err := c.fetch(); err != nil {
+			logger.Warnf("Failed to fetch JWKS from %s: %v", c.url, err)
+		} else {
+			c.keys, c.fetchedAt = keys, now
+		}
+	}
+	if k, ok := c.keys[kid]; ok {
+		return k, nil
+	}
+	// 令牌未带kid且JWKS中只有一个密钥时使用该密钥
+	if kid == "" && len(c.keys) == 1 {
+		for _, k := range c.keys {
+			return k, nil
+		}
+	}
+	return nil, errUnknownKey
+}
+
+// jsonWebKey JWKS中的一个密钥，支持RSA和EC公钥
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (c *jwksCache) fetch() (map[string]interface{}, error) {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		k, err := jwk.publicKey()
+		if err != nil {
+			logger.Warnf("Skipping JWKS key %q from %s: %v", jwk.Kid, c.url, err)
+			continue
+		}
+		keys[jwk.Kid] = k
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable keys")
+	}
+	return keys, nil
+}
+
+// publicKey 将JWK转换为Go的公钥
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// jwtPolicy 一条路由生效的JWT校验策略
+type jwtPolicy struct {
+	keys     jwtKeySource
+	issuer   string
+	audience string
+	claims   map[string]string // claim名 -> 规范化的请求头名
+}
+
+// newJWTPolicy 校验配置并创建JWT校验策略，未配置密钥来源或已关闭时返回nil表示不校验
+func newJWTPolicy(c jwtConfig) (*jwtPolicy, error) {
+	if c.Disabled || (c.JWKSURL == "" && c.KeyFile == "") {
+		return nil, nil
+	}
+	if c.JWKSURL != "" && c.KeyFile != "" {
+		return nil, fmt.Errorf("jwks_url and key_file cannot be used together")
+	}
+	p := &jwtPolicy{issuer: c.Issuer, audience: c.Audience, claims: map[string]string{}}
+	if c.JWKSURL != "" {
+		if !strings.HasPrefix(c.JWKSURL, "https://") && !strings.HasPrefix(c.JWKSURL, "http://") {
+			return nil, fmt.Errorf("invalid jwks_url %q", c.JWKSURL)
+		}
+		p.keys = getJWKSCache(c.JWKSURL)
+	} else {
+		k, err := loadJWTKey(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		p.keys = staticKey{k}
+	}
+	for claim, header := range c.Claims {
+		if header == "" || strings.ContainsAny(header, " \r\n:") {
+			return nil, fmt.Errorf("invalid header name %q for claim %s", header, claim)
+		}
+		p.claims[claim] = http.CanonicalHeaderKey(header)
+	}
+	return p, nil
+}
+
+// bearerToken 从Authorization头中取出Bearer令牌
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// authenticate 校验请求携带的JWT，返回令牌中的claim
+func (p *jwtPolicy) authenticate(r *http.Request) (map[string]interface{}, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, errMissingToken
+	}
+	return p.verify(token, time.Now())
+}
+
+// verify 校验令牌的签名和exp、nbf、iat、iss、aud
+func (p *jwtPolicy) verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+	key, err := p.keys.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return nil, fmt.Errorf("token has no exp")
+	}
+	if now.After(exp.Add(jwtLeeway)) {
+		return nil, fmt.Errorf("token expired at %s", exp.Format(time.RFC3339))
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(jwtLeeway).Before(nbf) {
+		return nil, fmt.Errorf("token not valid before %s", nbf.Format(time.RFC3339))
+	}
+	if iat, ok := numericClaim(claims, "iat"); ok && now.Add(jwtLeeway).Before(iat) {
+		return nil, fmt.Errorf("token issued in the future")
+	}
+	if p.issuer != "" && claims["iss"] != p.issuer {
+		return nil, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if p.audience != "" && !hasAudience(claims["aud"], p.audience) {
+		return nil, fmt.Errorf("token audience does not include %s", p.audience)
+	}
+	return claims, nil
+}
+
+// decodeSegment 解码base64url编码的JSON片段，数字保留为json.Number以便原样转发
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// numericClaim 读取以秒为单位的时间claim
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// hasAudience 判断aud（字符串或字符串数组）是否包含指定值
+func hasAudience(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyJWTSignature 按alg校验签名，密钥类型必须与算法一致，不接受none
+func verifyJWTSignature(alg string, key interface{}, signingInput string, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[len(alg)-min(len(alg), 3):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("alg %s does not match key type", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+	case strings.HasPrefix(alg, "PS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("alg %s does not match key type", alg)
+		}
+		if err := rsa.VerifyPSS(pub, hash, digest, sig, nil); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("alg %s does not match key type", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+	case strings.HasPrefix(alg, "HS"):
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("alg %s does not match key type", alg)
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	return nil
+}
+
+// forwardClaims 将配置的claim写入转发给后端的请求头。
+// 配置为claim的请求头总是先删除，客户端不能自行伪造；字符串和数字原样写入，数组以逗号连接，对象写为JSON
+func (p *jwtPolicy) forwardClaims(h http.Header, claims map[string]interface{}) {
+	names := make([]string, 0, len(p.claims))
+	for claim := range p.claims {
+		names = append(names, claim)
+	}
+	sort.Strings(names)
+	for _, claim := range names {
+		header := p.claims[claim]
+		h.Del(header)
+		if value, ok := claimString(claims[claim]); ok {
+			h.Set(header, value)
+		}
+	}
+}
+
+func claimString(v interface{}) (string, bool) {
+	switch c := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return c, true
+	case json.Number:
+		return c.String(), true
+	case bool:
+		return fmt.Sprint(c), true
+	case []interface{}:
+		items := make([]string, 0, len(c))
+		for _, item := range c {
+			if s, ok := claimString(item); ok {
+				items = append(items, s)
+			}
+		}
+		return strings.Join(items, ","), true
+	}
+	data, err := json.Marshal(v)
+	return string(data), err == nil
+}
+
+// challenge 返回401响应的WWW-Authenticate头，未携带令牌时不附带错误信息
+func (p *jwtPolicy) challenge(err error) string {
+	if errors.Is(err, errMissingToken) {
+		return `Bearer`
+	}
+	return `Bearer error="invalid_token"`
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// adaptiveLimiter 根据后端延迟动态调整最大并发请求数（参考Netflix concurrency-limits的Gradient算法）。
+// 短期延迟明显高于长期平均延迟时收缩并发上限，延迟平稳时逐步放大，后端出错或超时时按比例回退。
+type adaptiveLimiter struct {
+	minLimit  float64
+	maxLimit  float64
+	smoothing float64
+	tolerance float64
+	backoff   float64
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	longRTT  float64 // 长期平均延迟（秒，指数加权移动平均）
+}
+
+func newAdaptiveLimiter(initial, min, max int, smoothing, tolerance, backoff float64) *adaptiveLimiter {
+	return &adaptiveLimiter{
+		minLimit:  float64(min),
+		maxLimit:  float64(max),
+		smoothing: smoothing,
+		tolerance: tolerance,
+		backoff:   backoff,
+		limit:     float64(initial),
+	}
+}
+
+// acquire 尝试占用一个并发名额，已达到上限时返回false
+func (l *adaptiveLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if float64(l.inflight) >= math.Floor(l.limit) {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// release 释放一个并发名额
+func (l *adaptiveLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+}
+
+// current 返回当前并发上限和在途请求数
+func (l *adaptiveLimiter) current() (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit), l.inflight
+}
+
+// observe 记录一次后端调用的延迟，dropped表示后端出错或超时
+func (l *adaptiveLimiter) observe(rtt time.Duration, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	old := l.limit
+	if dropped {
+		l.limit = math.Max(l.minLimit, l.limit*l.backoff)
+	} else {
+		sample := rtt.Seconds()
+		if sample <= 0 {
+			return
+		}
+		if l.longRTT == 0 {
+			l.longRTT = sample
+		} else {
+			l.longRTT = l.longRTT*0.95 + sample*0.05
+		}
+
+		// 请求量不足上限一半时不放大上限，避免空闲时上限无限增长
+		if float64(l.inflight) < l.limit/2 && sample <= l.longRTT*l.tolerance {
+			return
+		}
+
+		gradient := math.Max(0.5, math.Min(1.0, l.tolerance*l.longRTT/sample))
+		newLimit := l.limit*gradient + math.Sqrt(l.limit)
+		newLimit = l.limit*(1-l.smoothing) + newLimit*l.smoothing
+		l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, newLimit))
+	}
+
+	if int(old) != int(l.limit) {
+		logger.Debugf("Adaptive concurrency limit: %d -> %d (rtt=%s, avg=%.3fs, dropped=%t)", int(old), int(l.limit), rtt, l.longRTT, dropped)
+	}
+}
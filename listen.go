@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isUnixSocketPath 判断监听地址是否为Unix域套接字文件路径
+func isUnixSocketPath(addr string) bool {
+	return strings.HasPrefix(addr, "/") || strings.HasPrefix(addr, "./") || strings.HasPrefix(addr, "unix:")
+}
+
+// listenUnix 在指定路径监听Unix域套接字，启动前清理残留的套接字文件并设置文件权限。
+// 监听器关闭（如优雅退出）时Go会自动删除套接字文件
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	path = strings.TrimPrefix(path, "unix:")
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket 删除上次未正常退出残留的套接字文件，
+// 若该套接字仍有进程在监听或路径不是套接字则返回错误
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	logger.Infof("Removing stale socket file %s", path)
+	return os.Remove(path)
+}
+
+// parseFileMode 解析八进制形式的文件权限，如 0660
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid file mode %q: %w", s, err)
+	}
+	return os.FileMode(mode), nil
+}
+
+// listenerConfig -listen指定的一个额外监听器
+type listenerConfig struct {
+	addr       string // host:port或Unix域套接字路径
+	name       string // 日志和路由名称中使用的名称，未指定时为addr
+	tls        bool   // 是否监听HTTPS，证书与主监听器相同
+	routesFile string // 该监听器独立的路由文件(JSON)，为空时与主监听器共用全局路由
+}
+
+// parseListenFlag 解析 addr[;tls][;name=N][;routes=FILE] 形式的监听器
+func parseListenFlag(value string) (*listenerConfig, error) {
+	parts := strings.Split(value, ";")
+	l := &listenerConfig{addr: strings.TrimSpace(parts[0])}
+	if l.addr == "" {
+		return nil, fmt.Errorf("invalid listener %q, expected addr[;tls][;name=N][;routes=FILE]", value)
+	}
+	if !isUnixSocketPath(l.addr) {
+		if _, _, err := net.SplitHostPort(l.addr); err != nil {
+			return nil, fmt.Errorf("invalid listener address %q: %w", l.addr, err)
+		}
+	}
+	for _, p := range parts[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(p), "=")
+		switch key {
+		case "tls":
+			l.tls = true
+		case "name":
+			l.name = strings.TrimSpace(val)
+		case "routes":
+			l.routesFile = strings.TrimSpace(val)
+		default:
+			return nil, fmt.Errorf("unknown listener option %q in %q", key, value)
+		}
+	}
+	if l.name == "" {
+		l.name = l.addr
+	}
+	return l, nil
+}
+
+// scope 返回该监听器的路由集合名称，共用全局路由时为空
+func (l *listenerConfig) scope() string {
+	if l == nil || l.routesFile == "" {
+		return ""
+	}
+	return l.name
+}
+
+// scopedListener 为接受的连接记录所属的监听器，请求据此只匹配该监听器的路由
+type scopedListener struct {
+	net.Listener
+	config *listenerConfig
+}
+
+func (l *scopedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &scopedConn{Conn: c, listener: l.config}, nil
+}
+
+type scopedConn struct {
+	net.Conn
+	listener *listenerConfig
+}
+
+// connListener 返回连接所属的额外监听器，主监听器的连接返回nil
+func connListener(c net.Conn) *listenerConfig {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if sc, ok := c.(*scopedConn); ok {
+		return sc.listener
+	}
+	return nil
+}
+
+// listen 在TCP地址或Unix域套接字路径上监听，升级启动时使用上一个进程传递的套接字
+func listen(addr string, socketMode os.FileMode) (net.Listener, error) {
+	if isUnixSocketPath(addr) {
+		path := strings.TrimPrefix(addr, "unix:")
+		return sockets.listener("unix", path, func() (net.Listener, error) {
+			return listenUnix(path, socketMode)
+		})
+	}
+	return listenTCP(addr)
+}
+
+// listenTCP 在TCP地址上监听，升级启动时使用上一个进程传递的套接字
+func listenTCP(addr string) (net.Listener, error) {
+	return sockets.listener("tcp", addr, func() (net.Listener, error) {
+		return net.Listen("tcp", addr)
+	})
+}
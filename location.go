@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// publicPath 将后端地址空间中的路径映射回代理的前缀空间，路径不在后端基础路径下时返回false
+func publicPath(backend *url.URL, path, prefix string) (string, bool) {
+	base := strings.TrimSuffix(backend.Path, "/")
+	if path != base && !strings.HasPrefix(path, base+"/") {
+		return "", false
+	}
+	return prefix + strings.TrimPrefix(strings.TrimPrefix(path, base), "/"), true
+}
+
+// sameOrigin 判断两个URL的协议和主机（含默认端口）是否相同
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(hostWithPort(a), hostWithPort(b))
+}
+
+// hostWithPort 返回带端口的主机，未写端口时补上协议的默认端口
+func hostWithPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return u.Host + ":443"
+	}
+	return u.Host + ":80"
+}
+
+// rewriteLocation 将指向后端的Location改写为代理前缀下的路径，使重定向不绕过代理。
+// 绝对地址依次与当前路由和路由表中各路由的后端比较；以/开头的地址视为当前后端上的路径。
+// 不属于任何后端的地址原样返回
+func rewriteLocation(location string, table *routeTable, current *route, backend *url.URL, currentPrefix string) (string, bool) {
+	u, err := url.Parse(location)
+	if err != nil || u.Opaque != "" {
+		return location, false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		if !strings.HasPrefix(u.Path, "/") {
+			// 相对路径由客户端相对当前地址解析，已经在代理的前缀空间内
+			return location, false
+		}
+		path, ok := publicPath(backend, u.Path, currentPrefix)
+		if !ok {
+			return location, false
+		}
+		u.Path, u.RawPath = path, ""
+		return u.String(), true
+	}
+
+	candidates := append([]*route{current}, table.routes...)
+	for _, rt := range candidates {
+		// 其他主机路由的前缀位于别的域名下，不能改写为本站路径
+		if rt != current && len(rt.hosts) > 0 {
+			continue
+		}
+		// 其他监听器的路由不在本监听器的路径空间内
+		if rt != current && rt != table.defaultRoute && rt.listener != current.listener {
+			continue
+		}
+		for _, b := range rt.allBackends() {
+			if !sameOrigin(u, b) {
+				continue
+			}
+			prefix := rt.prefix
+			if rt == current {
+				prefix = currentPrefix
+			}
+			if path, ok := publicPath(b, u.Path, prefix); ok {
+				rewritten := &url.URL{Path: path, RawQuery: u.RawQuery, Fragment: u.Fragment}
+				return rewritten.String(), true
+			}
+		}
+	}
+	return location, false
+}
+
+// rewriteLocationHeaders 改写响应中的Location和Content-Location头
+func rewriteLocationHeaders(resp *http.Response, table *routeTable, current *route, backend *url.URL, currentPrefix string) {
+	info := getRequestInfo(resp.Request)
+	for _, name := range []string{"Location", "Content-Location"} {
+		value := resp.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		if rewritten, ok := rewriteLocation(value, table, current, backend, currentPrefix); ok {
+			info.log.Infof("Rewrote %s header: %s -> %s", name, value, rewritten)
+			resp.Header.Set(name, rewritten)
+		}
+	}
+}
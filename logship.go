@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// 日志发送的批量大小、单次写出的超时和发送失败后的重试间隔
+const (
+	logShipBatch      = 256
+	logShipTimeout    = 10 * time.Second
+	logShipMinBackoff = time.Second
+	logShipMaxBackoff = 30 * time.Second
+)
+
+// accessLogMessage 访问日志条目的消息，未设置-access-log时用于在主日志中识别访问日志
+const accessLogMessage = "Request completed"
+
+// shipEntry 一条待发送的日志，line为不带换行的JSON
+type shipEntry struct {
+	kind  string // access 或 log
+	level logrus.Level
+	time  time.Time
+	line  []byte
+}
+
+// logSink 远程日志目标，write失败时由logShipper重试同一批日志
+type logSink interface {
+	write(batch []shipEntry) error
+	close()
+}
+
+// parseLogSink 按URL创建远程日志目标：
+// syslog://host:514（UDP）、syslog+tcp://host:514、tcp://host:port和udp://host:port（每行一条JSON）、
+// kafka://broker1:9092,broker2:9092/topic
+func parseLogSink(target string) (logSink, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in %q", target)
+	}
+	switch u.Scheme {
+	case "tcp", "udp":
+		return &netSink{network: u.Scheme, addr: u.Host}, nil
+	case "syslog", "syslog+udp", "syslog+tcp":
+		network := "udp"
+		if u.Scheme == "syslog+tcp" {
+			network = "tcp"
+		}
+		hostname, _ := os.Hostname()
+		if hostname == "" {
+			hostname = "-"
+		}
+		return &netSink{network: network, addr: u.Host, syslog: true, hostname: hostname}, nil
+	case "kafka":
+		topic := strings.Trim(u.Path, "/")
+		if topic == "" {
+			return nil, fmt.Errorf("missing kafka topic in %q, expected kafka://broker:9092/topic", target)
+		}
+		return &kafkaSink{writer: &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(u.Host, ",")...),
+			Topic:        topic,
+			Balancer:     &kafka.LeastBytes{},
+			MaxAttempts:  1,
+			BatchSize:    logShipBatch,
+			BatchTimeout: 10 * time.Millisecond,
+			WriteTimeout: logShipTimeout,
+			RequiredAcks: kafka.RequireOne,
+		}}, nil
+	}
+	return nil, fmt.Errorf("unsupported log sink scheme %q", u.Scheme)
+}
+
+// netSink 通过TCP或UDP发送日志，TCP连接断开后在下次发送时重连。
+// syslog按RFC 5424格式化，TCP时按RFC 6587以长度作为帧前缀；其余每条日志为一行JSON，UDP时每条一个数据报
+type netSink struct {
+	network  string
+	addr     string
+	syslog   bool
+	hostname string
+	conn     net.Conn
+}
+
+// syslogSeverity 将日志级别映射为syslog严重程度
+func syslogSeverity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return 2
+	case logrus.ErrorLevel:
+		return 3
+	case logrus.WarnLevel:
+		return 4
+	case logrus.InfoLevel:
+		return 6
+	}
+	return 7
+}
+
+// syslogFacilityLocal0 syslog设施local0
+const syslogFacilityLocal0 = 16
+
+func (s *netSink) frame(e shipEntry) []byte {
+	if !s.syslog {
+		if s.network == "udp" {
+			return e.line
+		}
+		return append(append([]byte(nil), e.line...), '\n')
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s st_proxy %d %s - %s", syslogFacilityLocal0*8+syslogSeverity(e.level),
+		e.time.Format(time.RFC3339Nano), s.hostname, os.Getpid(), e.kind, e.line)
+	if s.network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	return []byte(msg)
+}
+
+func (s *netSink) write(batch []shipEntry) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, logShipTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(logShipTimeout))
+	if s.network == "udp" {
+		for _, e := range batch {
+			if _, err := s.conn.Write(s.frame(e)); err != nil {
+				s.close()
+				return err
+			}
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, e := range batch {
+		buf.Write(s.frame(e))
+	}
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		// 写出一部分后失败时整批重发，接收端可能收到重复的日志
+		s.close()
+		return err
+	}
+	return nil
+}
+
+func (s *netSink) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// kafkaSink 将日志写入Kafka主题，消息的key为日志类型
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+func (s *kafkaSink) write(batch []shipEntry) error {
+	messages := make([]kafka.Message, len(batch))
+	for i, e := range batch {
+		messages[i] = kafka.Message{Key: []byte(e.kind), Value: e.line, Time: e.time}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), logShipTimeout)
+	defer cancel()
+	return s.writer.WriteMessages(ctx, messages...)
+}
+
+func (s *kafkaSink) close() {
+	s.writer.Close()
+}
+
+// logShipper 将日志异步发送到远程目标：日志先进入有界队列，发送goroutine批量写出；
+// 远程目标不可用时按退避间隔重试，期间队列已满则丢弃新日志并计数，不阻塞请求处理
+type logShipper struct {
+	target string
+	sink   logSink
+	queue  chan shipEntry
+
+	dropped atomic.Int64
+	done    chan struct{}
+	flushed chan struct{}
+}
+
+// newLogShipper 创建logShipper并启动发送goroutine，buffer为队列中最多保留的日志条数
+func newLogShipper(target string, buffer int) (*logShipper, error) {
+	sink, err := parseLogSink(target)
+	if err != nil {
+		return nil, err
+	}
+	s := &logShipper{
+		target:  target,
+		sink:    sink,
+		queue:   make(chan shipEntry, buffer),
+		done:    make(chan struct{}),
+		flushed: make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// enqueue 将日志放入队列，队列已满时丢弃
+func (s *logShipper) enqueue(e shipEntry) {
+	select {
+	case s.queue <- e:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// run 批量发送队列中的日志，失败时保留当前批次并退避重试；shutdown时尽量发送剩余日志后退出
+func (s *logShipper) run() {
+	defer close(s.flushed)
+	defer s.sink.close()
+	backoff := time.Duration(0)
+	var batch []shipEntry
+	for {
+		if len(batch) == 0 {
+			select {
+			case e := <-s.queue:
+				batch = append(batch, e)
+			case <-s.done:
+				s.drain(nil)
+				return
+			}
+		}
+	fill:
+		for len(batch) < logShipBatch {
+			select {
+			case e := <-s.queue:
+				batch = append(batch, e)
+			default:
+				break fill
+			}
+		}
+
+		if err := s.sink.write(batch); err != nil {
+			if backoff == 0 {
+				localLog().Warnf("Failed to ship %d log entries to %s, retrying: %v", len(batch), s.target, err)
+				backoff = logShipMinBackoff
+			} else if backoff = backoff * 2; backoff > logShipMaxBackoff {
+				backoff = logShipMaxBackoff
+			}
+			select {
+			case <-time.After(backoff):
+			case <-s.done:
+				s.drain(batch)
+				return
+			}
+			continue
+		}
+		if backoff != 0 {
+			logger.Infof("Log shipping to %s recovered", s.target)
+			backoff = 0
+		}
+		batch = batch[:0]
+		if dropped := s.dropped.Swap(0); dropped > 0 {
+			logger.Warnf("Dropped %d log entries because the log shipping queue was full", dropped)
+		}
+	}
+}
+
+// drain 退出前发送未发出的批次和队列中剩余的日志，只尝试一次，失败时丢弃
+func (s *logShipper) drain(batch []shipEntry) {
+	for {
+		select {
+		case e := <-s.queue:
+			if batch = append(batch, e); len(batch) < logShipBatch {
+				continue
+			}
+		default:
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.sink.write(batch); err != nil {
+			localLog().Warnf("Failed to ship remaining log entries to %s: %v", s.target, err)
+			return
+		}
+		batch = batch[:0]
+	}
+}
+
+// shutdown 发送剩余的日志，最多等待到ctx结束
+func (s *logShipper) shutdown(ctx context.Context) {
+	close(s.done)
+	select {
+	case <-s.flushed:
+	case <-ctx.Done():
+		localLog().Warn("Timed out shipping remaining log entries")
+	}
+}
+
+// logShipSkipKey 标记只写本地日志、不发送到远程目标的条目
+type logShipSkipKey struct{}
+
+// localLog 返回只写本地日志的entry，用于发送失败的提示，避免远程目标不可用时反复产生新的待发送日志
+func localLog() *logrus.Entry {
+	return logger.WithContext(context.WithValue(context.Background(), logShipSkipKey{}, true))
+}
+
+// logShipHook 将选中的日志复制到logShipper，本地日志的格式和输出不受影响
+type logShipHook struct {
+	shipper   *logShipper
+	formatter logrus.Formatter
+	access    bool // 该logger是单独的访问日志
+	kinds     map[string]bool
+}
+
+// 可以发送的日志类型：access为访问日志，error为主日志中error及以上级别的日志，all为主日志中访问日志以外的全部条目
+var logShipKinds = []string{"access", "error", "all"}
+
+// newLogShipHook 创建发送日志的hook，发送的日志由formatter格式化为一行JSON
+func newLogShipHook(shipper *logShipper, formatter *logrus.JSONFormatter, kinds []string, access bool) (*logShipHook, error) {
+	h := &logShipHook{
+		shipper:   shipper,
+		formatter: formatter,
+		access:    access,
+		kinds:     map[string]bool{},
+	}
+	for _, kind := range kinds {
+		known := false
+		for _, k := range logShipKinds {
+			known = known || k == kind
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown log kind %q, expected %s", kind, strings.Join(logShipKinds, ", "))
+		}
+		h.kinds[kind] = true
+	}
+	return h, nil
+}
+
+func (h *logShipHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *logShipHook) Fire(e *logrus.Entry) error {
+	if e.Context != nil && e.Context.Value(logShipSkipKey{}) != nil {
+		return nil
+	}
+	kind := "log"
+	if h.access || (accessLogger == logger && e.Message == accessLogMessage) {
+		kind = "access"
+	}
+	switch {
+	case kind == "access" && h.kinds["access"]:
+	case kind == "log" && (h.kinds["all"] || h.kinds["error"] && e.Level <= logrus.ErrorLevel):
+	default:
+		return nil
+	}
+	line, err := h.formatter.Format(e)
+	if err != nil {
+		return err
+	}
+	h.shipper.enqueue(shipEntry{kind: kind, level: e.Level, time: e.Time, line: bytes.TrimRight(line, "\n")})
+	return nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets 请求延迟直方图的桶上限（秒），与Prometheus客户端默认值相同
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricLabels 请求指标的标签
+type metricLabels struct {
+	route   string
+	backend string
+	tenant  string // 路由所属的租户，为空时不输出该标签
+}
+
+// requestLabels 按状态码区分的请求计数标签
+type requestLabels struct {
+	metricLabels
+	code int
+}
+
+// errorLabels 代理错误计数标签
+type errorLabels struct {
+	metricLabels
+	reason string
+}
+
+// thresholdLabels 超过慢请求或大响应阈值的计数标签
+type thresholdLabels struct {
+	metricLabels
+	kind string
+}
+
+// apiKeyLabels API Key请求计数标签
+type apiKeyLabels struct {
+	key    string
+	result string
+}
+
+// mirrorLabels 影子请求计数标签
+type mirrorLabels struct {
+	route  string
+	result string
+}
+
+// histogram 累积直方图
+type histogram struct {
+	counts []uint64 // 与latencyBuckets一一对应，最后一个为+Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range latencyBuckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.counts[len(latencyBuckets)]++
+	h.sum += v
+	h.count++
+}
+
+// proxyMetrics 以Prometheus文本格式导出的代理指标
+type proxyMetrics struct {
+	connections atomic.Int64 // 客户端连接数
+
+	mu         sync.Mutex
+	requests   map[requestLabels]uint64
+	durations  map[metricLabels]*histogram
+	errors     map[errorLabels]uint64
+	thresholds map[thresholdLabels]uint64
+	certExpiry map[string]time.Time // 按后端主机记录证书最早过期时间
+	apiKeys    map[apiKeyLabels]uint64
+	mirrors    map[mirrorLabels]uint64
+
+	// 抓取时读取的当前值：正在处理的请求数和各后端是否健康
+	inFlight       func() int64
+	backendHealthy func() map[string]bool
+}
+
+func newProxyMetrics() *proxyMetrics {
+	return &proxyMetrics{
+		requests:   make(map[requestLabels]uint64),
+		durations:  make(map[metricLabels]*histogram),
+		errors:     make(map[errorLabels]uint64),
+		thresholds: make(map[thresholdLabels]uint64),
+		certExpiry: make(map[string]time.Time),
+		apiKeys:    make(map[apiKeyLabels]uint64),
+		mirrors:    make(map[mirrorLabels]uint64),
+	}
+}
+
+// requestMetricLabels 返回请求的路由和后端标签，未经过路由（如命中缓存）时为空
+func requestMetricLabels(info *requestInfo) metricLabels {
+	var l metricLabels
+	if info.route != nil {
+		l.route = info.route.name()
+		l.tenant = info.route.tenant
+	}
+	if info.backend != nil {
+		l.backend = info.backend.String()
+	}
+	return l
+}
+
+// observeRequest 记录一个已完成的请求
+func (m *proxyMetrics) observeRequest(info *requestInfo, code int) {
+	labels := requestMetricLabels(info)
+	elapsed := time.Since(info.start).Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestLabels{labels, code}]++
+	h, ok := m.durations[labels]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		m.durations[labels] = h
+	}
+	h.observe(elapsed)
+}
+
+// observeError 记录一次请求后端失败
+func (m *proxyMetrics) observeError(info *requestInfo, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.errors[errorLabels{requestMetricLabels(info), reason}]++
+}
+
+// observeThreshold 记录一次超过慢请求或大响应阈值的请求
+func (m *proxyMetrics) observeThreshold(info *requestInfo, kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.thresholds[thresholdLabels{requestMetricLabels(info), kind}]++
+}
+
+// observeAPIKey 记录一次API Key检查，Key无效或缺失时名称为空
+func (m *proxyMetrics) observeAPIKey(name, result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.apiKeys[apiKeyLabels{name, result}]++
+}
+
+// observeMirror 记录一次影子请求的结果：状态码类别（如2xx）、error、dropped或skipped
+func (m *proxyMetrics) observeMirror(route, result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mirrors[mirrorLabels{route, result}]++
+}
+
+// setCertExpiry 记录后端证书的过期时间
+func (m *proxyMetrics) setCertExpiry(host string, notAfter time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.certExpiry[host] = notAfter
+}
+
+// trackConnState 作为http.Server.ConnState统计客户端连接数
+func (m *proxyMetrics) trackConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		m.connections.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		m.connections.Add(-1)
+	}
+}
+
+// ServeHTTP 以Prometheus文本格式输出全部指标
+func (m *proxyMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	writeHeader := func(name, kind, help string) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	if m.inFlight != nil {
+		writeHeader("st_proxy_requests_in_flight", "gauge", "Requests currently being handled.")
+		fmt.Fprintf(out, "st_proxy_requests_in_flight %d\n", m.inFlight())
+	}
+	writeHeader("st_proxy_open_connections", "gauge", "Open client connections.")
+	fmt.Fprintf(out, "st_proxy_open_connections %d\n", m.connections.Load())
+
+	m.mu.Lock()
+	requests := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		requests = append(requests, l)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].metricLabels != requests[j].metricLabels {
+			return lessLabels(requests[i].metricLabels, requests[j].metricLabels)
+		}
+		return requests[i].code < requests[j].code
+	})
+	writeHeader("st_proxy_requests_total", "counter", "Completed requests by route, backend and status code.")
+	for _, l := range requests {
+		fmt.Fprintf(out, "st_proxy_requests_total{%s,code=\"%d\"} %d\n", l.metricLabels, l.code, m.requests[l])
+	}
+
+	durations := make([]metricLabels, 0, len(m.durations))
+	for l := range m.durations {
+		durations = append(durations, l)
+	}
+	sort.Slice(durations, func(i, j int) bool { return lessLabels(durations[i], durations[j]) })
+	writeHeader("st_proxy_request_duration_seconds", "histogram", "Request latency by route and backend.")
+	for _, l := range durations {
+		h := m.durations[l]
+		for i, upper := range latencyBuckets {
+			fmt.Fprintf(out, "st_proxy_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", l, strconv.FormatFloat(upper, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(out, "st_proxy_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, h.counts[len(latencyBuckets)])
+		fmt.Fprintf(out, "st_proxy_request_duration_seconds_sum{%s} %g\n", l, h.sum)
+		fmt.Fprintf(out, "st_proxy_request_duration_seconds_count{%s} %d\n", l, h.count)
+	}
+
+	failures := make([]errorLabels, 0, len(m.errors))
+	for l := range m.errors {
+		failures = append(failures, l)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].metricLabels != failures[j].metricLabels {
+			return lessLabels(failures[i].metricLabels, failures[j].metricLabels)
+		}
+		return failures[i].reason < failures[j].reason
+	})
+	writeHeader("st_proxy_backend_errors_total", "counter", "Failed backend requests by route, backend and reason.")
+	for _, l := range failures {
+		fmt.Fprintf(out, "st_proxy_backend_errors_total{%s,reason=%q} %d\n", l.metricLabels, l.reason, m.errors[l])
+	}
+
+	exceeded := make([]thresholdLabels, 0, len(m.thresholds))
+	for l := range m.thresholds {
+		exceeded = append(exceeded, l)
+	}
+	sort.Slice(exceeded, func(i, j int) bool {
+		if exceeded[i].metricLabels != exceeded[j].metricLabels {
+			return lessLabels(exceeded[i].metricLabels, exceeded[j].metricLabels)
+		}
+		return exceeded[i].kind < exceeded[j].kind
+	})
+	if len(exceeded) > 0 {
+		writeHeader("st_proxy_threshold_exceeded_total", "counter", "Requests exceeding the slow request or large response threshold by route, backend and kind.")
+	}
+	for _, l := range exceeded {
+		fmt.Fprintf(out, "st_proxy_threshold_exceeded_total{%s,kind=%q} %d\n", l.metricLabels, l.kind, m.thresholds[l])
+	}
+
+	keys := make([]apiKeyLabels, 0, len(m.apiKeys))
+	for l := range m.apiKeys {
+		keys = append(keys, l)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].key != keys[j].key {
+			return keys[i].key < keys[j].key
+		}
+		return keys[i].result < keys[j].result
+	})
+	if len(keys) > 0 {
+		writeHeader("st_proxy_api_key_requests_total", "counter", "API key checks by key name and result.")
+	}
+	for _, l := range keys {
+		fmt.Fprintf(out, "st_proxy_api_key_requests_total{key=%q,result=%q} %d\n", l.key, l.result, m.apiKeys[l])
+	}
+
+	mirrors := make([]mirrorLabels, 0, len(m.mirrors))
+	for l := range m.mirrors {
+		mirrors = append(mirrors, l)
+	}
+	sort.Slice(mirrors, func(i, j int) bool {
+		if mirrors[i].route != mirrors[j].route {
+			return mirrors[i].route < mirrors[j].route
+		}
+		return mirrors[i].result < mirrors[j].result
+	})
+	if len(mirrors) > 0 {
+		writeHeader("st_proxy_mirror_requests_total", "counter", "Mirrored requests to shadow backends by route and result.")
+	}
+	for _, l := range mirrors {
+		fmt.Fprintf(out, "st_proxy_mirror_requests_total{route=%q,result=%q} %d\n", l.route, l.result, m.mirrors[l])
+	}
+
+	hosts := make([]string, 0, len(m.certExpiry))
+	for host := range m.certExpiry {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	writeHeader("st_proxy_backend_cert_expiry_timestamp_seconds", "gauge", "Earliest certificate expiry of each HTTPS backend.")
+	for _, host := range hosts {
+		fmt.Fprintf(out, "st_proxy_backend_cert_expiry_timestamp_seconds{host=%q} %d\n", host, m.certExpiry[host].Unix())
+	}
+	m.mu.Unlock()
+
+	if m.backendHealthy != nil {
+		health := m.backendHealthy()
+		backends := make([]string, 0, len(health))
+		for b := range health {
+			backends = append(backends, b)
+		}
+		sort.Strings(backends)
+		writeHeader("st_proxy_backend_healthy", "gauge", "Whether the backend is currently eligible for load balancing.")
+		for _, b := range backends {
+			value := 0
+			if health[b] {
+				value = 1
+			}
+			fmt.Fprintf(out, "st_proxy_backend_healthy{backend=%q} %d\n", b, value)
+		}
+	}
+}
+
+// String 格式化为Prometheus标签
+func (l metricLabels) String() string {
+	if l.tenant != "" {
+		return fmt.Sprintf("route=%q,backend=%q,tenant=%q", l.route, l.backend, l.tenant)
+	}
+	return fmt.Sprintf("route=%q,backend=%q", l.route, l.backend)
+}
+
+func lessLabels(a, b metricLabels) bool {
+	if a.route != b.route {
+		return a.route < b.route
+	}
+	return a.backend < b.backend
+}
+
+// proxyErrorReason 将代理错误归类为指标中的原因标签，与ErrorHandler返回的状态码对应
+func proxyErrorReason(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), strings.Contains(err.Error(), "timeout"):
+		return "timeout"
+	case strings.Contains(err.Error(), "connection refused"):
+		return "connection_refused"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case isResponseTooLarge(err):
+		return "response_too_large"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// middleware 请求和响应改写的扩展点，用于在不修改main.go的情况下添加自定义处理。
+// 在同一目录下新增一个文件，实现该接口并在init()中调用registerMiddleware注册，编译后即可在配置中按名称启用。
+//
+// request在内置的路径映射、查询参数和请求头处理之后、发往后端之前调用，重试时只调用一次；
+// response在内置的响应头、Cookie和响应体改写之后、写入缓存和返回给客户端之前调用。
+// 两者返回错误时请求以middlewareError中的状态码结束，其他错误返回502。
+// 处理中可通过getRequestInfo取得请求的路由、后端和日志记录器
+type middleware interface {
+	request(req *http.Request) error
+	response(resp *http.Response) error
+}
+
+// middlewareFactory 按路由配置中的参数创建中间件，每条路由、每次重新加载路由都会创建新的实例
+type middlewareFactory func(config map[string]interface{}) (middleware, error)
+
+// middlewareError 中间件拒绝请求时返回的错误，status为返回给客户端的状态码
+type middlewareError struct {
+	status  int
+	message string
+}
+
+func (e *middlewareError) Error() string {
+	return fmt.Sprintf("%d %s", e.status, e.message)
+}
+
+// rejectRequest 返回以指定状态码结束请求的错误，message为空时使用状态码的标准文本
+func rejectRequest(status int, message string) error {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return &middlewareError{status: status, message: message}
+}
+
+var (
+	middlewareMu        sync.Mutex
+	middlewareFactories = map[string]middlewareFactory{}
+)
+
+// registerMiddleware 按名称注册中间件，名称重复时panic
+func registerMiddleware(name string, factory middlewareFactory) {
+	middlewareMu.Lock()
+	defer middlewareMu.Unlock()
+	if _, dup := middlewareFactories[name]; dup {
+		panic("duplicate middleware " + name)
+	}
+	middlewareFactories[name] = factory
+}
+
+// middlewareNames 返回已注册的中间件名称
+func middlewareNames() []string {
+	middlewareMu.Lock()
+	defer middlewareMu.Unlock()
+	names := make([]string, 0, len(middlewareFactories))
+	for name := range middlewareFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// middlewareConfig 路由配置中启用的一个中间件
+type middlewareConfig struct {
+	Name   string                 `json:"name" yaml:"name"`
+	Config map[string]interface{} `json:"config,omitempty" yaml:"config"` // 传给中间件的参数
+}
+
+// namedMiddleware 带名称的中间件实例，名称用于日志
+type namedMiddleware struct {
+	name string
+	middleware
+}
+
+// middlewareChain 一条路由按顺序执行的中间件
+type middlewareChain []namedMiddleware
+
+// newMiddlewareChain 按配置创建中间件链
+func newMiddlewareChain(configs []middlewareConfig) (middlewareChain, error) {
+	var chain middlewareChain
+	for _, c := range configs {
+		middlewareMu.Lock()
+		factory, ok := middlewareFactories[c.Name]
+		middlewareMu.Unlock()
+		if !ok {
+			names := middlewareNames()
+			if len(names) == 0 {
+				return nil, fmt.Errorf("unknown middleware %q, no middleware is registered", c.Name)
+			}
+			return nil, fmt.Errorf("unknown middleware %q, registered: %s", c.Name, strings.Join(names, ", "))
+		}
+		m, err := factory(c.Config)
+		if err != nil {
+			return nil, fmt.Errorf("middleware %s: %w", c.Name, err)
+		}
+		chain = append(chain, namedMiddleware{c.Name, m})
+	}
+	return chain, nil
+}
+
+// parseMiddlewareFlag 解析-middleware参数中逗号分隔的中间件名称
+func parseMiddlewareFlag(list string) []middlewareConfig {
+	var configs []middlewareConfig
+	for _, name := range splitList(list) {
+		configs = append(configs, middlewareConfig{Name: name})
+	}
+	return configs
+}
+
+// applyRequest 按顺序执行请求处理，遇到错误时停止
+func (c middlewareChain) applyRequest(req *http.Request) error {
+	for _, m := range c {
+		if err := m.request(req); err != nil {
+			return fmt.Errorf("middleware %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// applyResponse 按顺序执行响应处理，遇到错误时停止
+func (c middlewareChain) applyResponse(resp *http.Response) error {
+	for _, m := range c {
+		if err := m.response(resp); err != nil {
+			return fmt.Errorf("middleware %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// middlewareTransport 在发往后端前执行路由的请求中间件，位于重试之外，重试时不重复执行
+type middlewareTransport struct {
+	base http.RoundTripper
+}
+
+func (t *middlewareTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if info := getRequestInfo(req); info.route != nil && len(info.route.middleware) > 0 {
+		if err := info.route.middleware.applyRequest(req); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// middlewareStatus 返回中间件错误对应的状态码，不是中间件拒绝的请求时返回0
+func middlewareStatus(err error) (int, string) {
+	var me *middlewareError
+	if errors.As(err, &me) {
+		return me.status, me.message
+	}
+	return 0, ""
+}
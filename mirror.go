@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 影子请求的超时时间、同时进行的最大数量和缓冲的请求体大小；影子请求过多时丢弃，避免影子后端变慢拖累代理
+const (
+	mirrorTimeout     = 30 * time.Second
+	mirrorMaxInflight = 256
+	mirrorBodyBytes   = 1 << 20 // 启用镜像的路由至少缓冲的请求体字节数，更大的请求体不镜像
+)
+
+// 影子请求的结果，用于指标
+const (
+	mirrorDropped = "dropped" // 同时进行的影子请求过多
+	mirrorSkipped = "skipped" // 请求体未缓冲，无法复制
+	mirrorFailed  = "error"   // 请求影子后端失败
+)
+
+// mirrorConfig 路由配置中的流量镜像设置，未写出的字段使用-mirror-*参数
+type mirrorConfig struct {
+	Backend string   `json:"backend,omitempty" yaml:"backend"` // 影子后端地址
+	Percent *float64 `json:"percent,omitempty" yaml:"percent"` // 镜像的请求百分比，0表示关闭
+}
+
+// merge 返回用override中已设置的字段覆盖后的配置
+func (c mirrorConfig) merge(override mirrorConfig) mirrorConfig {
+	if override.Backend != "" {
+		c.Backend = override.Backend
+	}
+	if override.Percent != nil {
+		c.Percent = override.Percent
+	}
+	return c
+}
+
+// defaultMirrorConfig 由-mirror-*参数得到的流量镜像设置
+var defaultMirrorConfig mirrorConfig
+
+// mirror 将按比例抽取的请求复制一份发往影子后端，影子后端的响应被丢弃，不影响客户端
+type mirror struct {
+	backend  *url.URL
+	percent  float64
+	client   *http.Client
+	inflight chan struct{}
+	metrics  *proxyMetrics
+}
+
+// newMirror 创建流量镜像，未配置影子后端或比例为0时返回nil
+func newMirror(c mirrorConfig, transport http.RoundTripper, metrics *proxyMetrics) (*mirror, error) {
+	percent := 100.0
+	if c.Percent != nil {
+		percent = *c.Percent
+	}
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("percent must be between 0 and 100")
+	}
+	if c.Backend == "" || percent == 0 {
+		return nil, nil
+	}
+	backends, _, err := parseBackends(c.Backend)
+	if err != nil {
+		return nil, err
+	}
+	if len(backends) != 1 {
+		return nil, fmt.Errorf("exactly one mirror backend is required")
+	}
+	return &mirror{
+		backend:  backends[0],
+		percent:  percent,
+		client:   &http.Client{Transport: transport, Timeout: mirrorTimeout, CheckRedirect: noRedirect},
+		inflight: make(chan struct{}, mirrorMaxInflight),
+		metrics:  metrics,
+	}, nil
+}
+
+// noRedirect 影子请求不跟随重定向，与转发给主后端的请求一致
+func noRedirect(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// send 按比例异步发送req的副本，path为映射后相对于后端基础路径的路径。
+// req是Director处理后即将发往主后端的请求，影子请求带有相同的请求头和请求体
+func (m *mirror) send(req *http.Request, path string, info *requestInfo) {
+	if rand.Float64()*100 >= m.percent {
+		return
+	}
+	routeName := info.route.name()
+	if req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0 && req.GetBody == nil {
+		// 超过缓冲大小的请求体边读边转发，无法再读一遍
+		m.observe(routeName, mirrorSkipped)
+		return
+	}
+	select {
+	case m.inflight <- struct{}{}:
+	default:
+		m.observe(routeName, mirrorDropped)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	shadow := req.Clone(ctx)
+	shadow.RequestURI = ""
+	shadow.URL.Scheme = m.backend.Scheme
+	shadow.URL.Host = m.backend.Host
+	shadow.URL.Path = m.backend.Path + strings.TrimPrefix(path, "/")
+	shadow.URL.RawPath = ""
+	shadow.Host = backendHostHeader(m.backend)
+	shadow.Header.Set("X-Shadow-Request", "true")
+	shadow.Body = nil
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			<-m.inflight
+			m.observe(routeName, mirrorSkipped)
+			return
+		}
+		shadow.Body = body
+	}
+
+	go func() {
+		defer func() { <-m.inflight }()
+		defer cancel()
+		resp, err := m.client.Do(shadow)
+		if err != nil {
+			info.log.Warnf("Mirror request to %s failed: %v", m.backend.Host, err)
+			m.observe(routeName, mirrorFailed)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		info.log.Debugf("Mirror request to %s returned %d", shadow.URL, resp.StatusCode)
+		m.observe(routeName, fmt.Sprintf("%dxx", resp.StatusCode/100))
+	}()
+}
+
+func (m *mirror) observe(route, result string) {
+	if m.metrics != nil {
+		m.metrics.observeMirror(route, result)
+	}
+}
@@ -0,0 +1,834 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIBodyBytes 按OpenAPI校验请求体时缓冲的最大字节数，更大的JSON请求体返回413
+const openAPIBodyBytes = 1 << 20
+
+// openAPIMaxErrors 一个请求最多返回的校验错误数
+const openAPIMaxErrors = 20
+
+// openAPIConfig 路由配置中的OpenAPI校验设置
+type openAPIConfig struct {
+	File     string `json:"file" yaml:"file"`                     // OpenAPI 3文档（JSON或YAML），重新加载路由时重新读取
+	BasePath string `json:"base_path,omitempty" yaml:"base_path"` // 文档中的路径相对的请求路径前缀，为空时为路由前缀
+}
+
+// openAPIValidator 按OpenAPI文档校验请求的路径、方法、参数和JSON请求体
+type openAPIValidator struct {
+	file     string
+	basePath string
+	paths    []*openAPIPath // 按字面段数从多到少排序，/users/me优先于/users/{id}
+}
+
+// openAPIPath 文档中的一个路径模板
+type openAPIPath struct {
+	template   string
+	segments   []string // 以{name}表示的段为路径参数
+	literals   int
+	operations map[string]*openAPIOperation // 大写的HTTP方法 -> 操作
+}
+
+// openAPIOperation 一个操作的参数和请求体定义
+type openAPIOperation struct {
+	params       []*openAPIParam
+	bodyRequired bool
+	content      map[string]*apiSchema // 请求体的媒体类型 -> schema，为nil时不接受请求体的定义
+}
+
+// openAPIParam 一个参数定义
+type openAPIParam struct {
+	name     string
+	in       string // path、query、header、cookie
+	required bool
+	schema   *apiSchema
+}
+
+// openAPIError 一项校验错误
+type openAPIError struct {
+	In      string `json:"in"`             // path、query、header、cookie或body
+	Name    string `json:"name,omitempty"` // 参数名或请求体中的JSON路径
+	Message string `json:"message"`
+}
+
+// apiSchema 编译后的JSON Schema，只支持请求校验常用的关键字
+type apiSchema struct {
+	types        []string // 允许的类型，为空时不限制
+	nullable     bool
+	enum         []interface{}
+	required     []string
+	properties   map[string]*apiSchema
+	additional   *apiSchema // additionalProperties为schema时的定义
+	noAdditional bool       // additionalProperties: false
+	items        *apiSchema
+	minItems     int
+	maxItems     int // -1表示不限制
+	minLength    int
+	maxLength    int // -1表示不限制
+	pattern      *regexp.Regexp
+	minimum      *float64
+	maximum      *float64
+	exclusiveMin bool
+	exclusiveMax bool
+	allOf        []*apiSchema
+	anyOf        []*apiSchema
+	oneOf        []*apiSchema
+	not          *apiSchema
+	readOnly     bool // 只出现在响应中的属性，请求中不要求
+}
+
+// openAPICompiler 编译文档中的schema，按$ref缓存以支持递归引用
+type openAPICompiler struct {
+	doc  map[string]interface{}
+	refs map[string]*apiSchema
+}
+
+// loadOpenAPIValidator 读取并编译OpenAPI文档
+func loadOpenAPIValidator(c openAPIConfig, routePrefix string) (*openAPIValidator, error) {
+	if c.File == "" {
+		return nil, fmt.Errorf("file is required")
+	}
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", c.File, err)
+	}
+	if version, _ := doc["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("%s: only OpenAPI 3.x documents are supported", c.File)
+	}
+	v := &openAPIValidator{file: c.File, basePath: c.BasePath}
+	if v.basePath == "" {
+		v.basePath = routePrefix
+	}
+	v.basePath = strings.TrimSuffix(v.basePath, "/")
+
+	comp := &openAPICompiler{doc: doc, refs: map[string]*apiSchema{}}
+	paths, _ := doc["paths"].(map[string]interface{})
+	for template, raw := range paths {
+		item, err := comp.deref(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: path %s: %w", c.File, template, err)
+		}
+		p := &openAPIPath{template: template, operations: map[string]*openAPIOperation{}}
+		for _, seg := range strings.Split(strings.Trim(template, "/"), "/") {
+			p.segments = append(p.segments, seg)
+			if !isPathParamSegment(seg) {
+				p.literals++
+			}
+		}
+		common, err := comp.params(item["parameters"])
+		if err != nil {
+			return nil, fmt.Errorf("%s: path %s: %w", c.File, template, err)
+		}
+		for method, raw := range item {
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+			default:
+				continue
+			}
+			op, err := comp.operation(raw, common)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s %s: %w", c.File, strings.ToUpper(method), template, err)
+			}
+			p.operations[strings.ToUpper(method)] = op
+		}
+		v.paths = append(v.paths, p)
+	}
+	sort.SliceStable(v.paths, func(i, j int) bool {
+		if v.paths[i].literals != v.paths[j].literals {
+			return v.paths[i].literals > v.paths[j].literals
+		}
+		return v.paths[i].template < v.paths[j].template
+	})
+	return v, nil
+}
+
+func isPathParamSegment(seg string) bool {
+	return strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")
+}
+
+// deref 解析本文档内的$ref（#/components/...），返回引用的对象
+func (c *openAPICompiler) deref(raw interface{}) (map[string]interface{}, error) {
+	node, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object")
+	}
+	for depth := 0; depth < 32; depth++ {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node, nil
+		}
+		target, err := c.lookup(ref)
+		if err != nil {
+			return nil, err
+		}
+		if node, ok = target.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("$ref %s is not an object", ref)
+		}
+	}
+	return nil, fmt.Errorf("too many nested $ref")
+}
+
+// lookup 按JSON Pointer查找文档中的节点，只支持本文档内的引用
+func (c *openAPICompiler) lookup(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %s, only local references are supported", ref)
+	}
+	var node interface{} = c.doc
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolved $ref %s", ref)
+		}
+		if node, ok = m[part]; !ok {
+			return nil, fmt.Errorf("unresolved $ref %s", ref)
+		}
+	}
+	return node, nil
+}
+
+// params 编译参数列表
+func (c *openAPICompiler) params(raw interface{}) ([]*openAPIParam, error) {
+	list, _ := raw.([]interface{})
+	var params []*openAPIParam
+	for _, item := range list {
+		node, err := c.deref(item)
+		if err != nil {
+			return nil, err
+		}
+		p := &openAPIParam{}
+		p.name, _ = node["name"].(string)
+		p.in, _ = node["in"].(string)
+		p.required, _ = node["required"].(bool)
+		if p.name == "" || p.in == "" {
+			return nil, fmt.Errorf("parameter requires name and in")
+		}
+		if p.in == "path" {
+			p.required = true
+		}
+		if p.in == "header" {
+			p.name = http.CanonicalHeaderKey(p.name)
+		}
+		if s, ok := node["schema"]; ok {
+			if p.schema, err = c.schema(s); err != nil {
+				return nil, fmt.Errorf("parameter %s: %w", p.name, err)
+			}
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+// operation 编译一个操作，操作的参数覆盖路径上同名同位置的参数
+func (c *openAPICompiler) operation(raw interface{}, common []*openAPIParam) (*openAPIOperation, error) {
+	node, err := c.deref(raw)
+	if err != nil {
+		return nil, err
+	}
+	own, err := c.params(node["parameters"])
+	if err != nil {
+		return nil, err
+	}
+	op := &openAPIOperation{params: own}
+	for _, p := range common {
+		overridden := false
+		for _, o := range own {
+			overridden = overridden || (o.name == p.name && o.in == p.in)
+		}
+		if !overridden {
+			op.params = append(op.params, p)
+		}
+	}
+	if rb, ok := node["requestBody"]; ok {
+		body, err := c.deref(rb)
+		if err != nil {
+			return nil, fmt.Errorf("requestBody: %w", err)
+		}
+		op.bodyRequired, _ = body["required"].(bool)
+		op.content = map[string]*apiSchema{}
+		content, _ := body["content"].(map[string]interface{})
+		for mediaType, raw := range content {
+			media, _ := raw.(map[string]interface{})
+			var s *apiSchema
+			if media != nil && media["schema"] != nil {
+				if s, err = c.schema(media["schema"]); err != nil {
+					return nil, fmt.Errorf("requestBody %s: %w", mediaType, err)
+				}
+			}
+			op.content[strings.ToLower(mediaType)] = s
+		}
+	}
+	return op, nil
+}
+
+// schema 编译一个schema
+func (c *openAPICompiler) schema(raw interface{}) (*apiSchema, error) {
+	node, ok := raw.(map[string]interface{})
+	if !ok {
+		if b, isBool := raw.(bool); isBool {
+			// JSON Schema中true接受任意值，false拒绝任意值
+			if b {
+				return &apiSchema{maxItems: -1, maxLength: -1}, nil
+			}
+			return &apiSchema{maxItems: -1, maxLength: -1, not: &apiSchema{maxItems: -1, maxLength: -1}}, nil
+		}
+		return nil, fmt.Errorf("schema must be an object")
+	}
+	if ref, ok := node["$ref"].(string); ok {
+		if s, ok := c.refs[ref]; ok {
+			return s, nil
+		}
+		target, err := c.lookup(ref)
+		if err != nil {
+			return nil, err
+		}
+		s := &apiSchema{}
+		c.refs[ref] = s
+		compiled, err := c.schema(target)
+		if err != nil {
+			return nil, err
+		}
+		*s = *compiled
+		return s, nil
+	}
+
+	s := &apiSchema{maxItems: -1, maxLength: -1}
+	switch t := node["type"].(type) {
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				s.types = append(s.types, name)
+			}
+		}
+	}
+	s.nullable, _ = node["nullable"].(bool)
+	s.readOnly, _ = node["readOnly"].(bool)
+	if enum, ok := node["enum"].([]interface{}); ok {
+		s.enum = enum
+	}
+	if v, ok := node["const"]; ok {
+		s.enum = []interface{}{v}
+	}
+	for _, name := range asList(node["required"]) {
+		if n, ok := name.(string); ok {
+			s.required = append(s.required, n)
+		}
+	}
+	var err error
+	if props, ok := node["properties"].(map[string]interface{}); ok {
+		s.properties = map[string]*apiSchema{}
+		for name, raw := range props {
+			if s.properties[name], err = c.schema(raw); err != nil {
+				return nil, fmt.Errorf("property %s: %w", name, err)
+			}
+		}
+	}
+	switch ap := node["additionalProperties"].(type) {
+	case bool:
+		s.noAdditional = !ap
+	case map[string]interface{}:
+		if s.additional, err = c.schema(ap); err != nil {
+			return nil, err
+		}
+	}
+	if items, ok := node["items"]; ok {
+		if s.items, err = c.schema(items); err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+	}
+	s.minItems = intKeyword(node, "minItems", 0)
+	s.maxItems = intKeyword(node, "maxItems", -1)
+	s.minLength = intKeyword(node, "minLength", 0)
+	s.maxLength = intKeyword(node, "maxLength", -1)
+	if pattern, ok := node["pattern"].(string); ok {
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	s.minimum, s.maximum = floatKeyword(node, "minimum"), floatKeyword(node, "maximum")
+	// OpenAPI 3.0的exclusiveMinimum为布尔值，3.1为数值
+	switch v := node["exclusiveMinimum"].(type) {
+	case bool:
+		s.exclusiveMin = v
+	default:
+		if f := floatKeyword(node, "exclusiveMinimum"); f != nil {
+			s.minimum, s.exclusiveMin = f, true
+		}
+	}
+	switch v := node["exclusiveMaximum"].(type) {
+	case bool:
+		s.exclusiveMax = v
+	default:
+		if f := floatKeyword(node, "exclusiveMaximum"); f != nil {
+			s.maximum, s.exclusiveMax = f, true
+		}
+	}
+	for key, list := range map[string]*[]*apiSchema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		for _, raw := range asList(node[key]) {
+			sub, err := c.schema(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			*list = append(*list, sub)
+		}
+	}
+	if not, ok := node["not"]; ok {
+		if s.not, err = c.schema(not); err != nil {
+			return nil, fmt.Errorf("not: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func asList(v interface{}) []interface{} {
+	list, _ := v.([]interface{})
+	return list
+}
+
+func intKeyword(node map[string]interface{}, key string, def int) int {
+	if f := floatKeyword(node, key); f != nil {
+		return int(*f)
+	}
+	return def
+}
+
+func floatKeyword(node map[string]interface{}, key string) *float64 {
+	var f float64
+	switch v := node[key].(type) {
+	case int:
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	case uint64:
+		f = float64(v)
+	case float64:
+		f = v
+	default:
+		return nil
+	}
+	return &f
+}
+
+// match 返回与路径匹配的路径模板和路径参数，没有匹配时返回nil
+func (v *openAPIValidator) match(path string) (*openAPIPath, map[string]string) {
+	rel := strings.TrimPrefix(path, v.basePath)
+	if !strings.HasPrefix(rel, "/") {
+		rel = "/" + rel
+	}
+	segments := strings.Split(strings.Trim(rel, "/"), "/")
+	for _, p := range v.paths {
+		if len(p.segments) != len(segments) {
+			continue
+		}
+		params := map[string]string{}
+		ok := true
+		for i, seg := range p.segments {
+			if isPathParamSegment(seg) {
+				value, err := url.PathUnescape(segments[i])
+				if err != nil || value == "" {
+					ok = false
+					break
+				}
+				params[seg[1:len(seg)-1]] = value
+			} else if seg != segments[i] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return p, params
+		}
+	}
+	return nil, nil
+}
+
+// needsBody 判断请求是否需要读取请求体做JSON校验
+func (v *openAPIValidator) needsBody(r *http.Request) bool {
+	p, _ := v.match(r.URL.Path)
+	if p == nil {
+		return false
+	}
+	op := p.operations[r.Method]
+	return op != nil && op.content != nil && isJSONMediaType(requestMediaType(r))
+}
+
+// validate 校验请求，返回建议的状态码和错误列表，通过时返回0；
+// 未匹配任何路径返回404，路径不支持该方法返回405，请求体类型不被接受返回415，其余错误返回400
+func (v *openAPIValidator) validate(r *http.Request) (int, []openAPIError) {
+	p, pathParams := v.match(r.URL.Path)
+	if p == nil {
+		return http.StatusNotFound, []openAPIError{{In: "path", Message: "path is not defined in the API specification"}}
+	}
+	op := p.operations[r.Method]
+	if op == nil {
+		if r.Method == http.MethodHead {
+			op = p.operations[http.MethodGet]
+		}
+		if op == nil {
+			return http.StatusMethodNotAllowed, []openAPIError{{In: "path", Message: fmt.Sprintf("method %s is not allowed for %s", r.Method, p.template)}}
+		}
+	}
+
+	var errs []openAPIError
+	query := r.URL.Query()
+	for _, param := range op.params {
+		var values []string
+		switch param.in {
+		case "path":
+			if value, ok := pathParams[param.name]; ok {
+				values = []string{value}
+			}
+		case "query":
+			values = query[param.name]
+		case "header":
+			values = r.Header.Values(param.name)
+		case "cookie":
+			if cookie, err := r.Cookie(param.name); err == nil {
+				values = []string{cookie.Value}
+			}
+		}
+		if len(values) == 0 {
+			if param.required {
+				errs = append(errs, openAPIError{In: param.in, Name: param.name, Message: "required parameter is missing"})
+			}
+			continue
+		}
+		if param.schema != nil {
+			for _, msg := range param.schema.validateParam(values) {
+				errs = append(errs, openAPIError{In: param.in, Name: param.name, Message: msg})
+			}
+		}
+	}
+
+	hasBody := r.ContentLength > 0 || (r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody)
+	switch {
+	case op.content == nil:
+	case !hasBody:
+		if op.bodyRequired {
+			errs = append(errs, openAPIError{In: "body", Message: "request body is required"})
+		}
+	default:
+		mediaType := requestMediaType(r)
+		s, ok := lookupMediaType(op.content, mediaType)
+		if !ok {
+			return http.StatusUnsupportedMediaType, []openAPIError{{In: "header", Name: "Content-Type", Message: fmt.Sprintf("content type %q is not accepted", mediaType)}}
+		}
+		if s != nil && isJSONMediaType(mediaType) {
+			bodyErrs, tooLarge := validateJSONBody(r, s)
+			if tooLarge {
+				return http.StatusRequestEntityTooLarge, []openAPIError{{In: "body", Message: fmt.Sprintf("request body exceeds %d bytes and cannot be validated", openAPIBodyBytes)}}
+			}
+			errs = append(errs, bodyErrs...)
+		}
+	}
+	if len(errs) == 0 {
+		return 0, nil
+	}
+	if len(errs) > openAPIMaxErrors {
+		errs = errs[:openAPIMaxErrors]
+	}
+	return http.StatusBadRequest, errs
+}
+
+// requestMediaType 返回请求体的媒体类型（小写、不含参数）
+func requestMediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(mediaType)
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// lookupMediaType 按精确类型、type/*、*/*的顺序查找请求体的定义
+func lookupMediaType(content map[string]*apiSchema, mediaType string) (*apiSchema, bool) {
+	if s, ok := content[mediaType]; ok {
+		return s, true
+	}
+	if major, _, ok := strings.Cut(mediaType, "/"); ok {
+		if s, ok := content[major+"/*"]; ok {
+			return s, true
+		}
+	}
+	s, ok := content["*/*"]
+	return s, ok
+}
+
+// validateJSONBody 读取已缓冲的请求体并按schema校验，请求体未缓冲（超过openAPIBodyBytes）时返回tooLarge
+func validateJSONBody(r *http.Request, s *apiSchema) (errs []openAPIError, tooLarge bool) {
+	if r.GetBody == nil {
+		return nil, true
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return []openAPIError{{In: "body", Message: err.Error()}}, false
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return []openAPIError{{In: "body", Message: err.Error()}}, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return []openAPIError{{In: "body", Message: "invalid JSON: " + err.Error()}}, false
+	}
+	if dec.More() {
+		return []openAPIError{{In: "body", Message: "invalid JSON: unexpected data after the top-level value"}}, false
+	}
+	for _, e := range s.validate(value, "$") {
+		errs = append(errs, openAPIError{In: "body", Name: e.path, Message: e.message})
+	}
+	return errs, false
+}
+
+// schemaError schema校验错误，path为JSON路径
+type schemaError struct {
+	path    string
+	message string
+}
+
+// validateParam 将参数的字符串值按schema的类型转换后校验；数组参数可以重复出现或以逗号分隔
+func (s *apiSchema) validateParam(values []string) []string {
+	var value interface{}
+	if s.allows("array") {
+		if len(values) == 1 && strings.Contains(values[0], ",") {
+			values = strings.Split(values[0], ",")
+		}
+		items := make([]interface{}, len(values))
+		for i, v := range values {
+			items[i] = coerceParam(s.items, v)
+		}
+		value = items
+	} else {
+		value = coerceParam(s, values[0])
+	}
+	var msgs []string
+	for _, e := range s.validate(value, "") {
+		msgs = append(msgs, strings.TrimPrefix(e.path+": ", ": ")+e.message)
+	}
+	return msgs
+}
+
+// coerceParam 按schema声明的类型转换参数值，无法转换时保留字符串，由校验报告类型错误
+func coerceParam(s *apiSchema, v string) interface{} {
+	if s == nil {
+		return v
+	}
+	switch {
+	case s.allows("integer"), s.allows("number"):
+		if _, err := strconv.ParseFloat(v, 64); err == nil {
+			return json.Number(v)
+		}
+	case s.allows("boolean"):
+		if v == "true" || v == "false" {
+			return v == "true"
+		}
+	}
+	return v
+}
+
+// allows 判断schema是否声明了该类型
+func (s *apiSchema) allows(t string) bool {
+	for _, name := range s.types {
+		if name == t {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType 返回JSON值的类型名，json.Number为整数时返回integer
+func jsonType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := x.Int64(); err == nil {
+			return "integer"
+		}
+		if f, err := x.Float64(); err == nil && f == math.Trunc(f) && !strings.ContainsAny(string(x), "eE.") {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// validate 按schema校验JSON值
+func (s *apiSchema) validate(v interface{}, path string) []schemaError {
+	fail := func(format string, args ...interface{}) []schemaError {
+		return []schemaError{{path: path, message: fmt.Sprintf(format, args...)}}
+	}
+	t := jsonType(v)
+	if t == "null" && (s.nullable || s.allows("null")) {
+		return nil
+	}
+	if len(s.types) > 0 {
+		ok := false
+		for _, want := range s.types {
+			ok = ok || want == t || (want == "number" && t == "integer")
+		}
+		if !ok {
+			return fail("expected %s, got %s", strings.Join(s.types, " or "), t)
+		}
+	}
+	if len(s.enum) > 0 {
+		found := false
+		for _, e := range s.enum {
+			found = found || enumEqual(e, v)
+		}
+		if !found {
+			return fail("value is not one of the allowed values")
+		}
+	}
+
+	var errs []schemaError
+	switch x := v.(type) {
+	case string:
+		if n := len([]rune(x)); n < s.minLength || (s.maxLength >= 0 && n > s.maxLength) {
+			errs = append(errs, fail("string length %d is outside the allowed range", n)...)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(x) {
+			errs = append(errs, fail("string does not match pattern %s", s.pattern)...)
+		}
+	case json.Number:
+		f, _ := x.Float64()
+		if s.minimum != nil && (f < *s.minimum || (s.exclusiveMin && f == *s.minimum)) {
+			errs = append(errs, fail("value %s is below the minimum %g", x, *s.minimum)...)
+		}
+		if s.maximum != nil && (f > *s.maximum || (s.exclusiveMax && f == *s.maximum)) {
+			errs = append(errs, fail("value %s is above the maximum %g", x, *s.maximum)...)
+		}
+	case []interface{}:
+		if len(x) < s.minItems || (s.maxItems >= 0 && len(x) > s.maxItems) {
+			errs = append(errs, fail("array length %d is outside the allowed range", len(x))...)
+		}
+		if s.items != nil {
+			for i, item := range x {
+				errs = append(errs, s.items.validate(item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := x[name]; !ok {
+				if prop := s.properties[name]; prop == nil || !prop.readOnly {
+					errs = append(errs, schemaError{path: path + "." + name, message: "required property is missing"})
+				}
+			}
+		}
+		names := make([]string, 0, len(x))
+		for name := range x {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.properties[name]; ok {
+				errs = append(errs, prop.validate(x[name], path+"."+name)...)
+			} else if s.additional != nil {
+				errs = append(errs, s.additional.validate(x[name], path+"."+name)...)
+			} else if s.noAdditional {
+				errs = append(errs, schemaError{path: path + "." + name, message: "property is not allowed"})
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		errs = append(errs, sub.validate(v, path)...)
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			matched = matched || len(sub.validate(v, path)) == 0
+		}
+		if !matched {
+			errs = append(errs, fail("value does not match any of the allowed schemas")...)
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if len(sub.validate(v, path)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			errs = append(errs, fail("value must match exactly one schema, matched %d", matched)...)
+		}
+	}
+	if s.not != nil && len(s.not.validate(v, path)) == 0 {
+		errs = append(errs, fail("value matches a schema it must not match")...)
+	}
+	return errs
+}
+
+// enumEqual 比较枚举值和JSON值，数字按数值比较
+func enumEqual(e, v interface{}) bool {
+	if n, ok := v.(json.Number); ok {
+		f, _ := n.Float64()
+		if ef := floatKeyword(map[string]interface{}{"v": e}, "v"); ef != nil {
+			return *ef == f
+		}
+		return false
+	}
+	if b, ok := v.(bool); ok {
+		eb, isBool := e.(bool)
+		return isBool && eb == b
+	}
+	if s, ok := v.(string); ok {
+		es, isString := e.(string)
+		return isString && es == s
+	}
+	return v == nil && e == nil
+}
+
+// reject 返回结构化的校验错误，405时在Allow中列出路径支持的方法
+func (v *openAPIValidator) reject(w http.ResponseWriter, r *http.Request, status int, errs []openAPIError) {
+	if p, _ := v.match(r.URL.Path); p != nil && status == http.StatusMethodNotAllowed {
+		methods := make([]string, 0, len(p.operations))
+		for method := range p.operations {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"error":      "request does not match the API specification",
+		"details":    errs,
+		"request_id": getRequestInfo(r).id,
+	})
+}
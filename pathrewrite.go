@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// pathRewriteConfig 路由配置中的路径改写规则，未写出时去掉路由前缀后拼接到后端基础路径
+type pathRewriteConfig struct {
+	StripPrefix *bool             `json:"strip_prefix,omitempty" yaml:"strip_prefix"` // 是否去掉路由前缀，默认true
+	AddPrefix   string            `json:"add_prefix,omitempty" yaml:"add_prefix"`     // 去掉前缀后在路径前添加的前缀，如/internal
+	Rules       []pathRewriteRule `json:"rules,omitempty" yaml:"rules"`               // 正则改写规则，按顺序匹配原始请求路径
+}
+
+// pathRewriteRule 一条正则改写规则，replace中可用$1、${name}引用捕获组
+type pathRewriteRule struct {
+	Match   string `json:"match" yaml:"match"`
+	Replace string `json:"replace" yaml:"replace"`
+
+	re *regexp.Regexp
+}
+
+// pathRewrite 一条路由生效的路径改写规则
+type pathRewrite struct {
+	strip     bool
+	addPrefix string
+	rules     []pathRewriteRule
+}
+
+// newPathRewrite 编译路径改写规则
+func newPathRewrite(c pathRewriteConfig) (*pathRewrite, error) {
+	p := &pathRewrite{strip: true, addPrefix: strings.TrimSuffix(c.AddPrefix, "/")}
+	if c.StripPrefix != nil {
+		p.strip = *c.StripPrefix
+	}
+	if p.addPrefix != "" && !strings.HasPrefix(p.addPrefix, "/") {
+		return nil, fmt.Errorf("add_prefix must start with /")
+	}
+	for i, rule := range c.Rules {
+		re, err := regexp.Compile(rule.Match)
+		if err != nil || rule.Match == "" {
+			return nil, fmt.Errorf("rule %d: invalid match %q", i+1, rule.Match)
+		}
+		rule.re = re
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+// apply 返回请求路径在后端基础路径下的路径。第一条匹配原始路径的正则规则生效，
+// 结果不再去掉或添加前缀；没有规则匹配时按strip_prefix和add_prefix处理
+func (p *pathRewrite) apply(path, prefix string) string {
+	for _, rule := range p.rules {
+		if rule.re.MatchString(path) {
+			return ensureLeadingSlash(rule.re.ReplaceAllString(path, rule.Replace))
+		}
+	}
+	if p.strip {
+		path = strings.TrimPrefix(path, prefix)
+	}
+	if p.addPrefix != "" {
+		path = p.addPrefix + "/" + strings.TrimPrefix(path, "/")
+	}
+	return ensureLeadingSlash(path)
+}
+
+func ensureLeadingSlash(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// poolConfig 路由配置中连接该路由后端的连接池设置，未写出的字段使用全局参数
+type poolConfig struct {
+	MaxIdleConns        *int   `json:"max_idle_conns,omitempty" yaml:"max_idle_conns"`                   // 所有后端合计保留的最大空闲连接数，0表示不限制
+	MaxIdleConnsPerHost *int   `json:"max_idle_conns_per_host,omitempty" yaml:"max_idle_conns_per_host"` // 每个后端保留的最大空闲连接数
+	MaxConnsPerHost     *int   `json:"max_conns_per_host,omitempty" yaml:"max_conns_per_host"`           // 每个后端的最大连接数，超过时请求等待空闲连接，0表示不限制
+	KeepAlive           string `json:"keep_alive,omitempty" yaml:"keep_alive"`                           // TCP keep-alive探测间隔，0表示关闭
+	DisableKeepAlives   *bool  `json:"disable_keep_alives,omitempty" yaml:"disable_keep_alives"`         // 不复用连接，每个请求新建连接
+	HTTP2               *bool  `json:"http2,omitempty" yaml:"http2"`                                     // 是否与HTTPS后端协商HTTP/2
+}
+
+// poolSettings 生效的连接池设置
+type poolSettings struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+	keepAlive           time.Duration
+	disableKeepAlives   bool
+	http2               bool
+}
+
+// defaultPool 由-max-idle-conns等参数得到的连接池设置，用于未单独配置pool的路由
+var defaultPool poolSettings
+
+// validate 检查连接池设置
+func (p poolSettings) validate() error {
+	if p.maxIdleConns < 0 || p.maxIdleConnsPerHost < 0 || p.maxConnsPerHost < 0 {
+		return fmt.Errorf("connection limits must not be negative")
+	}
+	if p.keepAlive < 0 {
+		return fmt.Errorf("keep_alive must not be negative")
+	}
+	return nil
+}
+
+// merge 返回用路由配置中已设置的字段覆盖后的连接池设置
+func (p poolSettings) merge(c poolConfig) (poolSettings, error) {
+	if c.MaxIdleConns != nil {
+		p.maxIdleConns = *c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost != nil {
+		p.maxIdleConnsPerHost = *c.MaxIdleConnsPerHost
+	}
+	if c.MaxConnsPerHost != nil {
+		p.maxConnsPerHost = *c.MaxConnsPerHost
+	}
+	if c.KeepAlive != "" {
+		d, err := time.ParseDuration(c.KeepAlive)
+		if err != nil {
+			return p, fmt.Errorf("invalid keep_alive %q", c.KeepAlive)
+		}
+		p.keepAlive = d
+	}
+	if c.DisableKeepAlives != nil {
+		p.disableKeepAlives = *c.DisableKeepAlives
+	}
+	if c.HTTP2 != nil {
+		p.http2 = *c.HTTP2
+	}
+	return p, p.validate()
+}
+
+// apply 将连接池设置写入Transport，拨号函数由调用方按keepAlive创建
+func (p poolSettings) apply(t *http.Transport) {
+	t.MaxIdleConns = p.maxIdleConns
+	t.MaxIdleConnsPerHost = p.maxIdleConnsPerHost
+	t.MaxConnsPerHost = p.maxConnsPerHost
+	t.DisableKeepAlives = p.disableKeepAlives
+	// 使用自定义的拨号函数和TLS配置时，只有ForceAttemptHTTP2才会协商HTTP/2
+	t.ForceAttemptHTTP2 = p.http2
+}
+
+// dialKeepAlive 返回net.Dialer使用的KeepAlive值，0表示关闭TCP keep-alive
+func (p poolSettings) dialKeepAlive() time.Duration {
+	if p.keepAlive == 0 {
+		return -1
+	}
+	return p.keepAlive
+}
+
+// transportCache 按TLS、超时和连接池设置共享后端Transport。设置相同的路由使用同一个连接池，
+// 重新加载路由时设置未变的路由继续使用原连接池，已建立的连接不会断开重建
+type transportCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedTransport
+}
+
+type cachedTransport struct {
+	transport idleCloser
+	rt        http.RoundTripper // 包装后供路由使用的RoundTripper
+}
+
+// idleCloser 可关闭空闲连接的Transport，包括http.Transport和gRPC使用的HTTP/2 Transport
+type idleCloser interface {
+	CloseIdleConnections()
+}
+
+func newTransportCache() *transportCache {
+	return &transportCache{entries: map[string]*cachedTransport{}}
+}
+
+// get 返回设置对应的共享Transport，不存在时用build创建
+func (c *transportCache) get(key string, build func() (idleCloser, http.RoundTripper, error)) (http.RoundTripper, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		return e.rt, nil
+	}
+	t, rt, err := build()
+	if err != nil {
+		return nil, err
+	}
+	c.entries[key] = &cachedTransport{transport: t, rt: rt}
+	return rt, nil
+}
+
+// retain 只保留仍被路由使用的Transport，并关闭其余Transport的空闲连接；
+// 正在进行的请求继续使用已取得的连接，完成后连接随Transport一起释放
+func (c *transportCache) retain(used map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if !used[key] {
+			e.transport.CloseIdleConnections()
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 就绪检查在没有主动健康检查时直接连接后端，结果缓存一段时间，避免探针频繁连接后端
+const (
+	readyDialTimeout = 2 * time.Second
+	readyCacheTTL    = 5 * time.Second
+)
+
+// probes 代理自身应答的存活和就绪探针，不转发给后端，也不写访问日志
+type probes struct {
+	routes    func() *routeTable
+	healthy   func(*url.URL) bool // 熔断器和主动健康检查的结果，均未启用时为nil
+	active    bool                // 是否启用了主动健康检查，启用时不再单独连接后端
+	allRoutes bool                // 是否要求每条路由都有可达的后端
+
+	draining atomic.Bool
+
+	mu    sync.Mutex
+	dials map[string]dialResult // 后端地址 -> 最近一次连接的结果
+}
+
+type dialResult struct {
+	err string
+	at  time.Time
+}
+
+// routeReadiness 就绪探针中单条路由的状态
+type routeReadiness struct {
+	Route     string `json:"route"`
+	Reachable int    `json:"reachable"`
+	Backends  int    `json:"backends"`
+}
+
+func newProbes(routes func() *routeTable, healthy func(*url.URL) bool, active, allRoutes bool) *probes {
+	return &probes{routes: routes, healthy: healthy, active: active, allRoutes: allRoutes, dials: map[string]dialResult{}}
+}
+
+// healthz 存活探针：能应答即说明进程和监听器正常，优雅关闭期间同样返回200，避免编排系统重启正在排空的进程
+func (p *probes) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// readyz 就绪探针：优雅关闭期间或路由没有可达的后端时返回503，使负载均衡器不再转发流量
+func (p *probes) readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if p.draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "draining"})
+		return
+	}
+	routes, ready := p.check()
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "routes": routes})
+}
+
+// check 统计各路由可达的后端数（不含金丝雀后端），返回各路由的状态和是否就绪
+func (p *probes) check() ([]routeReadiness, bool) {
+	table := p.routes()
+	var backends []*url.URL
+	for _, rt := range table.routes {
+		backends = append(backends, rt.backends...)
+	}
+	reachable := p.reachable(backends)
+
+	var routes []routeReadiness
+	readyRoutes := 0
+	for _, rt := range table.routes {
+		rr := routeReadiness{Route: rt.name(), Backends: len(rt.backends)}
+		for _, b := range rt.backends {
+			if reachable[b.String()] {
+				rr.Reachable++
+			}
+		}
+		if rr.Reachable > 0 {
+			readyRoutes++
+		}
+		routes = append(routes, rr)
+	}
+	if p.allRoutes {
+		return routes, readyRoutes == len(routes)
+	}
+	return routes, readyRoutes > 0
+}
+
+// reachable 返回各后端是否可达：先看熔断器和主动健康检查，未启用主动健康检查时并发建立TCP连接确认
+func (p *probes) reachable(backends []*url.URL) map[string]bool {
+	result := map[string]bool{}
+	var pending []*url.URL
+	now := time.Now()
+	p.mu.Lock()
+	for _, b := range backends {
+		key := b.String()
+		if _, seen := result[key]; seen {
+			continue
+		}
+		result[key] = false
+		if p.healthy != nil && !p.healthy(b) {
+			continue
+		}
+		if p.active {
+			result[key] = true
+			continue
+		}
+		if d, ok := p.dials[key]; ok && now.Sub(d.at) < readyCacheTTL {
+			result[key] = d.err == ""
+			continue
+		}
+		pending = append(pending, b)
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, b := range pending {
+		wg.Add(1)
+		go func(b *url.URL) {
+			defer wg.Done()
+			d := dialResult{at: time.Now()}
+			if conn, err := dialBackend(b, readyDialTimeout); err != nil {
+				d.err = err.Error()
+			} else {
+				conn.Close()
+			}
+			p.mu.Lock()
+			result[b.String()] = d.err == ""
+			if prev, ok := p.dials[b.String()]; !ok && d.err != "" || ok && (prev.err == "") != (d.err == "") {
+				if d.err != "" {
+					logger.Warnf("Readiness check: backend %s is unreachable: %s", b, d.err)
+				} else {
+					logger.Infof("Readiness check: backend %s is reachable again", b)
+				}
+			}
+			p.dials[b.String()] = d
+			p.mu.Unlock()
+		}(b)
+	}
+	wg.Wait()
+	return result
+}
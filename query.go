@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// queryOps 路由配置中的查询参数改写规则，按移除、重命名、设置、追加的顺序执行
+type queryOps struct {
+	Set    map[string]string `json:"set,omitempty" yaml:"set"`       // 覆盖已有的同名参数，如注入后端要求的apikey
+	Add    map[string]string `json:"add,omitempty" yaml:"add"`       // 保留已有的同名参数并追加一个值
+	Remove []string          `json:"remove,omitempty" yaml:"remove"` // 移除的参数，以*结尾时按前缀匹配，如utm_*
+	Rename map[string]string `json:"rename,omitempty" yaml:"rename"` // 旧名称 -> 新名称，保留参数的全部取值
+}
+
+// validate 检查参数名称
+func (o queryOps) validate() error {
+	for _, name := range o.Remove {
+		if strings.TrimSuffix(name, "*") == "" && name != "*" {
+			return fmt.Errorf("invalid remove parameter %q", name)
+		}
+	}
+	for from, to := range o.Rename {
+		if from == "" || to == "" {
+			return fmt.Errorf("invalid rename %q -> %q", from, to)
+		}
+	}
+	for _, m := range []map[string]string{o.Set, o.Add} {
+		for name := range m {
+			if name == "" {
+				return fmt.Errorf("parameter name must not be empty")
+			}
+		}
+	}
+	return nil
+}
+
+// apply 改写URL的查询参数，没有参数被改动时保留原始编码
+func (o *queryOps) apply(u *url.URL) {
+	q := u.Query()
+	changed := false
+	for _, name := range o.Remove {
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			for key := range q {
+				if strings.HasPrefix(key, prefix) {
+					q.Del(key)
+					changed = true
+				}
+			}
+		} else if q.Has(name) {
+			q.Del(name)
+			changed = true
+		}
+	}
+	for from, to := range o.Rename {
+		if values, ok := q[from]; ok {
+			q.Del(from)
+			q[to] = append(q[to], values...)
+			changed = true
+		}
+	}
+	for name, value := range o.Set {
+		q.Set(name, value)
+		changed = true
+	}
+	for name, value := range o.Add {
+		q.Add(name, value)
+		changed = true
+	}
+	if changed {
+		u.RawQuery = q.Encode()
+	}
+}
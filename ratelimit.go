@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// 限流的计数维度
+const (
+	rateLimitPerClient = "client" // 每个客户端IP单独计数
+	rateLimitPerRoute  = "route"  // 路由内所有客户端共享一个令牌桶
+	rateLimitPerTenant = "tenant" // 租户的全部路由和客户端共享一个令牌桶，只用于租户的路由
+)
+
+// rateLimitConfig 路由配置中的限流设置
+type rateLimitConfig struct {
+	Rate  float64 `json:"rate" yaml:"rate"`             // 每秒允许的请求数，0表示该路由不限流
+	Burst int     `json:"burst,omitempty" yaml:"burst"` // 令牌桶容量，为0时取rate向上取整
+	Per   string  `json:"per,omitempty" yaml:"per"`     // 计数维度: client(默认)、route 或 tenant
+}
+
+// rateLimit 一条路由生效的限流策略
+type rateLimit struct {
+	rate      float64
+	burst     int
+	perRoute  bool
+	perTenant bool
+}
+
+// defaultRateLimit 由-rate-limit等参数得到的限流策略，用于未单独配置rate_limit的路由
+var defaultRateLimit *rateLimit
+
+// newRateLimit 校验并创建限流策略，rate为0时返回nil表示不限流
+func newRateLimit(c rateLimitConfig) (*rateLimit, error) {
+	if c.Rate < 0 || c.Burst < 0 {
+		return nil, fmt.Errorf("rate and burst must not be negative")
+	}
+	if c.Rate == 0 {
+		return nil, nil
+	}
+	l := &rateLimit{rate: c.Rate, burst: c.Burst}
+	switch c.Per {
+	case "", rateLimitPerClient:
+	case rateLimitPerRoute:
+		l.perRoute = true
+	case rateLimitPerTenant:
+		l.perTenant = true
+	default:
+		return nil, fmt.Errorf("per must be %s, %s or %s", rateLimitPerClient, rateLimitPerRoute, rateLimitPerTenant)
+	}
+	if l.burst == 0 {
+		l.burst = int(math.Max(1, math.Ceil(l.rate)))
+	}
+	return l, nil
+}
+
+// rateLimitStore 令牌桶的存储。默认保存在进程内存中，
+// 多实例部署时可替换为Redis等共享存储，使各实例共享同一份限额
+type rateLimitStore interface {
+	// take 从key对应的令牌桶中取一个令牌，失败时返回需要等待的时间
+	take(key string, rate float64, burst int, now time.Time) (bool, time.Duration)
+}
+
+// tokenBucket 一个令牌桶的状态
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  int
+}
+
+// memoryRateStore 进程内的令牌桶存储，定期清理已经回满的令牌桶
+type memoryRateStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// rateSweepInterval 清理空闲令牌桶的间隔
+const rateSweepInterval = time.Minute
+
+func newMemoryRateStore() *memoryRateStore {
+	return &memoryRateStore{buckets: map[string]*tokenBucket{}}
+}
+
+func (s *memoryRateStore) take(key string, rate float64, burst int, now time.Time) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= rateSweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now, rate: rate, burst: burst}
+		s.buckets[key] = b
+	} else {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last, b.rate, b.burst = now, rate, burst
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// sweep 删除空闲超过一个清理周期且已经回满的令牌桶，回满的桶与新建的桶等价
+func (s *memoryRateStore) sweep(now time.Time) {
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		idle := now.Sub(b.last)
+		if idle >= rateSweepInterval && b.tokens+idle.Seconds()*b.rate >= float64(b.burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// rateLimiter 按路由和客户端IP限流
+type rateLimiter struct {
+	store rateLimitStore
+}
+
+// allow 检查请求是否在路由的限额内，超出时返回建议的重试等待时间
+func (l *rateLimiter) allow(rt *route, client string) (bool, time.Duration) {
+	key := rt.name()
+	switch {
+	case rt.rateLimit.perTenant:
+		key = "tenant|" + rt.tenant
+	case !rt.rateLimit.perRoute:
+		key += "|" + client
+	}
+	return l.store.take(key, rt.rateLimit.rate, rt.rateLimit.burst, time.Now())
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 回放目录中没有对应录制时的处理方式
+const (
+	replayMissError   = "error"   // 返回502，不请求后端
+	replayMissForward = "forward" // 转发到后端，同时指定-record-dir时录制该响应
+)
+
+// recordBodyBufferBytes 录制和回放时为计算请求体摘要至少缓冲的请求体字节数，更大的请求体不参与区分请求
+const recordBodyBufferBytes = 1 << 20
+
+// recordingNameMax 录制文件名中由方法和路径生成部分的最大长度
+const recordingNameMax = 96
+
+// recording 录制文件的内容：一次请求的方法、URL和请求体摘要，以及后端的响应。
+// 文本响应体保存在body中便于手工编辑，其余保存为body_base64
+type recording struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	BodySHA256 string      `json:"body_sha256,omitempty"`
+	Status     int         `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 string      `json:"body_base64,omitempty"`
+	RecordedAt time.Time   `json:"recorded_at"`
+
+	file string // 录制文件路径
+}
+
+// recordingStore 在目录中按请求保存和读取后端响应，用于后端不可用时离线开发
+type recordingStore struct {
+	dir     string
+	maxBody int64 // 录制的最大响应体字节数，超过时不录制
+}
+
+func newRecordingStore(dir string, maxBody int64) (*recordingStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &recordingStore{dir: dir, maxBody: maxBody}, nil
+}
+
+// recordingBodyHash 返回请求体的SHA-256，只对已缓冲在内存中的请求体计算，没有请求体或未缓冲时返回空字符串
+func recordingBodyHash(r *http.Request) string {
+	if r.GetBody == nil || r.ContentLength == 0 {
+		return ""
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newRecording 返回待录制的请求，URL为客户端请求的原始路径和查询参数
+func (s *recordingStore) newRecording(r *http.Request, bodyHash string) *recording {
+	return &recording{Method: r.Method, URL: r.URL.RequestURI(), BodySHA256: bodyHash, file: s.file(r, bodyHash)}
+}
+
+// file 返回请求对应的录制文件路径：由方法和路径生成可读的文件名，再加上方法、路径、查询参数和请求体摘要的哈希
+func (s *recordingStore) file(r *http.Request, bodyHash string) string {
+	sum := sha256.Sum256([]byte(r.Method + " " + r.URL.RequestURI() + "\n" + bodyHash))
+	var b strings.Builder
+	for _, c := range r.Method + "_" + strings.Trim(r.URL.Path, "/") {
+		if b.Len() >= recordingNameMax {
+			break
+		}
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return filepath.Join(s.dir, b.String()+"-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// load 读取录制文件，文件不存在时返回nil
+func (s *recordingStore) load(file string) (*recording, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("invalid recording %s: %w", file, err)
+	}
+	return &rec, nil
+}
+
+// serve 将录制的响应写给客户端
+func (s *recordingStore) serve(w http.ResponseWriter, r *http.Request, rec *recording) error {
+	body := []byte(rec.Body)
+	if rec.BodyBase64 != "" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(rec.BodyBase64); err != nil {
+			return fmt.Errorf("invalid body_base64: %w", err)
+		}
+	}
+	for name, values := range rec.Header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set("X-Replay", "HIT")
+	if r.Method != http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	status := rec.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+	return nil
+}
+
+// capture 在响应体完整转发后写入录制文件；响应体超过上限或读取出错时不录制，响应照常返回给客户端。
+// 304响应依赖客户端的条件请求头，回放给其他请求没有意义，不录制
+func (s *recordingStore) capture(resp *http.Response, rec *recording) {
+	if resp.StatusCode == http.StatusNotModified {
+		return
+	}
+	rec.Status, rec.Header = resp.StatusCode, resp.Header.Clone()
+	for _, name := range []string{"Content-Length", "Date", "X-Cache", "X-Replay"} {
+		rec.Header.Del(name)
+	}
+	save := func(body []byte) {
+		if resp.Header.Get("Content-Encoding") == "" && isTextContent(resp.Header.Get("Content-Type")) && utf8.Valid(body) {
+			rec.Body = string(body)
+		} else if len(body) > 0 {
+			rec.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+		}
+		rec.RecordedAt = time.Now()
+		log := getRequestInfo(resp.Request).log
+		if err := writeRecording(rec); err != nil {
+			log.Warnf("Failed to record response for %s %s: %v", rec.Method, rec.URL, err)
+			return
+		}
+		log.Infof("Recorded response for %s %s to %s (%d bytes)", rec.Method, rec.URL, rec.file, len(body))
+	}
+	if !hasResponseBody(resp) {
+		save(nil)
+		return
+	}
+	if resp.ContentLength > s.maxBody {
+		return
+	}
+	resp.Body = &cachingBody{ReadCloser: resp.Body, limit: s.maxBody, onComplete: save}
+}
+
+// writeRecording 先写入临时文件再重命名，回放时不会读到写了一半的文件
+func writeRecording(rec *recording) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(rec.file), ".recording-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), rec.file)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 服务注册中心的请求超时、Consul阻塞查询的等待时间和出错后的重试间隔
+const (
+	registryTimeout      = 10 * time.Second
+	consulWaitTime       = 5 * time.Minute
+	registryRetryBackoff = 5 * time.Second
+)
+
+// registryClient 访问Consul和etcd的客户端，不经过-upstream-proxy
+var registryClient = &http.Client{}
+
+// consulServiceEntry /v1/health/service返回的实例，只解析用到的字段
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Weights struct {
+			Passing int
+		}
+	}
+}
+
+// consulService 查询Consul中通过健康检查的服务实例；index大于0时为阻塞查询，实例变化或等待超时后返回。
+// query中的tag和dc分别按标签和数据中心过滤
+func consulService(ctx context.Context, name string, query url.Values, index uint64) ([]discoveredAddr, uint64, error) {
+	params := url.Values{"passing": {"true"}}
+	for _, key := range []string{"tag", "dc"} {
+		if v := query.Get(key); v != "" {
+			params.Set(key, v)
+		}
+	}
+	timeout := registryTimeout
+	if index > 0 {
+		params.Set("index", strconv.FormatUint(index, 10))
+		params.Set("wait", consulWaitTime.String())
+		timeout += consulWaitTime
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	target := strings.TrimSuffix(consulAddr, "/") + "/v1/health/service/" + url.PathEscape(name) + "?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if consulToken != "" {
+		req.Header.Set("X-Consul-Token", consulToken)
+	}
+	resp, err := registryClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, 0, fmt.Errorf("consul returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("invalid consul response: %w", err)
+	}
+	newIndex, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	var addrs []discoveredAddr
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		weight := e.Service.Weights.Passing
+		if weight <= 0 {
+			weight = 1
+		}
+		addrs = append(addrs, discoveredAddr{host: net.JoinHostPort(host, strconv.Itoa(e.Service.Port)), weight: weight})
+	}
+	return addrs, newIndex, nil
+}
+
+// watchConsul 以阻塞查询跟踪Consul中的实例变化，直到stop关闭
+func (d *backendDiscovery) watchConsul(key string, n *discoveredName) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-n.stop
+		cancel()
+	}()
+	index := n.index
+	for ctx.Err() == nil {
+		addrs, newIndex, err := consulService(ctx, n.name, n.query, index)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warnf("Failed to watch consul service %s, retrying in %s: %v", key, registryRetryBackoff, err)
+				sleepContext(ctx, registryRetryBackoff)
+			}
+			continue
+		}
+		// 索引变小说明Consul的状态被重置，重新从头查询
+		if newIndex < index {
+			newIndex = 0
+		}
+		index = newIndex
+		d.update(key, n, addrs)
+	}
+}
+
+// etcdKeyValue etcd v3 JSON网关返回的键值，键和值为base64
+type etcdKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// etcdRangeResponse /v3/kv/range的响应，64位整数以字符串表示
+type etcdRangeResponse struct {
+	Header struct {
+		Revision string `json:"revision"`
+	} `json:"header"`
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+// etcdKeyRange 返回前缀查询的key和range_end（base64）
+func etcdKeyRange(prefix string) map[string]interface{} {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	return map[string]interface{}{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(end),
+	}
+}
+
+// etcdPost 依次向各etcd节点发送请求，返回第一个成功的响应
+func etcdPost(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, endpoint := range splitList(etcdEndpoints) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+path, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := registryClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			lastErr = fmt.Errorf("etcd %s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(msg)))
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// etcdService 读取etcd中 -etcd-prefix/name/ 下的全部实例，返回实例和当前revision
+func etcdService(ctx context.Context, name string) ([]discoveredAddr, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, registryTimeout)
+	defer cancel()
+	resp, err := etcdPost(ctx, "/v3/kv/range", etcdKeyRange(etcdServicePrefix(name)))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	var result etcdRangeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, fmt.Errorf("invalid etcd response: %w", err)
+	}
+	revision, _ := strconv.ParseInt(result.Header.Revision, 10, 64)
+	var addrs []discoveredAddr
+	for _, kv := range result.Kvs {
+		key, _ := base64.StdEncoding.DecodeString(kv.Key)
+		value, _ := base64.StdEncoding.DecodeString(kv.Value)
+		a, err := parseEtcdInstance(value)
+		if err != nil {
+			logger.Warnf("Ignoring etcd key %s: %v", key, err)
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, revision, nil
+}
+
+// etcdServicePrefix 返回服务实例所在的键前缀
+func etcdServicePrefix(name string) string {
+	return strings.TrimSuffix(etcdPrefix, "/") + "/" + name + "/"
+}
+
+// parseEtcdInstance 解析etcd中的实例值：host:port、URL，或带addr（或Addr、address）和可选weight的JSON对象
+func parseEtcdInstance(value []byte) (discoveredAddr, error) {
+	raw := strings.TrimSpace(string(value))
+	a := discoveredAddr{weight: 1}
+	if strings.HasPrefix(raw, "{") {
+		var v struct {
+			Addr    string `json:"addr"`
+			Address string `json:"address"`
+			Weight  int    `json:"weight"`
+		}
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			return a, err
+		}
+		raw = v.Addr
+		if raw == "" {
+			raw = v.Address
+		}
+		if v.Weight > 0 {
+			a.weight = v.Weight
+		}
+	}
+	if strings.Contains(raw, "://") {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return a, err
+		}
+		raw = u.Host
+	}
+	if _, _, err := net.SplitHostPort(raw); err != nil {
+		return a, fmt.Errorf("invalid instance address %q, expected host:port", raw)
+	}
+	a.host = raw
+	return a, nil
+}
+
+// watchEtcd 通过etcd的watch接口跟踪实例变化，前缀下的键有变化时重新读取全部实例，直到stop关闭
+func (d *backendDiscovery) watchEtcd(key string, n *discoveredName) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-n.stop
+		cancel()
+	}()
+	revision := int64(n.index)
+	for ctx.Err() == nil {
+		err := etcdWatch(ctx, n.name, revision+1, func() error {
+			addrs, rev, err := etcdService(ctx, n.name)
+			if err != nil {
+				return err
+			}
+			revision = rev
+			d.update(key, n, addrs)
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warnf("Failed to watch etcd service %s, retrying in %s: %v", key, registryRetryBackoff, err)
+		sleepContext(ctx, registryRetryBackoff)
+		// 重新建立watch前读取一次，补上断开期间的变化
+		if addrs, rev, err := etcdService(ctx, n.name); err == nil {
+			revision = rev
+			d.update(key, n, addrs)
+		}
+	}
+}
+
+// etcdWatch 从revision开始watch服务前缀，收到事件时调用changed，连接断开或出错时返回
+func etcdWatch(ctx context.Context, name string, revision int64, changed func() error) error {
+	create := etcdKeyRange(etcdServicePrefix(name))
+	create["start_revision"] = strconv.FormatInt(revision, 10)
+	resp, err := etcdPost(ctx, "/v3/watch", map[string]interface{}{"create_request": create})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Canceled     bool              `json:"canceled"`
+				CancelReason string            `json:"cancel_reason"`
+				Events       []json.RawMessage `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := decoder.Decode(&msg); err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("etcd watch: %s", msg.Error.Message)
+		}
+		if msg.Result.Canceled {
+			return fmt.Errorf("etcd watch canceled: %s", msg.Result.CancelReason)
+		}
+		if len(msg.Result.Events) > 0 {
+			if err := changed(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sleepContext 等待d或ctx结束
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// errRequestTooLarge 请求体超过路由允许的大小
+var errRequestTooLarge = errors.New("request body too large")
+
+// prepareRequestBody 按路由上限限制请求体大小，并将不超过bufferBytes的请求体预先读入内存。
+// 缓冲后的请求带有GetBody，可以在后端失败时重放；更大的请求体边读边转发，不占用内存。
+// 声明的Content-Length超过上限时直接拒绝，分块上传的请求在读取到超限时拒绝。
+// 返回的错误为errRequestTooLarge时应返回413，其他错误为读取客户端请求体失败
+func prepareRequestBody(w http.ResponseWriter, r *http.Request, limit, bufferBytes int64) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	if limit > 0 {
+		if r.ContentLength > limit {
+			return errRequestTooLarge
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	if bufferBytes <= 0 || r.ContentLength > bufferBytes {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, bufferBytes+1))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return errRequestTooLarge
+		}
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	if int64(len(data)) > bufferBytes {
+		// 分块上传的请求体超过缓冲大小，已读取部分与剩余部分拼接后继续流式转发
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+		return nil
+	}
+	r.Body.Close()
+	// 缓冲后长度已知，以Content-Length而不是分块编码转发给后端
+	r.ContentLength = int64(len(data))
+	r.TransferEncoding = nil
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return nil
+}
+
+// isRequestTooLarge 判断转发过程中的错误是否由请求体超过上限引起
+func isRequestTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.Is(err, errRequestTooLarge) || errors.As(err, &maxErr)
+}
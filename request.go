@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// requestInfo 单个请求在处理函数、Director、ModifyResponse和ErrorHandler之间共享的信息
+type requestInfo struct {
+	id           string
+	start        time.Time
+	backend      *url.URL
+	route        *route
+	routeMatched bool
+	canary       bool            // 请求分给了路由的金丝雀后端
+	listener     *listenerConfig // 接收请求的额外监听器，主监听器为nil
+	backendPath  string
+	cacheKey     string        // 可缓存请求的基础缓存键，为空表示不参与缓存
+	cacheStale   *cacheEntry   // 向后端发送条件请求确认的过期缓存项
+	clientHeader http.Header   // 客户端原始请求头，用于按Vary计算缓存键
+	log          *logrus.Entry // 带request_id字段的日志记录器，该请求的日志都通过它输出
+	span         *span         // 该请求的trace span，未启用追踪时为nil
+	capture      *bodyCapture  // 调试模式下记录的请求和响应体，未启用时为nil
+	record       *recording    // 待录制的请求，响应完整转发后写入录制文件；未录制时为nil
+	breaker      *breakerCall  // 熔断器放行的本次调用，未启用熔断时为nil
+
+	upstreamStatus int   // 后端返回的状态码，未收到响应时为0
+	proxyErr       error // 请求后端失败时的错误
+}
+
+// 请求上下文中保存的值的键
+type contextKey int
+
+const (
+	requestInfoKey contextKey = iota
+	listenerKey               // 连接所属的额外监听器，由http.Server.ConnContext写入
+	bandwidthKey              // 连接的下载带宽预约，由http.Server.ConnContext写入
+)
+
+// withRequestInfo 将请求信息保存到请求上下文中
+func withRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+}
+
+// getRequestInfo 返回请求上下文中的请求信息，不存在时返回以当前时间开始的空信息
+func getRequestInfo(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{start: time.Now(), log: logrus.NewEntry(logger)}
+}
+
+// maxRequestIDLength 客户端传入的请求ID的最大长度
+const maxRequestIDLength = 128
+
+// isValidRequestID 检查客户端传入的请求ID，只接受长度有限的可打印ASCII字符，避免日志注入
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID 生成随机的请求ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
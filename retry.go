@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// idempotencyKeyHeader 客户端声明请求可安全重放时携带的头，带此头的非幂等请求同样允许重试
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// retryTransport 请求后端失败（连接错误或指定的状态码）时按指数退避重试。
+// 只重试幂等方法或带Idempotency-Key的请求，请求体需能完整缓冲在内存中以便重放
+type retryTransport struct {
+	base       http.RoundTripper
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	statuses   map[int]bool
+	maxBody    int64
+}
+
+// parseRetryStatuses 解析逗号分隔的需要重试的状态码
+func parseRetryStatuses(list string) (map[int]bool, error) {
+	statuses := map[int]bool{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		code, err := strconv.Atoi(item)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status code %q", item)
+		}
+		statuses[code] = true
+	}
+	return statuses, nil
+}
+
+// isIdempotent 判断请求按HTTP语义是否可以安全重放
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(idempotencyKeyHeader) != ""
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req) || isUpgradeRequest(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	// 缓冲请求体以便重放，处理函数已缓冲的请求体直接使用其GetBody，超过上限的请求不重试
+	getBody := req.GetBody
+	if getBody == nil && req.Body != nil && req.Body != http.NoBody {
+		data, err := io.ReadAll(io.LimitReader(req.Body, t.maxBody+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > t.maxBody {
+			req.Body = readCloser{io.MultiReader(bytes.NewReader(data), req.Body), req.Body}
+			return t.base.RoundTrip(req)
+		}
+		req.Body.Close()
+		getBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+
+	log := getRequestInfo(req).log
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if getBody != nil {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+			attemptReq.GetBody = getBody
+		}
+		resp, err := t.base.RoundTrip(attemptReq)
+
+		reason := ""
+		switch {
+		case err != nil:
+			if errors.Is(err, context.Canceled) {
+				return nil, err
+			}
+			reason = err.Error()
+		case t.statuses[resp.StatusCode]:
+			reason = resp.Status
+		default:
+			return resp, nil
+		}
+		if attempt >= t.retries {
+			return resp, err
+		}
+
+		// 丢弃本次失败的响应，释放连接后再重试
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		wait := t.backoff << attempt
+		if wait > t.maxBackoff || wait <= 0 {
+			wait = t.maxBackoff
+		}
+		log.Warnf("Retrying %s %s in %s (attempt %d/%d): %s", req.Method, req.URL, wait, attempt+1, t.retries, reason)
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// readCloser 组合读取和关闭，用于将已读出的部分请求体接回原请求体
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// urlReplacer 将响应体中的后端URL替换为代理公开URL，
+// 既可以一次替换整个缓冲的响应体，也可以包装Reader流式替换
+type urlReplacer struct {
+	pairs    []replacePair // 按from长度从长到短排序，同一位置优先匹配较长的地址
+	replacer *strings.Replacer
+	first    [256]bool // 各from的首字节，用于快速跳过不可能匹配的位置
+}
+
+type replacePair struct{ from, to string }
+
+// newURLReplacer 创建将各路由后端基础URL替换为代理公开URL的替换器：
+// 默认路由的后端替换为publicBase，其他路由的后端替换为publicBase的源站加路由前缀。
+// 同时处理JSON中斜杠被转义为"\/"的写法
+func newURLReplacer(table *routeTable, publicBase string) *urlReplacer {
+	var pairs []replacePair
+	origin := publicBase
+	if u, err := url.Parse(publicBase); err == nil && u.Host != "" {
+		origin = u.Scheme + "://" + u.Host
+	}
+	escape := func(s string) string { return strings.ReplaceAll(s, "/", `\/`) }
+	for _, r := range table.routes {
+		to := origin + r.prefix
+		if r == table.defaultRoute {
+			to = publicBase
+		} else if len(r.hosts) > 0 {
+			// 主机路由替换为第一个非通配主机下的地址，只有通配主机时无法确定公开地址，不替换
+			host := ""
+			for _, h := range r.hosts {
+				if !strings.HasPrefix(h, "*.") {
+					host = h
+					break
+				}
+			}
+			if host == "" {
+				continue
+			}
+			scheme := "https"
+			if u, err := url.Parse(publicBase); err == nil && u.Scheme != "" {
+				scheme = u.Scheme
+			}
+			to = scheme + "://" + host + r.prefix
+		}
+		for _, b := range r.allBackends() {
+			pairs = append(pairs, replacePair{b.String(), to}, replacePair{escape(b.String()), escape(to)})
+		}
+	}
+	// 较长的后端地址优先匹配，避免被其前缀地址抢先替换
+	sort.SliceStable(pairs, func(i, j int) bool { return len(pairs[i].from) > len(pairs[j].from) })
+
+	u := &urlReplacer{pairs: pairs}
+	var args []string
+	for _, p := range pairs {
+		args = append(args, p.from, p.to)
+		u.first[p.from[0]] = true
+	}
+	u.replacer = strings.NewReplacer(args...)
+	return u
+}
+
+// Replace 替换字符串中的全部后端URL
+func (u *urlReplacer) Replace(s string) string {
+	return u.replacer.Replace(s)
+}
+
+// replacingReader 流式替换后端URL的Reader。每次读取后只输出确定不会再变化的部分，
+// 末尾可能是某个后端地址开头的数据留到下次读取后再判断，因此地址跨越读取边界时也能替换
+type replacingReader struct {
+	u       *urlReplacer
+	src     io.Reader
+	pending []byte // 已读取但尚未处理的数据
+	out     []byte // 已处理、等待输出的数据
+	err     error
+	changed bool
+}
+
+func (u *urlReplacer) newReader(r io.Reader) *replacingReader {
+	return &replacingReader{u: u, src: r}
+}
+
+func (r *replacingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		buf := make([]byte, 32<<10)
+		n, err := r.src.Read(buf)
+		r.pending = append(r.pending, buf[:n]...)
+		r.err = err
+		r.process(err != nil)
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// process 从头扫描待处理数据并替换匹配的地址。未到结尾时，
+// 若剩余数据是某个地址的开头则停下等待更多数据
+func (r *replacingReader) process(final bool) {
+	data := r.pending
+	i := 0
+scan:
+	for i < len(data) {
+		if !r.u.first[data[i]] {
+			i++
+			continue
+		}
+		for _, p := range r.u.pairs {
+			if bytes.HasPrefix(data[i:], []byte(p.from)) {
+				r.out = append(r.out, data[:i]...)
+				r.out = append(r.out, p.to...)
+				r.changed = true
+				data = data[i+len(p.from):]
+				i = 0
+				continue scan
+			}
+		}
+		if !final {
+			for _, p := range r.u.pairs {
+				if len(data)-i < len(p.from) && strings.HasPrefix(p.from, string(data[i:])) {
+					break scan
+				}
+			}
+		}
+		r.out = append(r.out, data[:i+1]...)
+		data = data[i+1:]
+		i = 0
+	}
+	r.out = append(r.out, data[:i]...)
+	r.pending = append(r.pending[:0], data[i:]...)
+}
+
+// isRewritableContentType 判断响应是否为可改写的文本类型（json/html/text），
+// 事件流属于流式响应，不做改写
+func isRewritableContentType(header http.Header) bool {
+	mediaType, _, _ := strings.Cut(header.Get("Content-Type"), ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+	return false
+}
+
+// rewriteResponseBody 替换文本响应体中的后端URL。长度已知且不超过maxBytes的响应体整体读取后替换并修正Content-Length；
+// 更大或长度未知（分块传输）的响应体改为流式替换，去掉Content-Length后按分块返回，不在内存中缓存整个响应体
+func rewriteResponseBody(resp *http.Response, replacer *urlReplacer, maxBytes int64) error {
+	if !hasResponseBody(resp) || !isRewritableContentType(resp.Header) {
+		return nil
+	}
+	info := getRequestInfo(resp.Request)
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		info.log.Warnf("Skipping body rewrite for %s: body is %s encoded (enable -decompress-body)", resp.Request.URL.Path, encoding)
+		return nil
+	}
+	if resp.ContentLength < 0 || resp.ContentLength > maxBytes {
+		streamRewrite(resp, replacer, resp.Body)
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return fmt.Errorf("failed to read response body for rewrite: %w", err)
+	}
+	if int64(len(body)) > maxBytes {
+		// 实际长度超过声明的Content-Length上限，已读取部分与剩余部分拼接后流式替换
+		streamRewrite(resp, replacer, io.MultiReader(bytes.NewReader(body), resp.Body))
+		return nil
+	}
+	resp.Body.Close()
+
+	rewritten := replacer.Replace(string(body))
+	resp.Body = io.NopCloser(strings.NewReader(rewritten))
+	resp.ContentLength = int64(len(rewritten))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(rewritten)))
+	if rewritten != string(body) {
+		info.log.Infof("Rewrote backend URLs in response body for %s (%d -> %d bytes)", resp.Request.URL.Path, len(body), len(rewritten))
+	}
+	return nil
+}
+
+// streamRewrite 将响应体替换为流式替换后端URL的Reader，替换后长度未知，因此去掉Content-Length
+func streamRewrite(resp *http.Response, replacer *urlReplacer, src io.Reader) {
+	resp.Body = &bodyReadCloser{Reader: replacer.newReader(src), closers: []io.Closer{resp.Body}}
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	getRequestInfo(resp.Request).log.Infof("Rewriting backend URLs in streamed response body for %s", resp.Request.URL.Path)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 直接输出到标准输出或标准错误的日志目标
+const (
+	logOutputStdout = "stdout"
+	logOutputStderr = "stderr"
+)
+
+// logRotation 日志文件的轮转和保留策略
+type logRotation struct {
+	maxSizeMB  int  // 单个文件的大小上限，0表示只按日期轮转
+	maxBackups int  // 保留的旧文件数量，0表示不限制
+	maxAgeDays int  // 旧文件保留天数，0表示不限制
+	compress   bool // 是否将旧文件压缩为.gz
+}
+
+// openLogOutput 打开日志输出：stdout/stderr直接输出，其他值为日志目录，按日期和大小轮转
+func openLogOutput(target, prefix string, rotation logRotation) (io.Writer, error) {
+	switch target {
+	case logOutputStdout:
+		return os.Stdout, nil
+	case logOutputStderr:
+		return os.Stderr, nil
+	}
+	return newRotatingWriter(target, prefix, rotation)
+}
+
+// rotatingWriter 按日期和大小轮转的日志文件写入器。
+// 当前日志写入 <prefix>_<日期>.log，跨天或超过大小上限时切换文件，
+// 旧文件在后台压缩为.gz（可关闭），并按保留数量和保留天数清理。
+type rotatingWriter struct {
+	dir        string
+	prefix     string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+	compress   bool
+
+	mu   sync.Mutex
+	file *os.File
+	date string
+	size int64
+
+	millCh chan struct{}
+}
+
+func newRotatingWriter(dir, prefix string, rotation logRotation) (*rotatingWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	w := &rotatingWriter{
+		dir:        dir,
+		prefix:     prefix,
+		maxSize:    int64(rotation.maxSizeMB) * 1024 * 1024,
+		maxBackups: rotation.maxBackups,
+		maxAge:     time.Duration(rotation.maxAgeDays) * 24 * time.Hour,
+		compress:   rotation.compress,
+		millCh:     make(chan struct{}, 1),
+	}
+	if err := w.openFile(time.Now().Format("2006-01-02")); err != nil {
+		return nil, err
+	}
+	go w.millLoop()
+	w.mill()
+	return w, nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	today := time.Now().Format("2006-01-02")
+	if today != w.date {
+		// 跨天：关闭前一天的文件，打开新日期文件
+		if err := w.rotate(today, false); err != nil {
+			return 0, err
+		}
+	} else if w.maxSize > 0 && w.size+int64(len(p)) > w.maxSize && w.size > 0 {
+		// 超过大小上限：将当前文件改名为备份后重新打开
+		if err := w.rotate(today, true); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close 关闭当前日志文件
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *rotatingWriter) filename(date string) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%s_%s.log", w.prefix, date))
+}
+
+// openFile 打开（或追加）指定日期的日志文件，调用方需持有锁
+func (w *rotatingWriter) openFile(date string) error {
+	name := w.filename(date)
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	w.file = file
+	w.date = date
+	w.size = info.Size()
+	return nil
+}
+
+// rotate 关闭当前文件并打开新文件，bySize为true时先将当前文件改名为带时间戳的备份
+func (w *rotatingWriter) rotate(date string, bySize bool) error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return fmt.Errorf("failed to close log file: %w", err)
+		}
+		w.file = nil
+	}
+	if bySize {
+		name := w.filename(w.date)
+		backup := filepath.Join(w.dir, fmt.Sprintf("%s_%s.%s.log", w.prefix, w.date, time.Now().Format("150405.000")))
+		if err := os.Rename(name, backup); err != nil {
+			return fmt.Errorf("failed to rename log file: %w", err)
+		}
+	}
+	if err := w.openFile(date); err != nil {
+		return err
+	}
+	w.mill()
+	return nil
+}
+
+// mill 通知后台协程压缩和清理旧日志
+func (w *rotatingWriter) mill() {
+	select {
+	case w.millCh <- struct{}{}:
+	default:
+	}
+}
+
+func (w *rotatingWriter) millLoop() {
+	for range w.millCh {
+		w.millRun()
+	}
+}
+
+// millRun 压缩除当前文件外的旧日志（启用压缩时），并按保留策略删除过期备份
+func (w *rotatingWriter) millRun() {
+	w.mu.Lock()
+	current := w.filename(w.date)
+	w.mu.Unlock()
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "log rotation: failed to read log directory: %v\n", err)
+		return
+	}
+
+	type backupFile struct {
+		path    string
+		modTime time.Time
+	}
+	var backups []backupFile
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(w.dir, name)
+		if entry.IsDir() || path == current || !strings.HasPrefix(name, w.prefix+"_") {
+			continue
+		}
+		if !strings.HasSuffix(name, ".log") && !strings.HasSuffix(name, ".log.gz") {
+			continue
+		}
+		if w.compress && strings.HasSuffix(name, ".log") {
+			if err := compressFile(path); err != nil {
+				fmt.Fprintf(os.Stderr, "log rotation: failed to compress %s: %v\n", path, err)
+				continue
+			}
+			path += ".gz"
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: path, modTime: info.ModTime()})
+	}
+
+	// 按修改时间从新到旧排序
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].modTime.After(backups[j].modTime)
+	})
+	for i, b := range backups {
+		expired := w.maxAge > 0 && time.Since(b.modTime) > w.maxAge
+		overflow := w.maxBackups > 0 && i >= w.maxBackups
+		if expired || overflow {
+			if err := os.Remove(b.path); err != nil {
+				fmt.Fprintf(os.Stderr, "log rotation: failed to remove %s: %v\n", b.path, err)
+			}
+		}
+	}
+}
+
+// compressFile 将文件压缩为同名.gz文件并删除原文件，保留原修改时间
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	os.Chtimes(path+".gz", info.ModTime(), info.ModTime())
+	src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// routeConfig 路由配置文件中的一条路由
+type routeConfig struct {
+	Prefix          string                 `json:"prefix" yaml:"prefix"`                                       // 路径前缀，设置host时可为空，表示该主机的全部路径
+	Host            string                 `json:"host,omitempty" yaml:"host"`                                 // 匹配的Host，逗号分隔，支持*.example.com通配；为空时匹配任意Host
+	Backend         string                 `json:"backend" yaml:"backend"`                                     // 多个后端以逗号分隔
+	Strategy        string                 `json:"strategy,omitempty" yaml:"strategy"`                         // 负载均衡策略，为空时使用-lb-strategy
+	TLS             *backendTLSConfig      `json:"tls,omitempty" yaml:"tls"`                                   // 连接该路由后端的TLS设置，为空时使用全局设置
+	Headers         *routeHeaders          `json:"headers,omitempty" yaml:"headers"`                           // 该路由的请求头和响应头改写规则
+	RateLimit       *rateLimitConfig       `json:"rate_limit,omitempty" yaml:"rate_limit"`                     // 该路由的限流设置，为空时使用-rate-limit
+	CacheTTL        string                 `json:"cache_ttl,omitempty" yaml:"cache_ttl"`                       // 该路由的缓存时间，如30s，为空时使用-cache-ttl，0表示不缓存
+	CORS            *corsConfig            `json:"cors,omitempty" yaml:"cors"`                                 // 该路由的CORS设置，为空时使用-cors-*参数
+	Cookies         *cookieConfig          `json:"cookies,omitempty" yaml:"cookies"`                           // 该路由后端返回的Set-Cookie属性改写规则
+	RewriteBody     *bool                  `json:"rewrite_body,omitempty" yaml:"rewrite_body"`                 // 是否改写该路由响应体中的后端URL，为空时使用-rewrite-body
+	MaxBodyBytes    *int64                 `json:"max_body_bytes,omitempty" yaml:"max_body_bytes"`             // 该路由请求体的最大字节数，为空时使用-max-body-bytes，0表示不限制
+	Timeouts        *timeoutConfig         `json:"timeouts,omitempty" yaml:"timeouts"`                         // 该路由的超时设置，未写出的字段使用全局参数
+	Pool            *poolConfig            `json:"pool,omitempty" yaml:"pool"`                                 // 连接该路由后端的连接池设置，设置相同的路由共用连接池
+	Protocol        string                 `json:"protocol,omitempty" yaml:"protocol"`                         // 后端协议：http（默认）或grpc，grpc以HTTP/2转发并保留trailer
+	JWT             *jwtConfig             `json:"jwt,omitempty" yaml:"jwt"`                                   // 该路由的JWT校验设置，在-jwt-*参数的基础上覆盖
+	APIKey          *bool                  `json:"api_key,omitempty" yaml:"api_key"`                           // 该路由是否要求API Key，为空时配置了Key即要求
+	ACL             *aclConfig             `json:"acl,omitempty" yaml:"acl"`                                   // 该路由的IP访问控制，在全局-allow-cidrs/-deny-cidrs之后检查
+	BasicAuth       *basicAuthConfig       `json:"basic_auth,omitempty" yaml:"basic_auth"`                     // 该路由的Basic认证设置，在-basic-auth-*参数的基础上覆盖
+	Capture         *bool                  `json:"capture,omitempty" yaml:"capture"`                           // 是否在日志中记录该路由的请求和响应体，为空时使用-capture-bodies
+	Rewrite         *pathRewriteConfig     `json:"rewrite,omitempty" yaml:"rewrite"`                           // 该路由的路径改写规则，为空时去掉前缀后转发
+	Query           *queryOps              `json:"query,omitempty" yaml:"query"`                               // 转发前对查询参数的改写规则
+	Sticky          *stickyConfig          `json:"sticky,omitempty" yaml:"sticky"`                             // 该路由的会话保持设置，在-sticky-*参数的基础上覆盖
+	Mirror          *mirrorConfig          `json:"mirror,omitempty" yaml:"mirror"`                             // 该路由的流量镜像设置，在-mirror-*参数的基础上覆盖
+	Canary          *canaryConfig          `json:"canary,omitempty" yaml:"canary"`                             // 该路由的金丝雀分流设置，为空时不分流
+	Fault           *faultConfig           `json:"fault,omitempty" yaml:"fault"`                               // 该路由的故障注入设置，用于容错测试，管理接口可在运行时修改
+	OpenAPI         *openAPIConfig         `json:"openapi,omitempty" yaml:"openapi"`                           // 按OpenAPI文档校验该路由的请求，不符合时返回400
+	Middleware      []middlewareConfig     `json:"middleware,omitempty" yaml:"middleware"`                     // 该路由依次执行的中间件，在-middleware之后执行
+	Script          string                 `json:"script,omitempty" yaml:"script"`                             // 该路由的Lua脚本，定义on_request和/或on_response
+	ScriptFile      string                 `json:"script_file,omitempty" yaml:"script_file"`                   // 从文件读取该路由的Lua脚本，重新加载路由时重新读取
+	Maintenance     *bool                  `json:"maintenance,omitempty" yaml:"maintenance"`                   // 该路由是否处于维护模式，为空时使用-maintenance，管理接口可在运行时切换
+	SlowRequest     string                 `json:"slow_request,omitempty" yaml:"slow_request"`                 // 该路由的慢请求阈值，如2s，为空时使用-slow-request-threshold，0表示不检查
+	LargeResponse   *int64                 `json:"large_response_bytes,omitempty" yaml:"large_response_bytes"` // 该路由的大响应阈值（字节），为空时使用-large-response-threshold，0表示不检查
+	MaxResponse     *int64                 `json:"max_response_bytes,omitempty" yaml:"max_response_bytes"`     // 该路由响应体的最大字节数，为空时使用-max-response-bytes，0表示不限制
+	DownloadRate    *int64                 `json:"download_rate,omitempty" yaml:"download_rate"`               // 该路由每个客户端连接的下载限速（字节/秒），为空时使用-download-rate，0表示不限速
+	Methods         []string               `json:"methods,omitempty" yaml:"methods"`                           // 该路由允许的请求方法，其他方法返回405，为空时使用-allowed-methods
+	SecurityHeaders *securityHeadersConfig `json:"security_headers,omitempty" yaml:"security_headers"`         // 该路由注入的安全响应头，在-security-headers等参数的基础上覆盖
+	Signing         *signingConfig         `json:"signing,omitempty" yaml:"signing"`                           // 用配置的凭据为转发给后端的请求签名（HMAC或AWS SigV4）
+
+	listener string        // 路由所属监听器的路由集合，为空时为全局路由
+	tenant   *tenantConfig // 路由所属的租户，为nil时不属于任何租户
+}
+
+// routesFile 路由配置文件格式
+type routesFile struct {
+	Routes  []routeConfig  `json:"routes"`
+	Tenants []tenantConfig `json:"tenants,omitempty"`
+}
+
+// route 一条路由规则：匹配主机和前缀的请求去掉前缀后转发到该路由的后端
+type route struct {
+	prefix        string
+	hosts         []string // 匹配的Host（小写、不含端口），*.example.com为通配；为空时匹配任意Host
+	backends      []*url.URL
+	selector      *backendSelector
+	transport     http.RoundTripper // 路由单独配置TLS、超时或连接池时使用的共享Transport，为nil时使用全局Transport
+	headers       *routeHeaders     // 路由的请求头和响应头改写规则，在全局规则之后执行
+	rateLimit     *rateLimit        // 路由的限流策略，为nil时不限流
+	cacheTTL      time.Duration     // 后端未声明有效期时的缓存时间，0表示该路由不缓存
+	cors          *corsPolicy       // 路由的CORS策略，为nil时不处理CORS，预检请求和响应头都由后端决定
+	cookies       *cookieConfig     // 路由的Set-Cookie改写规则，为nil时不改写
+	rewriteBody   bool              // 是否将响应体中的后端URL替换为代理公开URL
+	maxBodyBytes  int64             // 请求体的最大字节数，0表示不限制
+	timeouts      routeTimeouts     // 路由的超时设置
+	grpc          bool              // 以HTTP/2透传gRPC请求：http后端使用h2c，不缓冲请求体、不设置Connection头
+	jwt           *jwtPolicy        // 路由的JWT校验策略，为nil时不校验
+	apiKey        bool              // 是否要求请求携带有效的API Key
+	acl           *ipACL            // 路由的IP访问控制，为nil时不检查
+	basicAuth     *basicAuthPolicy  // 路由的Basic认证策略，为nil时不认证
+	capture       bool              // 是否在日志中记录请求和响应体
+	rewrite       *pathRewrite      // 路由的路径改写规则，为nil时去掉前缀后拼接到后端基础路径
+	query         *queryOps         // 路由的查询参数改写规则，为nil时原样转发
+	mirror        *mirror           // 路由的流量镜像，为nil时不镜像
+	canary        *canaryRelease    // 路由的金丝雀分流，为nil时全部请求使用backends
+	fault         *faultPolicy      // 路由配置的故障注入，运行时以faultSwitch为准
+	openapi       *openAPIValidator // 路由的OpenAPI请求校验，为nil时不校验
+	middleware    middlewareChain   // 路由的请求和响应中间件
+	script        *routeScript      // 路由的Lua脚本，为nil时不执行
+	maintenance   bool              // 路由配置的维护模式初始状态，运行时以maintenanceSwitch为准
+	slowRequest   time.Duration     // 请求耗时超过该值时记录告警，0表示不检查
+	largeResponse int64             // 响应体超过该字节数时记录告警，0表示不检查
+	maxResponse   int64             // 响应体的最大字节数，超过时中断响应，0表示不限制
+	downloadRate  int64             // 每个客户端连接的下载限速（字节/秒），0表示不限速
+	methods       []string          // 允许的请求方法，为空时不限制
+	security      *securityHeaders  // 注入的安全响应头，为nil时不注入
+	signer        *requestSigner    // 出站请求签名，为nil时不签名
+	tenant        string            // 路由所属的租户，为空时不属于任何租户
+	tenantKeys    *apiKeyStore      // 租户自己的API Key，为nil时使用全局Key
+	listener      string            // 路由所属监听器的路由集合，只匹配该监听器收到的请求；为空时为全局路由
+}
+
+// routeTable 路由表，先按主机再按最长前缀匹配；未匹配任何路由的请求使用默认路由且不剥离前缀
+type routeTable struct {
+	routes       []*route // 按主机和前缀长度排序
+	defaultRoute *route
+	replacer     *urlReplacer // 响应体改写使用的后端URL替换器，没有路由启用改写时为nil
+	apiKeys      *apiKeyStore // 有效的API Key，未配置Key时为nil
+}
+
+// normalizePrefix 确保路由前缀以斜杠开头和结尾
+func normalizePrefix(prefix string) string {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	return prefix
+}
+
+// newRoute 创建一条路由，backendList为逗号分隔的后端地址
+func newRoute(prefix, backendList, strategy, hashHeader string, healthy func(*url.URL) bool) (*route, error) {
+	if strings.TrimSpace(prefix) == "" {
+		return nil, fmt.Errorf("route prefix must not be empty")
+	}
+	if err := validateStrategy(strategy); err != nil {
+		return nil, fmt.Errorf("route %s: %w", prefix, err)
+	}
+	backends, weights, err := parseBackends(backendList)
+	if err != nil {
+		return nil, fmt.Errorf("route %s: %w", prefix, err)
+	}
+	return &route{
+		prefix:   normalizePrefix(prefix),
+		backends: backends,
+		selector: newBackendSelector(backends, weights, strategy, hashHeader, healthy),
+	}, nil
+}
+
+// parseRouteFlags 解析 [host]prefix=backend 形式的路由参数，多个后端以逗号分隔；
+// 等号左边不以/开头时，第一个/之前的部分为主机，如 api.example.com/=https://a.internal/
+func parseRouteFlags(values []string) ([]routeConfig, error) {
+	var configs []routeConfig
+	for _, v := range values {
+		prefix, backend, ok := strings.Cut(v, "=")
+		prefix, backend = strings.TrimSpace(prefix), strings.TrimSpace(backend)
+		if !ok || prefix == "" || backend == "" {
+			return nil, fmt.Errorf("invalid route %q, expected prefix=backend", v)
+		}
+		c := routeConfig{Prefix: prefix, Backend: backend}
+		if !strings.HasPrefix(prefix, "/") {
+			host, path, _ := strings.Cut(prefix, "/")
+			c.Host, c.Prefix = host, "/"+path
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+// parseRouteHosts 解析逗号分隔的主机列表，*.example.com匹配example.com的任意子域名
+func parseRouteHosts(list string) ([]string, error) {
+	var hosts []string
+	for _, h := range splitList(list) {
+		h = normalizeHost(h)
+		name := strings.TrimPrefix(h, "*.")
+		if name == "" || strings.ContainsAny(name, "*/:") {
+			return nil, fmt.Errorf("invalid host %q, expected example.com or *.example.com", h)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// normalizeHost 返回小写、不含端口和末尾点号的主机名
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// name 返回路由在日志、指标和限流中使用的名称，主机路由为 主机+前缀，监听器独立的路由前面加上[监听器名称]
+func (r *route) name() string {
+	name := r.prefix
+	if len(r.hosts) > 0 {
+		name = strings.Join(r.hosts, ",") + r.prefix
+	}
+	if r.listener != "" {
+		return "[" + r.listener + "]" + name
+	}
+	return name
+}
+
+// strippedPath 返回请求路径去掉路由前缀或按改写规则映射后的路径，拼接在后端基础路径之后；
+// 未匹配任何路由（使用默认路由）时不剥离前缀
+func (r *route) strippedPath(path string, matched bool) string {
+	if !matched {
+		return path
+	}
+	if r.rewrite != nil {
+		path = r.rewrite.apply(path, r.prefix)
+	} else {
+		path = strings.TrimPrefix(path, r.prefix)
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// hostRank 返回路由的主机与请求Host的匹配程度：精确匹配最高，通配按后缀长度，
+// 未限制主机的路由为0，不匹配时为-1
+func (r *route) hostRank(host string) int {
+	if len(r.hosts) == 0 {
+		return 0
+	}
+	best := -1
+	for _, h := range r.hosts {
+		if h == host {
+			return 1 << 20
+		}
+		if suffix, ok := strings.CutPrefix(h, "*"); ok && strings.HasSuffix(host, suffix) && len(host) > len(suffix) && len(suffix) > best {
+			best = len(suffix)
+		}
+	}
+	return best
+}
+
+// loadRoutesFile 读取JSON格式的路由配置文件
+func loadRoutesFile(path string) ([]routeConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file routesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	tenantRoutes, err := expandTenants(file.Tenants)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return append(file.Routes, tenantRoutes...), nil
+}
+
+// newRouteTable 由默认路由和额外路由构建路由表，未限制主机且与默认路由前缀相同的额外路由覆盖默认路由
+func newRouteTable(defaultRoute *route, extra []*route) (*routeTable, error) {
+	byName := map[string]*route{defaultRoute.name(): defaultRoute}
+	seen := map[string]bool{}
+	for _, r := range extra {
+		if seen[r.name()] {
+			return nil, fmt.Errorf("duplicate route %s", r.name())
+		}
+		seen[r.name()] = true
+		byName[r.name()] = r
+	}
+
+	t := &routeTable{defaultRoute: byName[defaultRoute.name()]}
+	for _, r := range byName {
+		t.routes = append(t.routes, r)
+	}
+	sort.Slice(t.routes, func(i, j int) bool {
+		if (len(t.routes[i].hosts) > 0) != (len(t.routes[j].hosts) > 0) {
+			return len(t.routes[i].hosts) > 0
+		}
+		if len(t.routes[i].prefix) != len(t.routes[j].prefix) {
+			return len(t.routes[i].prefix) > len(t.routes[j].prefix)
+		}
+		return t.routes[i].name() < t.routes[j].name()
+	})
+	return t, nil
+}
+
+// match 先按Host再按最长前缀匹配路由：匹配主机的路由优先于未限制主机的路由，
+// 精确主机优先于通配主机。只考虑listener的路由集合和默认路由，前缀相同时监听器的路由优先；未匹配时返回默认路由和false
+func (t *routeTable) match(listener, host, path string) (*route, bool) {
+	host = normalizeHost(host)
+	var best *route
+	bestRank := 0
+	for _, r := range t.routes {
+		if r.listener != listener && r != t.defaultRoute {
+			continue
+		}
+		rank := r.hostRank(host)
+		if rank < 0 || !strings.HasPrefix(path, r.prefix) {
+			continue
+		}
+		if best == nil || rank > bestRank || rank == bestRank && len(r.prefix) > len(best.prefix) ||
+			rank == bestRank && len(r.prefix) == len(best.prefix) && r.listener != best.listener {
+			best, bestRank = r, rank
+		}
+	}
+	if best == nil {
+		return t.defaultRoute, false
+	}
+	return best, true
+}
+
+// allBackends 返回路由表中的全部后端
+func (t *routeTable) allBackends() []*url.URL {
+	var backends []*url.URL
+	for _, r := range t.routes {
+		backends = append(backends, r.allBackends()...)
+	}
+	return backends
+}
+
+// allBackends 返回路由的后端和金丝雀后端
+func (r *route) allBackends() []*url.URL {
+	if r.canary == nil {
+		return r.backends
+	}
+	return append(append([]*url.URL{}, r.backends...), r.canary.backends...)
+}
+
+// currentRoutes 当前生效的路由表，重新加载时原子替换
+var currentRoutes atomic.Pointer[routeTable]
+
+// routeTransport 按请求匹配的路由选择Transport，路由未单独配置时使用全局Transport
+type routeTransport struct {
+	base http.RoundTripper
+}
+
+func (t *routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt := getRequestInfo(req).route; rt != nil && rt.transport != nil {
+		return rt.transport.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// statsSlotSeconds 滚动统计的时间粒度，每条路由每分钟一个槽位
+	statsSlotSeconds = 60
+	// defaultStatsWindow 统计接口未指定window时的统计区间
+	defaultStatsWindow = 5 * time.Minute
+)
+
+// statsLatencyBounds 延迟分桶的上限（毫秒），从1ms起按1.2倍增长到约2分钟，
+// 百分位按桶上限估算，相对误差不超过20%
+var statsLatencyBounds = func() []float64 {
+	var bounds []float64
+	for b := 1.0; b < 120000; b *= 1.2 {
+		bounds = append(bounds, math.Round(b*100)/100)
+	}
+	return bounds
+}()
+
+// statsSlot 一条路由一分钟内的请求统计
+type statsSlot struct {
+	minute       int64 // Unix时间的分钟数，用于判断槽位是否已过期
+	requests     uint64
+	errors       uint64 // 5xx响应
+	clientErrors uint64 // 4xx响应
+	sumMs        float64
+	maxMs        float64
+	latency      []uint32 // 与statsLatencyBounds一一对应，最后一个为超出上限的请求
+}
+
+// routeStats 在内存中按路由保存最近一段时间的请求统计（请求速率、延迟百分位和错误率），
+// 供无法部署Prometheus的环境通过管理接口和定期汇总日志查看
+type routeStats struct {
+	slots int // 每条路由保留的槽位数，决定可查询的最长区间
+	start time.Time
+
+	mu     sync.Mutex
+	routes map[string][]statsSlot
+}
+
+// newRouteStats 创建保留retention时长统计的路由统计
+func newRouteStats(retention time.Duration) *routeStats {
+	slots := int(retention / (statsSlotSeconds * time.Second))
+	if slots < 1 {
+		slots = 1
+	}
+	return &routeStats{slots: slots, start: time.Now(), routes: map[string][]statsSlot{}}
+}
+
+// observe 记录一个已完成的请求，未匹配路由的请求（如静态文件）不统计
+func (s *routeStats) observe(info *requestInfo, status int) {
+	if info.route == nil {
+		return
+	}
+	now := time.Now()
+	ms := float64(now.Sub(info.start)) / float64(time.Millisecond)
+	minute := now.Unix() / statsSlotSeconds
+	bucket := sort.SearchFloat64s(statsLatencyBounds, ms)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ring, ok := s.routes[info.route.name()]
+	if !ok {
+		ring = make([]statsSlot, s.slots)
+		s.routes[info.route.name()] = ring
+	}
+	slot := &ring[minute%int64(s.slots)]
+	if slot.minute != minute || slot.latency == nil {
+		*slot = statsSlot{minute: minute, latency: make([]uint32, len(statsLatencyBounds)+1)}
+	}
+	slot.requests++
+	switch {
+	case status >= 500:
+		slot.errors++
+	case status >= 400:
+		slot.clientErrors++
+	}
+	slot.sumMs += ms
+	if ms > slot.maxMs {
+		slot.maxMs = ms
+	}
+	slot.latency[bucket]++
+}
+
+// routeSummary 一条路由在统计区间内的汇总
+type routeSummary struct {
+	Route        string  `json:"route"`
+	Requests     uint64  `json:"requests"`
+	RPS          float64 `json:"rps"`
+	ErrorRate    float64 `json:"error_rate"`    // 5xx响应的比例
+	ClientErrors uint64  `json:"client_errors"` // 4xx响应数
+	MeanMs       float64 `json:"mean_ms"`
+	P50Ms        float64 `json:"p50_ms"`
+	P95Ms        float64 `json:"p95_ms"`
+	P99Ms        float64 `json:"p99_ms"`
+	MaxMs        float64 `json:"max_ms"`
+}
+
+// summarize 汇总最近window内各路由的统计，window按分钟取整，区间内没有请求的路由不输出
+func (s *routeStats) summarize(window time.Duration) []routeSummary {
+	now := time.Now()
+	minutes := int64((window + statsSlotSeconds*time.Second - 1) / (statsSlotSeconds * time.Second))
+	if minutes < 1 {
+		minutes = 1
+	}
+	if minutes > int64(s.slots) {
+		minutes = int64(s.slots)
+	}
+	// 当前分钟尚未结束，区间包括当前分钟和之前的minutes-1个完整分钟，按实际经过的时间计算速率
+	current := now.Unix() / statsSlotSeconds
+	oldest := current - minutes + 1
+	elapsed := now.Sub(time.Unix(oldest*statsSlotSeconds, 0)).Seconds()
+	if uptime := now.Sub(s.start).Seconds(); uptime < elapsed {
+		elapsed = uptime
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summaries := []routeSummary{}
+	for name, ring := range s.routes {
+		total := statsSlot{latency: make([]uint32, len(statsLatencyBounds)+1)}
+		for _, slot := range ring {
+			if slot.latency == nil || slot.minute < oldest || slot.minute > current {
+				continue
+			}
+			total.requests += slot.requests
+			total.errors += slot.errors
+			total.clientErrors += slot.clientErrors
+			total.sumMs += slot.sumMs
+			total.maxMs = math.Max(total.maxMs, slot.maxMs)
+			for i, n := range slot.latency {
+				total.latency[i] += n
+			}
+		}
+		if total.requests == 0 {
+			continue
+		}
+		sum := routeSummary{
+			Route:        name,
+			Requests:     total.requests,
+			ErrorRate:    round3(float64(total.errors) / float64(total.requests)),
+			ClientErrors: total.clientErrors,
+			MeanMs:       round3(total.sumMs / float64(total.requests)),
+			P50Ms:        total.percentile(0.50),
+			P95Ms:        total.percentile(0.95),
+			P99Ms:        total.percentile(0.99),
+			MaxMs:        round3(total.maxMs),
+		}
+		if elapsed > 0 {
+			sum.RPS = round3(float64(total.requests) / elapsed)
+		}
+		summaries = append(summaries, sum)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Route < summaries[j].Route })
+	return summaries
+}
+
+// percentile 按分桶估算延迟百分位，取所在桶的上限，不超过最大延迟
+func (s *statsSlot) percentile(q float64) float64 {
+	target := uint64(math.Ceil(q * float64(s.requests)))
+	var seen uint64
+	for i, n := range s.latency {
+		seen += uint64(n)
+		if seen >= target && i < len(statsLatencyBounds) {
+			return round3(math.Min(statsLatencyBounds[i], s.maxMs))
+		}
+	}
+	return round3(s.maxMs)
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// logSummaries 每隔interval为每条有请求的路由输出一条汇总日志
+func (s *routeStats) logSummaries(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, sum := range s.summarize(interval) {
+			logger.WithFields(logrus.Fields{
+				"route":         sum.Route,
+				"requests":      sum.Requests,
+				"rps":           sum.RPS,
+				"error_rate":    sum.ErrorRate,
+				"client_errors": sum.ClientErrors,
+				"p50_ms":        sum.P50Ms,
+				"p95_ms":        sum.P95Ms,
+				"p99_ms":        sum.P99Ms,
+				"max_ms":        sum.MaxMs,
+			}).Infof("Route %s in the last %s: %d requests, %.3g req/s, p50 %.fms, p95 %.fms, p99 %.fms, %.2f%% errors",
+				sum.Route, interval, sum.Requests, sum.RPS, sum.P50Ms, sum.P95Ms, sum.P99Ms, sum.ErrorRate*100)
+		}
+	}
+}
+
+// newStatsHandler 创建查看路由统计的管理接口（GET /admin/stats?window=5m），window最长为统计保留的时长
+func newStatsHandler(token string, s *routeStats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkAdminRequest(w, r, token, http.MethodGet) {
+			return
+		}
+		window := defaultStatsWindow
+		if v := r.FormValue("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "window must be a positive duration such as 5m"})
+				return
+			}
+			window = d
+		}
+		if max := time.Duration(s.slots) * statsSlotSeconds * time.Second; window > max {
+			window = max
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"window": window.String(), "routes": s.summarize(window)})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// scriptTimeout 单次执行脚本函数的最长时间，超时后中止脚本并返回500
+const scriptTimeout = 100 * time.Millisecond
+
+// routeScript 路由的Lua脚本。脚本可定义on_request(req)和on_response(resp)两个函数：
+// on_request在认证之后、选择后端之前执行，可以读取和修改请求头、直接返回响应或指定后端；
+// on_response在响应返回给客户端之前执行，可以修改状态码和响应头。
+// 脚本只编译一次，每个并发请求使用池中的独立Lua状态，全局变量不在请求之间共享
+type routeScript struct {
+	name        string
+	proto       *lua.FunctionProto
+	hasRequest  bool
+	hasResponse bool
+	states      sync.Pool
+}
+
+// scriptResult on_request的执行结果
+type scriptResult struct {
+	respond bool // 脚本调用了req:respond，直接返回响应不转发
+	status  int
+	body    string
+	header  http.Header
+	backend string // 脚本通过req:set_backend指定的后端地址
+}
+
+// newRouteScript 编译脚本，source为空时读取file
+func newRouteScript(source, file string) (*routeScript, error) {
+	name := "inline"
+	if file != "" {
+		if source != "" {
+			return nil, fmt.Errorf("script and script_file cannot be used together")
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		source, name = string(data), file
+	}
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, err
+	}
+	s := &routeScript{name: name, proto: proto}
+	L, err := s.newState()
+	if err != nil {
+		return nil, err
+	}
+	_, s.hasRequest = L.GetGlobal("on_request").(*lua.LFunction)
+	_, s.hasResponse = L.GetGlobal("on_response").(*lua.LFunction)
+	L.Close()
+	if !s.hasRequest && !s.hasResponse {
+		return nil, fmt.Errorf("%s: script must define on_request or on_response", name)
+	}
+	return s, nil
+}
+
+// newState 创建只加载base、string、table和math库的Lua状态并执行脚本的顶层代码，脚本不能访问文件和进程
+func (s *routeScript) newState() (*lua.LState, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{{lua.BaseLibName, lua.OpenBase}, {lua.TabLibName, lua.OpenTable}, {lua.StringLibName, lua.OpenString}, {lua.MathLibName, lua.OpenMath}} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	L.Push(L.NewFunctionFromProto(s.proto))
+	err := L.PCall(0, lua.MultRet, nil)
+	L.RemoveContext()
+	if err != nil {
+		L.Close()
+		return nil, err
+	}
+	return L, nil
+}
+
+// call 在池中的Lua状态上执行函数，出错的状态不再放回池中
+func (s *routeScript) call(ctx context.Context, fn string, build func(L *lua.LState) lua.LValue) error {
+	L, _ := s.states.Get().(*lua.LState)
+	if L == nil {
+		var err error
+		if L, err = s.newState(); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	err := L.CallByParam(lua.P{Fn: L.GetGlobal(fn), NRet: 0, Protect: true}, build(L))
+	L.RemoveContext()
+	if err != nil {
+		L.Close()
+		return fmt.Errorf("%s: %s: %w", s.name, fn, err)
+	}
+	s.states.Put(L)
+	return nil
+}
+
+// headerFuncs 返回读写头集合的header、set_header、add_header和del_header方法
+func headerFuncs(h http.Header) map[string]lua.LGFunction {
+	return map[string]lua.LGFunction{
+		"header": func(L *lua.LState) int {
+			L.Push(lua.LString(h.Get(L.CheckString(2))))
+			return 1
+		},
+		"set_header": func(L *lua.LState) int {
+			h.Set(L.CheckString(2), L.CheckString(3))
+			return 0
+		},
+		"add_header": func(L *lua.LState) int {
+			h.Add(L.CheckString(2), L.CheckString(3))
+			return 0
+		},
+		"del_header": func(L *lua.LState) int {
+			h.Del(L.CheckString(2))
+			return 0
+		},
+	}
+}
+
+// onRequest 执行on_request，脚本未定义该函数时返回空结果
+func (s *routeScript) onRequest(r *http.Request, info *requestInfo) (*scriptResult, error) {
+	result := &scriptResult{}
+	if !s.hasRequest {
+		return result, nil
+	}
+	err := s.call(r.Context(), "on_request", func(L *lua.LState) lua.LValue {
+		req := L.NewTable()
+		L.SetField(req, "method", lua.LString(r.Method))
+		L.SetField(req, "path", lua.LString(r.URL.Path))
+		L.SetField(req, "query", lua.LString(r.URL.RawQuery))
+		L.SetField(req, "host", lua.LString(r.Host))
+		L.SetField(req, "client_ip", lua.LString(clientIP(r)))
+		L.SetField(req, "route", lua.LString(info.route.name()))
+		funcs := headerFuncs(r.Header)
+		funcs["query_param"] = func(L *lua.LState) int {
+			L.Push(lua.LString(r.URL.Query().Get(L.CheckString(2))))
+			return 1
+		}
+		funcs["cookie"] = func(L *lua.LState) int {
+			value := ""
+			if c, err := r.Cookie(L.CheckString(2)); err == nil {
+				value = c.Value
+			}
+			L.Push(lua.LString(value))
+			return 1
+		}
+		funcs["respond"] = func(L *lua.LState) int {
+			result.respond = true
+			result.status = L.CheckInt(2)
+			result.body = L.OptString(3, "")
+			result.header = http.Header{}
+			if t, ok := L.Get(4).(*lua.LTable); ok {
+				t.ForEach(func(k, v lua.LValue) {
+					result.header.Set(k.String(), v.String())
+				})
+			}
+			if result.status < 100 || result.status > 999 {
+				L.ArgError(2, "invalid status code")
+			}
+			return 0
+		}
+		funcs["set_backend"] = func(L *lua.LState) int {
+			result.backend = L.CheckString(2)
+			return 0
+		}
+		funcs["log"] = func(L *lua.LState) int {
+			info.log.Infof("Script: %s", L.CheckString(2))
+			return 0
+		}
+		L.SetFuncs(req, funcs)
+		return req
+	})
+	return result, err
+}
+
+// onResponse 执行on_response，脚本可修改状态码和响应头
+func (s *routeScript) onResponse(resp *http.Response) error {
+	if !s.hasResponse {
+		return nil
+	}
+	info := getRequestInfo(resp.Request)
+	return s.call(resp.Request.Context(), "on_response", func(L *lua.LState) lua.LValue {
+		t := L.NewTable()
+		L.SetField(t, "status", lua.LNumber(resp.StatusCode))
+		L.SetField(t, "path", lua.LString(resp.Request.URL.Path))
+		funcs := headerFuncs(resp.Header)
+		funcs["set_status"] = func(L *lua.LState) int {
+			code := L.CheckInt(2)
+			if code < 100 || code > 999 {
+				L.ArgError(2, "invalid status code")
+			}
+			resp.StatusCode, resp.Status = code, fmt.Sprintf("%d %s", code, http.StatusText(code))
+			return 0
+		}
+		funcs["log"] = func(L *lua.LState) int {
+			info.log.Infof("Script: %s", L.CheckString(2))
+			return 0
+		}
+		L.SetFuncs(t, funcs)
+		return t
+	})
+}
+
+// scriptBackend 在路由的后端和金丝雀后端中查找脚本指定的后端，返回其所属的选择器
+func scriptBackend(rt *route, target string) (*backendSelector, *url.URL, error) {
+	if !strings.HasSuffix(target, "/") {
+		target += "/"
+	}
+	selectors := []*backendSelector{rt.selector}
+	if rt.canary != nil {
+		selectors = append(selectors, rt.canary.selector)
+	}
+	for _, s := range selectors {
+		for _, b := range s.backends {
+			if b.String() == target {
+				return s, b, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("backend %s is not configured for route %s", target, rt.name())
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// securityHeadersConfig 路由配置中的安全响应头设置，未写出的字段使用-security-headers等参数，
+// 取值为空字符串时不设置该头
+type securityHeadersConfig struct {
+	Enabled            *bool   `json:"enabled,omitempty" yaml:"enabled"`                           // 是否注入安全响应头
+	HSTS               *string `json:"hsts,omitempty" yaml:"hsts"`                                 // Strict-Transport-Security，只在HTTPS响应中设置
+	ContentTypeOptions *string `json:"content_type_options,omitempty" yaml:"content_type_options"` // X-Content-Type-Options
+	FrameOptions       *string `json:"frame_options,omitempty" yaml:"frame_options"`               // X-Frame-Options
+	CSP                *string `json:"csp,omitempty" yaml:"csp"`                                   // Content-Security-Policy
+}
+
+// merge 返回用override中已设置的字段覆盖后的配置
+func (c securityHeadersConfig) merge(override securityHeadersConfig) securityHeadersConfig {
+	if override.Enabled != nil {
+		c.Enabled = override.Enabled
+	}
+	if override.HSTS != nil {
+		c.HSTS = override.HSTS
+	}
+	if override.ContentTypeOptions != nil {
+		c.ContentTypeOptions = override.ContentTypeOptions
+	}
+	if override.FrameOptions != nil {
+		c.FrameOptions = override.FrameOptions
+	}
+	if override.CSP != nil {
+		c.CSP = override.CSP
+	}
+	return c
+}
+
+// defaultSecurityHeadersConfig 由-security-headers等参数得到的安全响应头设置
+var defaultSecurityHeadersConfig securityHeadersConfig
+
+// securityHeaders 一条路由注入的安全响应头
+type securityHeaders struct {
+	hsts    string        // 只在HTTPS响应中设置，为空时不设置
+	headers []headerValue // 其余响应头
+}
+
+// newSecurityHeaders 校验安全响应头设置，未启用或没有要设置的头时返回nil
+func newSecurityHeaders(c securityHeadersConfig) (*securityHeaders, error) {
+	if c.Enabled == nil || !*c.Enabled {
+		return nil, nil
+	}
+	value := func(p *string) string {
+		if p == nil {
+			return ""
+		}
+		return strings.TrimSpace(*p)
+	}
+	s := &securityHeaders{hsts: value(c.HSTS)}
+	if strings.ContainsAny(s.hsts, "\r\n") {
+		return nil, fmt.Errorf("hsts: value must not contain line breaks")
+	}
+	for _, h := range []headerValue{
+		{name: "X-Content-Type-Options", value: value(c.ContentTypeOptions)},
+		{name: "X-Frame-Options", value: value(c.FrameOptions)},
+		{name: "Content-Security-Policy", value: value(c.CSP)},
+	} {
+		if strings.ContainsAny(h.value, "\r\n") {
+			return nil, fmt.Errorf("%s: value must not contain line breaks", h.name)
+		}
+		if h.value != "" {
+			s.headers = append(s.headers, h)
+		}
+	}
+	if s.hsts == "" && len(s.headers) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// apply 在响应头中设置安全头，HSTS只在客户端通过HTTPS访问时设置
+func (s *securityHeaders) apply(h http.Header, https bool) {
+	if https && s.hsts != "" {
+		h.Set("Strict-Transport-Security", s.hsts)
+	}
+	for _, header := range s.headers {
+		h.Set(header.name, header.value)
+	}
+}
+
+// strip 移除后端返回的同名头，以代理设置的值为准，避免客户端收到两个取值
+func (s *securityHeaders) strip(h http.Header) {
+	if s.hsts != "" {
+		h.Del("Strict-Transport-Security")
+	}
+	for _, header := range s.headers {
+		h.Del(header.name)
+	}
+}
+
+// parseMethods 规范化方法白名单，允许GET时同时允许HEAD；为空时返回nil，表示不限制方法
+func parseMethods(methods []string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	add := func(m string) {
+		if !seen[m] {
+			seen[m] = true
+			out = append(out, m)
+		}
+	}
+	for _, m := range methods {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		if m == "" {
+			continue
+		}
+		if strings.ContainsAny(m, " \t\r\n,;:/") {
+			return nil, fmt.Errorf("invalid method %q", m)
+		}
+		add(m)
+		if m == http.MethodGet {
+			add(http.MethodHead)
+		}
+	}
+	return out, nil
+}
+
+// methodAllowed 方法在白名单中或未配置白名单时返回true
+func methodAllowed(methods []string, method string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify 向systemd发送状态通知（如READY=1、STOPPING=1），未由systemd以Type=notify启动时忽略
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval 返回systemd要求的看门狗间隔，未启用WatchdogSec或通知对象不是本进程时返回0
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runSdWatchdog 按看门狗间隔的一半向systemd报告存活，进程卡死时systemd按WatchdogSec重启服务
+func runSdWatchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			logger.Warnf("Failed to notify systemd watchdog: %v", err)
+		}
+	}
+}
+
+// writePIDFile 写入PID文件；文件已存在且其中的进程仍在运行时返回错误，进程已退出时覆盖。
+// 升级启动时文件中为即将退出的上一个进程，直接覆盖
+func writePIDFile(path string) error {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() && pid != sockets.parent && processAlive(pid) {
+			return fmt.Errorf("%s: proxy is already running with pid %d", path, pid)
+		}
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePIDFile 退出时删除PID文件，文件已被其他进程改写时保留
+func removePIDFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		logger.Warnf("Failed to remove pid file %s: %v", path, err)
+	}
+}
+
+// stripFlag 从命令行参数中去掉指定参数，hasValue为true时同时去掉以空格分隔的取值；
+// 用于以后台进程或系统服务重新启动自身时去掉-daemon和-service
+func stripFlag(args []string, name string, hasValue bool) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		trimmed := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if trimmed == arg {
+			out = append(out, arg)
+			continue
+		}
+		if trimmed == name {
+			if hasValue {
+				i++
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, name+"=") {
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
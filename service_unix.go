@@ -0,0 +1,163 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// daemonStartTimeout 后台进程报告就绪的最长等待时间
+const daemonStartTimeout = time.Minute
+
+// processAlive 判断进程是否仍在运行
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// daemonize 以后台进程重新启动自身并等待其就绪：子进程脱离终端和会话，标准输入输出重定向到/dev/null，
+// 开始监听后通过临时的通知套接字报告READY=1，父进程随后退出；子进程在就绪前退出时返回错误
+func daemonize() (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	dir, err := os.MkdirTemp("", "st_proxy-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(exe, stripFlag(os.Args[1:], "daemon", false)...)
+	cmd.Env = append(os.Environ(), envName("daemon")+"=false", "NOTIFY_SOCKET="+socket)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = devNull, devNull, devNull
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	ready := make(chan struct{})
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if strings.Contains("\n"+string(buf[:n])+"\n", "\nREADY=1\n") {
+				close(ready)
+				return
+			}
+		}
+	}()
+	select {
+	case <-ready:
+		return cmd.Process.Pid, nil
+	case err := <-exited:
+		return 0, fmt.Errorf("background process exited before becoming ready (%v), see the log for details", err)
+	case <-time.After(daemonStartTimeout):
+		return cmd.Process.Pid, fmt.Errorf("background process %d did not become ready within %s", cmd.Process.Pid, daemonStartTimeout)
+	}
+}
+
+// upgradeReadyTimeout 升级时等待新进程就绪的最长时间
+const upgradeReadyTimeout = time.Minute
+
+// notifyUpgradeSignal 收到SIGUSR2时触发升级
+func notifyUpgradeSignal(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// startUpgrade 以磁盘上当前的可执行文件和相同的参数启动新进程，并传递全部监听套接字；
+// 新进程开始服务后通过管道报告就绪，返回其PID。新进程在就绪前退出或超时时返回错误，当前进程继续服务
+func startUpgrade() (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	keys, files, err := sockets.files()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer readyR.Close()
+
+	// 新进程不是systemd启动的进程，去掉WATCHDOG_PID使其在成为主进程后继续报告看门狗
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if name != inheritedFDsEnv && name != upgradeReadyFDEnv && name != "WATCHDOG_PID" {
+			env = append(env, kv)
+		}
+	}
+	// ExtraFiles中的文件在新进程中从3开始编号：先是就绪管道，然后是各套接字
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(env, upgradeReadyFDEnv+"=3", inheritedEnv(keys, 4))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append([]*os.File{readyW}, files...)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	ready := make(chan struct{})
+	go func() {
+		data, _ := io.ReadAll(readyR)
+		if strings.Contains(string(data), "READY=1") {
+			close(ready)
+		}
+	}()
+	select {
+	case <-ready:
+		return cmd.Process.Pid, nil
+	case err := <-exited:
+		return 0, fmt.Errorf("new process exited before becoming ready (%v), see the log for details", err)
+	case <-time.After(upgradeReadyTimeout):
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("new process %d did not become ready within %s and was killed", cmd.Process.Pid, upgradeReadyTimeout)
+	}
+}
+
+// startService 只在Windows上以系统服务运行，其他平台由systemd等进程管理器直接启动
+func startService(stop func()) (finish func()) {
+	return func() {}
+}
+
+// controlService 其他平台不支持-service，使用systemd时参考README中的unit文件
+func controlService(command, name string) error {
+	return fmt.Errorf("-service is only supported on Windows, use a systemd unit on this platform")
+}
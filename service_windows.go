@@ -0,0 +1,147 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// stillActive GetExitCodeProcess对仍在运行的进程返回的退出码
+const stillActive = 259
+
+// processAlive 判断进程是否仍在运行
+func processAlive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	return windows.GetExitCodeProcess(h, &code) == nil && code == stillActive
+}
+
+// daemonize Windows上没有后台进程模式，使用-service install安装为系统服务
+func daemonize() (int, error) {
+	return 0, fmt.Errorf("-daemon is not supported on Windows, use -service install")
+}
+
+// notifyUpgradeSignal Windows上没有用于触发升级的信号
+func notifyUpgradeSignal(c chan<- os.Signal) {}
+
+// startUpgrade Windows上不能向新进程传递监听套接字，升级时需要重启服务
+func startUpgrade() (int, error) {
+	return 0, fmt.Errorf("binary upgrade is not supported on Windows, restart the service instead")
+}
+
+// windowsService 将服务管理器的停止和关机请求转为优雅关闭
+type windowsService struct {
+	stop func()
+	done chan struct{}
+}
+
+func (s *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				logger.Info("Windows service stop requested")
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32((shutdownTimeout + 10*time.Second) / time.Millisecond)}
+				s.stop()
+				<-s.done
+				return false, 0
+			}
+		case <-s.done:
+			return false, 0
+		}
+	}
+}
+
+// startService 由服务管理器启动时连接服务管理器，停止请求调用stop；
+// 返回的finish在代理退出前调用，向服务管理器报告服务已停止。不是以服务启动时不做任何事
+func startService(stop func()) (finish func()) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return func() {}
+	}
+	s := &windowsService{stop: stop, done: make(chan struct{})}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := svc.Run(serviceName, s); err != nil {
+			logger.Errorf("Windows service failed: %v", err)
+		}
+	}()
+	logger.Infof("Running as Windows service %s", serviceName)
+	return func() {
+		close(s.done)
+		<-stopped
+	}
+}
+
+// controlService 安装、卸载、启动或停止Windows服务；安装时以当前的命令行参数（去掉-service）作为服务的启动参数，
+// 进程异常退出时由服务管理器在5秒后重启
+func controlService(command, name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if command == "install" {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		if exe, err = filepath.Abs(exe); err != nil {
+			return err
+		}
+		if s, err := m.OpenService(name); err == nil {
+			s.Close()
+			return fmt.Errorf("service %s already exists", name)
+		}
+		s, err := m.CreateService(name, exe, mgr.Config{
+			DisplayName: name,
+			Description: "st_proxy API reverse proxy",
+			StartType:   mgr.StartAutomatic,
+		}, stripFlag(os.Args[1:], "service", true)...)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		if err := s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}, 86400); err != nil {
+			logger.Warnf("Failed to set recovery actions for service %s: %v", name, err)
+		}
+		logger.Infof("Service %s installed", name)
+		return nil
+	}
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", name, err)
+	}
+	defer s.Close()
+	switch command {
+	case "uninstall":
+		err = s.Delete()
+	case "start":
+		err = s.Start()
+	case "stop":
+		_, err = s.Control(svc.Stop)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Infof("Service %s: %s done", name, command)
+	return nil
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signingHMAC  = "hmac"
+	signingSigV4 = "aws-sigv4"
+
+	// unsignedPayload 不对请求体签名时代替请求体哈希的值，与AWS的约定相同
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// defaultSigningMaxBody 计算请求体哈希时最多缓冲的字节数
+	defaultSigningMaxBody = 10 << 20
+)
+
+// signingConfig 路由配置中的出站请求签名设置，代理用配置的凭据为转发给后端的请求签名，
+// 使不带凭据的内部客户端可以访问要求签名的后端接口
+type signingConfig struct {
+	Type            string   `json:"type" yaml:"type"`                                     // hmac 或 aws-sigv4
+	KeyID           string   `json:"key_id,omitempty" yaml:"key_id"`                       // hmac：随签名发送的密钥ID，为空时不发送
+	Secret          string   `json:"secret,omitempty" yaml:"secret"`                       // hmac：签名密钥
+	SecretEnv       string   `json:"secret_env,omitempty" yaml:"secret_env"`               // hmac：从该环境变量读取签名密钥，避免写在配置文件中
+	Algorithm       string   `json:"algorithm,omitempty" yaml:"algorithm"`                 // hmac：sha256（默认）、sha512 或 sha1
+	Encoding        string   `json:"encoding,omitempty" yaml:"encoding"`                   // hmac：签名的编码，hex（默认）或 base64
+	Header          string   `json:"header,omitempty" yaml:"header"`                       // hmac：签名请求头，默认X-Signature
+	TimestampHeader string   `json:"timestamp_header,omitempty" yaml:"timestamp_header"`   // hmac：时间戳（Unix秒）请求头，默认X-Signature-Timestamp
+	KeyIDHeader     string   `json:"key_id_header,omitempty" yaml:"key_id_header"`         // hmac：密钥ID请求头，默认X-Signature-Key-Id
+	Region          string   `json:"region,omitempty" yaml:"region"`                       // aws-sigv4：区域，如us-east-1
+	Service         string   `json:"service,omitempty" yaml:"service"`                     // aws-sigv4：服务名，如s3、execute-api
+	AccessKeyID     string   `json:"access_key_id,omitempty" yaml:"access_key_id"`         // aws-sigv4：为空时读取环境变量AWS_ACCESS_KEY_ID
+	SecretAccessKey string   `json:"secret_access_key,omitempty" yaml:"secret_access_key"` // aws-sigv4：为空时读取环境变量AWS_SECRET_ACCESS_KEY
+	SessionToken    string   `json:"session_token,omitempty" yaml:"session_token"`         // aws-sigv4：临时凭据的令牌，为空时读取环境变量AWS_SESSION_TOKEN
+	Headers         []string `json:"headers,omitempty" yaml:"headers"`                     // 额外参与签名的请求头
+	UnsignedPayload bool     `json:"unsigned_payload,omitempty" yaml:"unsigned_payload"`   // 不对请求体签名，用于大文件或流式上传
+	MaxBodyBytes    int64    `json:"max_body_bytes,omitempty" yaml:"max_body_bytes"`       // 计算请求体哈希时最多缓冲的字节数，默认10MiB，超过时请求失败
+}
+
+// requestSigner 一条路由的出站请求签名
+type requestSigner struct {
+	kind     string
+	headers  []string // 额外参与签名的请求头，小写
+	unsigned bool
+	maxBody  int64
+
+	// hmac
+	keyID           string
+	secret          []byte
+	hash            func() hash.Hash
+	base64          bool
+	header          string
+	timestampHeader string
+	keyIDHeader     string
+
+	// aws-sigv4
+	region       string
+	service      string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// newRequestSigner 检查签名设置并读取凭据
+func newRequestSigner(c signingConfig) (*requestSigner, error) {
+	s := &requestSigner{kind: c.Type, unsigned: c.UnsignedPayload, maxBody: c.MaxBodyBytes}
+	if s.maxBody == 0 {
+		s.maxBody = defaultSigningMaxBody
+	}
+	if s.maxBody < 0 {
+		return nil, fmt.Errorf("max_body_bytes must not be negative")
+	}
+	for _, h := range c.Headers {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" || strings.ContainsAny(h, " \t\r\n:") {
+			return nil, fmt.Errorf("invalid header %q", h)
+		}
+		s.headers = append(s.headers, h)
+	}
+
+	switch c.Type {
+	case signingHMAC:
+		secret := c.Secret
+		if c.SecretEnv != "" {
+			secret = os.Getenv(c.SecretEnv)
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("hmac signing requires secret or a non-empty secret_env")
+		}
+		s.secret, s.keyID = []byte(secret), c.KeyID
+		switch strings.ToLower(c.Algorithm) {
+		case "", "sha256":
+			s.hash = sha256.New
+		case "sha512":
+			s.hash = sha512.New
+		case "sha1":
+			s.hash = sha1.New
+		default:
+			return nil, fmt.Errorf("unknown algorithm %q, expected sha256, sha512 or sha1", c.Algorithm)
+		}
+		switch c.Encoding {
+		case "", "hex":
+		case "base64":
+			s.base64 = true
+		default:
+			return nil, fmt.Errorf("unknown encoding %q, expected hex or base64", c.Encoding)
+		}
+		s.header = headerOrDefault(c.Header, "X-Signature")
+		s.timestampHeader = headerOrDefault(c.TimestampHeader, "X-Signature-Timestamp")
+		s.keyIDHeader = headerOrDefault(c.KeyIDHeader, "X-Signature-Key-Id")
+	case signingSigV4:
+		s.region, s.service = c.Region, c.Service
+		if s.region == "" || s.service == "" {
+			return nil, fmt.Errorf("aws-sigv4 signing requires region and service")
+		}
+		s.accessKey, s.secretKey, s.sessionToken = c.AccessKeyID, c.SecretAccessKey, c.SessionToken
+		if s.accessKey == "" && s.secretKey == "" {
+			s.accessKey, s.secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+			if s.sessionToken == "" {
+				s.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+			}
+		}
+		if s.accessKey == "" || s.secretKey == "" {
+			return nil, fmt.Errorf("aws-sigv4 signing requires access_key_id and secret_access_key or the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q, expected hmac or aws-sigv4", c.Type)
+	}
+	return s, nil
+}
+
+func headerOrDefault(name, def string) string {
+	if name = strings.TrimSpace(name); name != "" {
+		return http.CanonicalHeaderKey(name)
+	}
+	return def
+}
+
+// signingTransport 为路由配置了签名的请求签名后再发给后端，放在重试之内，每次尝试使用新的时间戳
+type signingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := getRequestInfo(req).route
+	if rt == nil || rt.signer == nil {
+		return t.base.RoundTrip(req)
+	}
+	signed, err := rt.signer.sign(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(signed)
+}
+
+// sign 返回带签名请求头的请求副本，需要请求体哈希时读出请求体并放回副本
+func (s *requestSigner) sign(req *http.Request) (*http.Request, error) {
+	out := req.Clone(req.Context())
+	payloadHash := unsignedPayload
+	if !s.unsigned {
+		var data []byte
+		if req.Body != nil && req.Body != http.NoBody {
+			var err error
+			data, err = io.ReadAll(io.LimitReader(req.Body, s.maxBody+1))
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(data)) > s.maxBody {
+				return nil, fmt.Errorf("request body exceeds %d bytes and cannot be signed, set unsigned_payload or raise max_body_bytes", s.maxBody)
+			}
+			out.Body = io.NopCloser(bytes.NewReader(data))
+			out.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(data)), nil
+			}
+		}
+		sum := sha256.Sum256(data)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	if s.kind == signingSigV4 {
+		s.signSigV4(out, payloadHash)
+	} else {
+		s.signHMAC(out, payloadHash)
+	}
+	return out, nil
+}
+
+// signHMAC 对以下各行（以\n连接）计算HMAC：方法、路径和查询参数、时间戳、请求体的SHA-256（十六进制），
+// 以及headers中每个请求头的 小写名称:取值
+func (s *requestSigner) signHMAC(req *http.Request, payloadHash string) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	lines := []string{req.Method, req.URL.RequestURI(), timestamp, payloadHash}
+	for _, h := range s.headers {
+		lines = append(lines, h+":"+canonicalHeaderValue(req, h))
+	}
+	mac := hmac.New(s.hash, s.secret)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	sum := mac.Sum(nil)
+	signature := hex.EncodeToString(sum)
+	if s.base64 {
+		signature = base64.StdEncoding.EncodeToString(sum)
+	}
+	req.Header.Set(s.timestampHeader, timestamp)
+	req.Header.Set(s.header, signature)
+	if s.keyID != "" {
+		req.Header.Set(s.keyIDHeader, s.keyID)
+	}
+}
+
+// signSigV4 按AWS Signature Version 4设置Authorization、X-Amz-Date等请求头
+func (s *requestSigner) signSigV4(req *http.Request, payloadHash string) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := strings.Join([]string{now.Format("20060102"), s.region, s.service, "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Del("Authorization")
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	} else {
+		req.Header.Del("X-Amz-Security-Token")
+	}
+
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if s.sessionToken != "" {
+		names = append(names, "x-amz-security-token")
+	}
+	for _, h := range s.headers {
+		if h != "host" && h != "authorization" && !strings.HasPrefix(h, "x-amz-") {
+			names = append(names, h)
+		}
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, h := range names {
+		canonicalHeaders.WriteString(h + ":" + canonicalHeaderValue(req, h) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// 除S3外，路径中的每段需要编码两次
+	path := awsEscapePath(req.URL.Path)
+	if s.service != "s3" {
+		path = awsEscapePath(path)
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		awsCanonicalQuery(req.URL.RawQuery),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{now.Format("20060102"), s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalHeaderValue 返回参与签名的请求头取值：多个取值以逗号连接，去掉首尾空白并合并连续空格；
+// host取实际发送的Host
+func canonicalHeaderValue(req *http.Request, name string) string {
+	if name == "host" {
+		if req.Host != "" {
+			return req.Host
+		}
+		return req.URL.Host
+	}
+	var values []string
+	for _, v := range req.Header.Values(name) {
+		values = append(values, strings.Join(strings.Fields(v), " "))
+	}
+	return strings.Join(values, ",")
+}
+
+// awsEscape 按AWS的规则编码：除A-Z、a-z、0-9和-_.~外全部百分号编码
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsEscapePath 逐段编码路径，保留斜杠
+func awsEscapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		segments[i] = awsEscape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// awsCanonicalQuery 按参数名和取值排序并重新编码查询参数
+func awsCanonicalQuery(rawQuery string) string {
+	values, _ := url.ParseQuery(rawQuery)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return awsEscape(keys[i]) < awsEscape(keys[j]) })
+	var pairs []string
+	for _, k := range keys {
+		vs := make([]string, len(values[k]))
+		for i, v := range values[k] {
+			vs[i] = awsEscape(v)
+		}
+		sort.Strings(vs)
+		for _, v := range vs {
+			pairs = append(pairs, awsEscape(k)+"="+v)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// staticSite 从本地目录提供未匹配任何路由的请求，用于与API同端口托管前端。
+// 启用fallback时，不存在且不带扩展名的路径返回fallback页面，由单页应用的前端路由处理
+type staticSite struct {
+	root     http.Dir
+	fallback string // 回退页面相对于根目录的路径，如index.html，为空时不回退
+}
+
+// newStaticSite 检查目录和回退页面是否存在
+func newStaticSite(dir, fallback string) (*staticSite, error) {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, errors.New(dir + " is not a directory")
+	}
+	s := &staticSite{root: http.Dir(dir), fallback: strings.TrimPrefix(fallback, "/")}
+	if s.fallback != "" {
+		if _, err := os.Stat(path.Join(dir, s.fallback)); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ServeHTTP 只接受GET和HEAD，以.开头的文件和目录不对外提供，目录只返回其中的index.html，不列出文件
+func (s *staticSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := path.Clean("/" + r.URL.Path)
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	if s.serveFile(w, r, name) {
+		return
+	}
+	if s.fallback != "" && path.Ext(name) == "" {
+		// 回退页面随前端发布变化，要求浏览器每次确认
+		w.Header().Set("Cache-Control", "no-cache")
+		if s.serveFile(w, r, "/"+s.fallback) {
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// serveFile 返回文件或目录下的index.html，不存在时返回false
+func (s *staticSite) serveFile(w http.ResponseWriter, r *http.Request, name string) bool {
+	f, err := s.root.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	if st.IsDir() {
+		return s.serveFile(w, r, path.Join(name, "index.html"))
+	}
+	if !st.Mode().IsRegular() {
+		return false
+	}
+	if path.Ext(name) == ".html" {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, st.Name(), st.ModTime(), f)
+	return true
+}
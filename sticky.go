@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 会话保持方式
+const (
+	stickyCookie = "cookie" // 代理签发Cookie记录选中的后端
+	stickyIP     = "ip"     // 按客户端IP的一致性哈希选择后端
+)
+
+// stickyConfig 路由配置中的会话保持设置，未写出的字段使用-sticky-*参数
+type stickyConfig struct {
+	Mode   string `json:"mode,omitempty" yaml:"mode"`       // cookie、ip，或none关闭
+	Cookie string `json:"cookie,omitempty" yaml:"cookie"`   // cookie方式使用的Cookie名称
+	MaxAge string `json:"max_age,omitempty" yaml:"max_age"` // Cookie有效期，如24h，0表示浏览器关闭时失效
+}
+
+// merge 返回用override中已设置的字段覆盖后的配置
+func (c stickyConfig) merge(override stickyConfig) stickyConfig {
+	if override.Mode != "" {
+		c.Mode = override.Mode
+	}
+	if override.Cookie != "" {
+		c.Cookie = override.Cookie
+	}
+	if override.MaxAge != "" {
+		c.MaxAge = override.MaxAge
+	}
+	return c
+}
+
+// defaultStickyConfig 由-sticky-*参数得到的会话保持设置
+var defaultStickyConfig stickyConfig
+
+// stickySessions 一条路由生效的会话保持策略
+type stickySessions struct {
+	mode   string
+	cookie string
+	maxAge time.Duration
+}
+
+// newStickySessions 校验会话保持设置，未启用时返回nil
+func newStickySessions(c stickyConfig) (*stickySessions, error) {
+	s := &stickySessions{mode: strings.ToLower(c.Mode), cookie: c.Cookie}
+	switch s.mode {
+	case "", "none":
+		return nil, nil
+	case stickyCookie:
+		if s.cookie == "" || strings.ContainsAny(s.cookie, " \t\r\n;,=\"") {
+			return nil, fmt.Errorf("invalid cookie name %q", s.cookie)
+		}
+	case stickyIP:
+	default:
+		return nil, fmt.Errorf("unknown sticky mode %q, expected %s, %s or none", c.Mode, stickyCookie, stickyIP)
+	}
+	if c.MaxAge != "" {
+		d, err := time.ParseDuration(c.MaxAge)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid max_age %q", c.MaxAge)
+		}
+		s.maxAge = d
+	}
+	return s, nil
+}
+
+// stickyID 返回Cookie中标识后端的值，使用地址的摘要，不向客户端暴露后端地址；
+// 同一地址的值不随重新加载变化
+func stickyID(backend *url.URL) string {
+	sum := sha256.Sum256([]byte(backend.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// stickyBackend 返回请求的会话Cookie指向的健康后端，没有时返回-1。
+// 多条路由的Cookie同名时浏览器会一起发送，逐个比较
+func (s *backendSelector) stickyBackend(r *http.Request, healthy func(int) bool) int {
+	for _, c := range r.Cookies() {
+		if c.Name != s.sticky.cookie {
+			continue
+		}
+		for i, id := range s.stickyIDs {
+			if c.Value == id && healthy(i) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// persist 在cookie方式下将选中的后端写入响应的Cookie，并从转发给后端的请求中移除该Cookie
+func (s *backendSelector) persist(w http.ResponseWriter, r *http.Request, backend *url.URL, path string) {
+	if s.sticky == nil || s.sticky.mode != stickyCookie {
+		return
+	}
+	id := stickyID(backend)
+	current := false
+	var kept []string
+	for _, c := range r.Cookies() {
+		if c.Name == s.sticky.cookie {
+			current = current || c.Value == id
+			continue
+		}
+		kept = append(kept, c.Name+"="+c.Value)
+	}
+	if r.Header.Get("Cookie") != "" {
+		r.Header.Del("Cookie")
+		if len(kept) > 0 {
+			r.Header.Set("Cookie", strings.Join(kept, "; "))
+		}
+	}
+	if current {
+		return
+	}
+	cookie := &http.Cookie{
+		Name:     s.sticky.cookie,
+		Value:    id,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if s.sticky.maxAge > 0 {
+		cookie.MaxAge = int(s.sticky.maxAge / time.Second)
+	}
+	http.SetCookie(w, cookie)
+}
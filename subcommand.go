@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// 不启动服务器、只检查配置的子命令，写在其余参数之前，如 go_proxy check -config cfg.yaml
+const (
+	cmdCheck     = "check"      // 校验全部参数和路由配置并列出路由
+	cmdRouteTest = "route-test" // 显示给定请求会匹配的路由、后端和改写后的路径
+)
+
+// subcommand 命令行指定的子命令，为空时正常启动代理
+var subcommand string
+
+// takeSubcommand 从命令行参数中取出子命令，子命令只输出到标准输出，日志只在出错时写到标准错误
+func takeSubcommand() {
+	if len(os.Args) < 2 {
+		return
+	}
+	switch os.Args[1] {
+	case cmdCheck, cmdRouteTest:
+		subcommand = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+}
+
+// runSubcommand 按已构建的路由表执行子命令，返回进程退出码
+func runSubcommand(out io.Writer, table *routeTable, args []string) int {
+	switch subcommand {
+	case cmdCheck:
+		if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "check takes no arguments, got %q\n", args)
+			return 2
+		}
+		fmt.Fprintf(out, "Configuration OK: %d routes\n", len(table.routes))
+		for _, r := range table.routes {
+			fmt.Fprintf(out, "  %s* -> %s (%s)\n", r.name(), joinURLs(r.backends), r.selector.strategy)
+		}
+		return 0
+	case cmdRouteTest:
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "usage: go_proxy route-test [flags] [METHOD] PATH|URL ...")
+			return 2
+		}
+		method := "GET"
+		if isMethodToken(args[0]) && len(args) > 1 {
+			method, args = args[0], args[1:]
+		}
+		for i, target := range args {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			if err := routeTest(out, table, method, target); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", target, err)
+				return 1
+			}
+		}
+		return 0
+	}
+	return 0
+}
+
+// isMethodToken 判断参数是否为请求方法（全部为大写字母）
+func isMethodToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// routeTest 输出请求会匹配的路由和各后端上的目标URL，target为路径或带主机的URL
+func routeTest(out io.Writer, table *routeTable, method, target string) error {
+	if !strings.HasPrefix(target, "/") && !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	r, matched := table.match("", u.Host, u.Path)
+
+	fmt.Fprintf(out, "%s %s\n", method, target)
+	if matched {
+		fmt.Fprintf(out, "  route:     %s*\n", r.name())
+	} else {
+		fmt.Fprintf(out, "  route:     %s* (default route, no prefix matched, path is not stripped)\n", r.name())
+	}
+	if r.tenant != "" {
+		fmt.Fprintf(out, "  tenant:    %s\n", r.tenant)
+	}
+	if !methodAllowed(r.methods, method) {
+		fmt.Fprintf(out, "  result:    405 Method Not Allowed (allowed: %s)\n", strings.Join(r.methods, ", "))
+		return nil
+	}
+	stripped := r.strippedPath(u.Path, matched)
+	fmt.Fprintf(out, "  path:      %s -> /%s\n", u.Path, strings.TrimPrefix(stripped, "/"))
+	printTargets := func(label string, backends []*url.URL) {
+		for _, backend := range backends {
+			mapped := *u
+			mapped.Scheme, mapped.Host, mapped.User = backend.Scheme, backend.Host, nil
+			mapped.Path = backend.Path + strings.TrimPrefix(stripped, "/")
+			mapped.RawPath = ""
+			if r.query != nil {
+				r.query.apply(&mapped)
+			}
+			fmt.Fprintf(out, "  %-10s %s\n", label+":", mapped.String())
+		}
+	}
+	printTargets("backend", r.backends)
+	fmt.Fprintf(out, "  strategy:  %s\n", r.selector.strategy)
+	if r.canary != nil {
+		printTargets(fmt.Sprintf("canary %.3g%%", r.canary.percent), r.canary.backends)
+	}
+
+	var notes []string
+	if r.maintenance {
+		notes = append(notes, "maintenance mode (503)")
+	}
+	if r.acl != nil {
+		notes = append(notes, "ip acl")
+	}
+	if r.basicAuth != nil {
+		notes = append(notes, "basic auth")
+	}
+	if r.apiKey && r.keyStore(table) != nil {
+		notes = append(notes, "api key")
+	}
+	if r.jwt != nil {
+		notes = append(notes, "jwt")
+	}
+	if r.signer != nil {
+		notes = append(notes, r.signer.kind+" signing")
+	}
+	if r.rateLimit != nil {
+		notes = append(notes, "rate limit")
+	}
+	if r.cacheTTL > 0 {
+		notes = append(notes, "cache "+r.cacheTTL.String())
+	}
+	if r.openapi != nil {
+		notes = append(notes, "openapi validation")
+	}
+	if r.fault != nil {
+		notes = append(notes, "fault injection")
+	}
+	if r.mirror != nil {
+		notes = append(notes, "mirror")
+	}
+	if r.script != nil {
+		notes = append(notes, "lua script (may change backend or respond directly)")
+	}
+	if len(r.middleware) > 0 {
+		notes = append(notes, "middleware")
+	}
+	if len(notes) > 0 {
+		fmt.Fprintf(out, "  policies:  %s\n", strings.Join(notes, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tenantConfig 配置文件中的一个租户：独立的URL前缀或子域名、后端、限流和凭据，
+// 多个团队共用一个代理进程。租户的路由展开为普通路由，访问日志、指标和路由名称中带有租户
+type tenantConfig struct {
+	Name      string           `json:"name" yaml:"name"`                       // 租户名称，用于日志、指标和API Key名称
+	Prefix    string           `json:"prefix,omitempty" yaml:"prefix"`         // 租户的URL前缀，租户路由的前缀拼接在其后
+	Host      string           `json:"host,omitempty" yaml:"host"`             // 租户的域名，逗号分隔，支持*.example.com通配；与prefix至少设置一个
+	RateLimit *rateLimitConfig `json:"rate_limit,omitempty" yaml:"rate_limit"` // 租户路由的限流，per为tenant时租户的全部路由共用一个令牌桶
+	BasicAuth *basicAuthConfig `json:"basic_auth,omitempty" yaml:"basic_auth"` // 租户路由的Basic认证
+	JWT       *jwtConfig       `json:"jwt,omitempty" yaml:"jwt"`               // 租户路由的JWT校验
+	APIKeys   []apiKeyEntry    `json:"api_keys,omitempty" yaml:"api_keys"`     // 租户自己的API Key，设置后租户路由只接受这些Key
+	Headers   *routeHeaders    `json:"headers,omitempty" yaml:"headers"`       // 租户路由的请求头和响应头改写规则
+	Routes    []routeConfig    `json:"routes" yaml:"routes"`                   // 租户的路由，prefix相对于租户前缀，未设置上面各项的路由使用租户的设置
+}
+
+// tenantNamePattern 租户名称只能包含字母、数字、下划线和连字符
+var tenantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// expandTenants 校验租户并展开为路由配置：路由前缀拼接在租户前缀之后，主机取租户的域名，
+// 路由未设置的限流、认证和请求头改写使用租户的设置
+func expandTenants(tenants []tenantConfig) ([]routeConfig, error) {
+	var configs []routeConfig
+	seen := map[string]bool{}
+	for i := range tenants {
+		t := &tenants[i]
+		if !tenantNamePattern.MatchString(t.Name) {
+			return nil, fmt.Errorf("tenant %q: name must contain only letters, digits, '_' and '-'", t.Name)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("duplicate tenant %s", t.Name)
+		}
+		seen[t.Name] = true
+		if strings.TrimSpace(t.Prefix) == "" && strings.TrimSpace(t.Host) == "" {
+			return nil, fmt.Errorf("tenant %s: prefix or host is required", t.Name)
+		}
+		if len(t.Routes) == 0 {
+			return nil, fmt.Errorf("tenant %s: no routes", t.Name)
+		}
+		prefix := "/"
+		if strings.TrimSpace(t.Prefix) != "" {
+			prefix = normalizePrefix(strings.TrimSpace(t.Prefix))
+		}
+		for _, rc := range t.Routes {
+			if rc.Host != "" {
+				return nil, fmt.Errorf("tenant %s: route %s: host is set by the tenant", t.Name, rc.Prefix)
+			}
+			if strings.TrimSpace(rc.Backend) == "" {
+				return nil, fmt.Errorf("tenant %s: route %s: backend must not be empty", t.Name, rc.Prefix)
+			}
+			rc.Prefix = prefix + strings.TrimPrefix(normalizePrefix(strings.TrimSpace(rc.Prefix)), "/")
+			rc.Host = t.Host
+			if rc.RateLimit == nil {
+				rc.RateLimit = t.RateLimit
+			}
+			if rc.BasicAuth == nil {
+				rc.BasicAuth = t.BasicAuth
+			}
+			if rc.JWT == nil {
+				rc.JWT = t.JWT
+			}
+			if rc.Headers == nil {
+				rc.Headers = t.Headers
+			}
+			rc.tenant = t
+			configs = append(configs, rc)
+		}
+	}
+	return configs, nil
+}
+
+// newTenantKeys 创建租户自己的API Key，Key名称前加上租户名称，如team-a/ci，
+// 与全局Key和其他租户的Key分开限速和统计配额；租户没有Key时返回nil
+func newTenantKeys(t *tenantConfig) (*apiKeyStore, error) {
+	if len(t.APIKeys) == 0 {
+		return nil, nil
+	}
+	store := newAPIKeyStore()
+	for _, e := range t.APIKeys {
+		if e.Name != "" {
+			e.Name = t.Name + "/" + e.Name
+		}
+		if err := store.add(e); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// keyStore 返回校验路由请求使用的API Key，租户配置了Key时只接受该租户的Key
+func (r *route) keyStore(t *routeTable) *apiKeyStore {
+	if r.tenantKeys != nil {
+		return r.tenantKeys
+	}
+	return t.apiKeys
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// 超过阈值的类型，作为st_proxy_threshold_exceeded_total的kind标签
+const (
+	thresholdSlowRequest   = "slow_request"
+	thresholdLargeResponse = "large_response"
+)
+
+// reportThresholds 请求结束后检查耗时和写给客户端的响应字节数，超过路由的阈值时输出告警日志并计入指标。
+// 协议升级的连接持续时间由客户端决定，不作为慢请求
+func reportThresholds(r *http.Request, info *requestInfo, recorder *responseRecorder, metrics *proxyMetrics) {
+	slow, large := slowRequestThreshold, largeResponseThreshold
+	if info.route != nil {
+		slow, large = info.route.slowRequest, info.route.largeResponse
+	}
+	backend := "-"
+	if info.backend != nil {
+		backend = info.backend.String()
+	}
+	if elapsed := time.Since(info.start); slow > 0 && elapsed > slow && !isUpgradeRequest(r) {
+		info.log.Warnf("Slow request: %s %s took %s (threshold %s), backend %s, status %d", r.Method, r.URL.Path, elapsed.Round(time.Millisecond), slow, backend, recorder.statusCode())
+		if metrics != nil {
+			metrics.observeThreshold(info, thresholdSlowRequest)
+		}
+	}
+	if large > 0 && recorder.bytes > large {
+		info.log.Warnf("Large response: %s %s returned %d bytes (threshold %d), backend %s, status %d", r.Method, r.URL.Path, recorder.bytes, large, backend, recorder.statusCode())
+		if metrics != nil {
+			metrics.observeThreshold(info, thresholdLargeResponse)
+		}
+	}
+}
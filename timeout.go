@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// timeoutConfig 路由配置中的超时设置，时长格式如 5s、500ms，未写出的字段使用全局参数，0表示不限制
+type timeoutConfig struct {
+	Dial           string `json:"dial,omitempty" yaml:"dial"`                       // 连接后端的超时时间
+	ResponseHeader string `json:"response_header,omitempty" yaml:"response_header"` // 发出请求后等待后端响应头的超时时间
+	Idle           string `json:"idle,omitempty" yaml:"idle"`                       // 后端空闲连接在连接池中保留的时间
+	Request        string `json:"request,omitempty" yaml:"request"`                 // 转发一个请求的总时限，超过时取消后端请求并返回504
+}
+
+// routeTimeouts 一条路由生效的超时设置
+type routeTimeouts struct {
+	dial           time.Duration
+	responseHeader time.Duration
+	idle           time.Duration
+	request        time.Duration
+}
+
+// defaultTimeouts 由-dial-timeout等参数得到的超时设置，用于未单独配置timeouts的路由
+var defaultTimeouts routeTimeouts
+
+// merge 返回用路由配置中已设置的字段覆盖后的超时设置
+func (t routeTimeouts) merge(c timeoutConfig) (routeTimeouts, error) {
+	fields := []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"dial", c.Dial, &t.dial},
+		{"response_header", c.ResponseHeader, &t.responseHeader},
+		{"idle", c.Idle, &t.idle},
+		{"request", c.Request, &t.request},
+	}
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f.value)
+		if err != nil || d < 0 {
+			return t, fmt.Errorf("invalid %s timeout %q", f.name, f.value)
+		}
+		*f.dst = d
+	}
+	return t, nil
+}
+
+// sameTransport 判断两组超时设置能否共用同一个Transport；请求总时限在处理函数中生效，与Transport无关
+func (t routeTimeouts) sameTransport(other routeTimeouts) bool {
+	return t.dial == other.dial && t.responseHeader == other.responseHeader && t.idle == other.idle
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certReloader 持有监听器使用的证书，收到SIGHUP时重新读取证书文件，无需重启即可更换证书
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload 重新读取证书和私钥，失败时继续使用原证书
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %w", r.certFile, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate %s: %w", r.certFile, err)
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	logger.Infof("Loaded TLS certificate %s (subject: %s, expires: %s)", r.certFile, leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// getCertificate 供tls.Config.GetCertificate使用
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// newHTTPSRedirectHandler 将HTTP请求301重定向到HTTPS监听端口的相同路径
+func newHTTPSRedirectHandler(httpsAddr string) http.Handler {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// newACMEManager 创建自动申请和续期证书的ACME管理器，只为列出的域名申请证书，
+// 证书保存在cacheDir中，重启后无需重新申请
+func newACMEManager(domains []string, cacheDir, email, directoryURL string) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	if directoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: directoryURL}
+	}
+	return m
+}
+
+// backendTLSConfig 连接HTTPS后端时的TLS设置，路由配置中只需写出与全局设置不同的字段
+type backendTLSConfig struct {
+	SkipVerify *bool  `json:"skip_verify,omitempty" yaml:"skip_verify"` // 跳过后端证书验证
+	CAFile     string `json:"ca_file,omitempty" yaml:"ca_file"`         // 验证后端证书使用的CA证书(PEM)，为空时使用系统CA
+	CertFile   string `json:"cert_file,omitempty" yaml:"cert_file"`     // 双向TLS的客户端证书(PEM)
+	KeyFile    string `json:"key_file,omitempty" yaml:"key_file"`       // 双向TLS的客户端私钥(PEM)
+	ServerName string `json:"server_name,omitempty" yaml:"server_name"` // 覆盖SNI和证书验证使用的主机名
+}
+
+// merge 返回用override中已设置的字段覆盖后的配置
+func (c backendTLSConfig) merge(override backendTLSConfig) backendTLSConfig {
+	if override.SkipVerify != nil {
+		c.SkipVerify = override.SkipVerify
+	}
+	if override.CAFile != "" {
+		c.CAFile = override.CAFile
+	}
+	if override.CertFile != "" || override.KeyFile != "" {
+		c.CertFile, c.KeyFile = override.CertFile, override.KeyFile
+	}
+	if override.ServerName != "" {
+		c.ServerName = override.ServerName
+	}
+	return c
+}
+
+// cacheKey 返回区分TLS配置的键，包含证书文件的修改时间，使重新加载时能读到更新后的证书
+func (c backendTLSConfig) cacheKey() string {
+	stamp := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return path
+		}
+		return fmt.Sprintf("%s@%d", path, info.ModTime().UnixNano())
+	}
+	return fmt.Sprintf("%t|%s|%s|%s|%s", c.SkipVerify != nil && *c.SkipVerify, stamp(c.CAFile), stamp(c.CertFile), stamp(c.KeyFile), c.ServerName)
+}
+
+// build 根据配置创建tls.Config，读取CA和客户端证书文件
+func (c backendTLSConfig) build() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: c.SkipVerify != nil && *c.SkipVerify,
+		ServerName:         c.ServerName,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// W3C Trace Context中的采样标志
+const traceFlagSampled = 0x01
+
+// OTLP中的span类型和状态码
+const (
+	otlpSpanKindServer = 2
+	otlpStatusUnset    = 0
+	otlpStatusError    = 2
+)
+
+// span导出的批量大小、间隔和队列长度
+const (
+	traceExportBatch    = 512
+	traceExportInterval = 5 * time.Second
+	traceQueueSize      = 4096
+)
+
+// traceContext 一个span在W3C traceparent中的标识
+type traceContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	flags   byte
+}
+
+// parseTraceparent 解析traceparent头，格式 00-<trace-id>-<parent-id>-<flags>，无效时返回false
+func parseTraceparent(value string) (traceContext, bool) {
+	var tc traceContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return tc, false
+	}
+	// 版本00只有4段，更高版本允许在后面追加字段
+	if parts[0] == "00" && len(parts) != 4 {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.traceID[:], []byte(parts[1])); err != nil || tc.traceID == [16]byte{} {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.spanID[:], []byte(parts[2])); err != nil || tc.spanID == [8]byte{} {
+		return tc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return tc, false
+	}
+	tc.flags = flags[0]
+	return tc, true
+}
+
+// traceparent 返回该span作为父span时传给下游的traceparent头
+func (tc traceContext) traceparent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", tc.traceID, tc.spanID, tc.flags&traceFlagSampled)
+}
+
+func (tc traceContext) sampled() bool {
+	return tc.flags&traceFlagSampled != 0
+}
+
+// span 代理处理一个请求的服务端span
+type span struct {
+	traceContext
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    []otlpAttribute
+	status   int
+	message  string
+}
+
+// setAttr 添加字符串或整数属性
+func (s *span) setAttr(key string, value interface{}) {
+	attr := otlpAttribute{Key: key}
+	switch v := value.(type) {
+	case int:
+		attr.Value.IntValue = strconv.Itoa(v)
+	case int64:
+		attr.Value.IntValue = strconv.FormatInt(v, 10)
+	default:
+		s := fmt.Sprint(v)
+		attr.Value.StringValue = &s
+	}
+	s.attrs = append(s.attrs, attr)
+}
+
+// tracer 为每个请求创建span，并将采样的span批量导出到OTLP/HTTP接收端
+type tracer struct {
+	endpoint    string // 接收span的完整地址，如 http://collector:4318/v1/traces
+	headers     map[string]string
+	serviceName string
+	sampleRatio float64
+	client      *http.Client
+
+	queue   chan *span
+	dropped atomic.Int64
+	done    chan struct{}
+	flushed chan struct{}
+}
+
+// newTracer 创建tracer并启动导出goroutine，endpoint未写路径时使用OTLP默认的/v1/traces
+func newTracer(endpoint string, headers map[string]string, serviceName string, sampleRatio float64) *tracer {
+	if !strings.Contains(strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://"), "/") {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	t := &tracer{
+		endpoint:    endpoint,
+		headers:     headers,
+		serviceName: serviceName,
+		sampleRatio: sampleRatio,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *span, traceQueueSize),
+		done:        make(chan struct{}),
+		flushed:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// randomBytes 用随机数填充b，保证结果不全为0
+func randomBytes(b []byte) {
+	for {
+		rand.Read(b)
+		for _, c := range b {
+			if c != 0 {
+				return
+			}
+		}
+	}
+}
+
+// start 为请求创建span：请求带有效traceparent时作为其子span并沿用父span的采样决定，否则开始新的trace并按比例采样
+func (t *tracer) start(r *http.Request) *span {
+	s := &span{start: time.Now()}
+	if parent, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		s.traceID, s.parentID, s.flags = parent.traceID, parent.spanID, parent.flags&traceFlagSampled
+	} else {
+		randomBytes(s.traceID[:])
+		// 用trace ID的低8字节决定是否采样，同一trace在各个服务得到相同的结果
+		if float64(binary.BigEndian.Uint64(s.traceID[8:])>>11)/(1<<53) < t.sampleRatio {
+			s.flags = traceFlagSampled
+		}
+	}
+	randomBytes(s.spanID[:])
+	return s
+}
+
+// finish 结束span，采样的span放入导出队列，队列已满时丢弃
+func (t *tracer) finish(s *span) {
+	s.end = time.Now()
+	if !s.sampled() {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// run 按批量大小或时间间隔导出队列中的span，shutdown时导出剩余的span后退出
+func (t *tracer) run() {
+	defer close(t.flushed)
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+	var batch []*span
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) >= traceExportBatch {
+				t.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				t.export(batch)
+				batch = nil
+			}
+		case <-t.done:
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+				default:
+					if len(batch) > 0 {
+						t.export(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// shutdown 导出剩余的span，最多等待到ctx结束
+func (t *tracer) shutdown(ctx context.Context) {
+	close(t.done)
+	select {
+	case <-t.flushed:
+	case <-ctx.Done():
+		logger.Warn("Timed out exporting remaining trace spans")
+	}
+}
+
+// OTLP/HTTP JSON编码的导出请求，trace ID和span ID按OTLP规范写成十六进制
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    string  `json:"intValue,omitempty"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// export 将一批span编码为OTLP JSON发送到接收端，失败时记录日志并丢弃
+func (t *tracer) export(batch []*span) {
+	req := otlpExportRequest{ResourceSpans: make([]otlpResourceSpans, 1)}
+	rs := &req.ResourceSpans[0]
+	service := &span{}
+	service.setAttr("service.name", t.serviceName)
+	rs.Resource.Attributes = service.attrs
+	rs.ScopeSpans = make([]otlpScopeSpans, 1)
+	ss := &rs.ScopeSpans[0]
+	ss.Scope.Name = "st_proxy"
+	for _, s := range batch {
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              otlpSpanKindServer,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        s.attrs,
+		}
+		if s.parentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		o.Status.Code, o.Status.Message = s.status, s.message
+		ss.Spans = append(ss.Spans, o)
+	}
+
+	body, err := json.Marshal(&req)
+	if err != nil {
+		logger.Errorf("Failed to encode trace spans: %v", err)
+		return
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		logger.Errorf("Failed to export trace spans: %v", err)
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for name, value := range t.headers {
+		httpReq.Header.Set(name, value)
+	}
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		logger.Warnf("Failed to export %d trace spans to %s: %v", len(batch), t.endpoint, err)
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		logger.Warnf("Trace collector %s rejected %d spans: %s", t.endpoint, len(batch), resp.Status)
+	}
+	if dropped := t.dropped.Swap(0); dropped > 0 {
+		logger.Warnf("Dropped %d trace spans because the export queue was full", dropped)
+	}
+}
+
+// finishRequestSpan 按请求的处理结果设置span的名称、属性和状态后结束span
+func (t *tracer) finishRequestSpan(r *http.Request, info *requestInfo, status int) {
+	s := info.span
+	s.name = r.Method
+	if info.route != nil {
+		s.name += " " + info.route.name()
+		s.setAttr("http.route", info.route.prefix)
+	}
+	s.setAttr("http.request.method", r.Method)
+	s.setAttr("url.path", r.URL.Path)
+	if r.TLS != nil {
+		s.setAttr("url.scheme", "https")
+	} else {
+		s.setAttr("url.scheme", "http")
+	}
+	s.setAttr("server.address", r.Host)
+	s.setAttr("client.address", clientIP(r))
+	s.setAttr("network.protocol.version", fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor))
+	s.setAttr("user_agent.original", r.UserAgent())
+	s.setAttr("st_proxy.request_id", info.id)
+	s.setAttr("http.response.status_code", status)
+	if info.backend != nil {
+		s.setAttr("st_proxy.backend", info.backend.String())
+	}
+	if info.upstreamStatus != 0 {
+		s.setAttr("st_proxy.upstream_status_code", info.upstreamStatus)
+	}
+	s.status = otlpStatusUnset
+	if status >= 500 {
+		s.status = otlpStatusError
+		if info.proxyErr != nil {
+			s.message = info.proxyErr.Error()
+		}
+	}
+	t.finish(s)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// 响应转换出错时的处理方式
+const (
+	transformErrorFail        = "fail"        // 请求失败，由ErrorHandler返回502
+	transformErrorPassthrough = "passthrough" // 记录警告，原样返回未转换的响应
+)
+
+// recordingBody 在转换过程中记录已读取的原始响应体，出错时用于恢复原始响应
+type recordingBody struct {
+	io.ReadCloser
+	buf       bytes.Buffer
+	recording bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.recording && n > 0 {
+		b.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// applyResponseTransform 执行一个响应转换，并按-transform-error处理转换错误：
+// fail模式返回错误使请求失败；passthrough模式恢复原始响应头和响应体后继续返回
+func applyResponseTransform(resp *http.Response, name string, transform func(*http.Response) error) error {
+	origHeader := resp.Header.Clone()
+	origLength := resp.ContentLength
+	origBody := resp.Body
+
+	var rec *recordingBody
+	if origBody != nil && origBody != http.NoBody {
+		rec = &recordingBody{ReadCloser: origBody, recording: true}
+		resp.Body = rec
+	}
+
+	err := transform(resp)
+	if rec != nil {
+		rec.recording = false
+	}
+	if err == nil {
+		return nil
+	}
+
+	if transformErrorMode != transformErrorPassthrough {
+		return fmt.Errorf("%s transform failed: %w", name, err)
+	}
+
+	getRequestInfo(resp.Request).log.Warnf("%s transform failed for %s, passing original response through: %v", name, resp.Request.URL.Path, err)
+	resp.Header = origHeader
+	resp.ContentLength = origLength
+	if rec != nil {
+		resp.Body = &bodyReadCloser{
+			Reader:  io.MultiReader(bytes.NewReader(rec.buf.Bytes()), origBody),
+			closers: []io.Closer{origBody},
+		}
+	} else {
+		resp.Body = origBody
+	}
+	return nil
+}
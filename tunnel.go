@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// connectProxy 为允许的目标地址建立CONNECT隧道，连接建立后双向转发字节，
+// 使通过HTTP隧道访问内部服务的工具（如SSH、数据库客户端）可以经过代理使用
+type connectProxy struct {
+	patterns []*regexp.Regexp // 允许的目标 host:port，*匹配任意字符
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// newConnectProxy 解析允许的目标地址列表，如 git.internal:22,*.db.internal:5432,bastion:*；
+// 列表为空时返回nil，CONNECT请求返回405
+func newConnectProxy(allowed []string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (*connectProxy, error) {
+	if len(allowed) == 0 {
+		return nil, nil
+	}
+	p := &connectProxy{dial: dial}
+	for _, pattern := range allowed {
+		host, port, err := net.SplitHostPort(strings.ToLower(pattern))
+		if err != nil || host == "" || port == "" {
+			return nil, fmt.Errorf("invalid CONNECT target %q, expected host:port", pattern)
+		}
+		re, err := regexp.Compile("^" + strings.ReplaceAll(regexp.QuoteMeta(net.JoinHostPort(host, port)), `\*`, "[^:]*") + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONNECT target %q: %w", pattern, err)
+		}
+		p.patterns = append(p.patterns, re)
+	}
+	return p, nil
+}
+
+// allowed 目标地址匹配任一允许的模式时返回true
+func (p *connectProxy) allowed(target string) bool {
+	host, port, err := net.SplitHostPort(strings.ToLower(target))
+	if err != nil || host == "" || port == "" {
+		return false
+	}
+	target = net.JoinHostPort(strings.TrimSuffix(host, "."), port)
+	for _, re := range p.patterns {
+		if re.MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveConnect 校验目标地址并建立隧道：HTTP/1.x接管客户端连接，HTTP/2在请求流上双向转发
+func (p *connectProxy) serveConnect(w http.ResponseWriter, r *http.Request, info *requestInfo) {
+	target := r.Host
+	if p == nil {
+		w.Header().Set("Allow", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !p.allowed(target) {
+		info.log.WithFields(logrus.Fields{
+			"audit":     "connect_denied",
+			"client_ip": clientIP(r),
+			"target":    target,
+		}).Warnf("CONNECT to %s from %s denied: target not allowed", target, clientIP(r))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), dialTimeout)
+	backend, err := p.dial(ctx, "tcp", target)
+	cancel()
+	if err != nil {
+		info.log.Warnf("CONNECT to %s failed: %v", target, err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	defer backend.Close()
+
+	var client io.ReadWriteCloser
+	idle := tunnelIdleTimeout
+	if r.ProtoMajor == 1 {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			info.log.Errorf("CONNECT to %s: failed to hijack connection: %v", target, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+			return
+		}
+		// 客户端在收到响应前已发送的数据留在缓冲区中，先转发给目标
+		if n := rw.Reader.Buffered(); n > 0 {
+			buffered, _ := rw.Reader.Peek(n)
+			if _, err := backend.Write(buffered); err != nil {
+				return
+			}
+		}
+		// 接管的连接已按-tunnel-idle-timeout检查空闲（见responseRecorder.Hijack）
+		client, idle = conn, 0
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			return
+		}
+		client = &streamConn{r: r.Body, w: w}
+	}
+
+	info.log.Infof("CONNECT tunnel to %s established for %s", target, clientIP(r))
+	up, down := tunnel(client, backend, idle)
+	info.log.Infof("CONNECT tunnel to %s closed after %s: %d bytes sent, %d bytes received", target, time.Since(info.start).Round(time.Millisecond), up, down)
+}
+
+// streamConn 将HTTP/2 CONNECT请求的请求体和响应组合为双向流
+type streamConn struct {
+	r io.ReadCloser
+	w http.ResponseWriter
+}
+
+func (c *streamConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err == nil {
+		err = http.NewResponseController(c.w).Flush()
+	}
+	return n, err
+}
+
+func (c *streamConn) Close() error { return c.r.Close() }
+
+// tunnel 在客户端和目标之间双向复制字节，直到任一方向结束或超过idle没有数据，
+// 返回客户端发出和收到的字节数；idle为0时不限制空闲时间
+func tunnel(client, backend io.ReadWriteCloser, idle time.Duration) (int64, int64) {
+	var timer *idleTimer
+	if idle > 0 {
+		timer = newIdleTimer(idle, func() {
+			logger.Infof("Closing tunnel after %s without traffic", idle)
+			client.Close()
+			backend.Close()
+		})
+		defer timer.stop()
+	}
+	var up, down int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyDir := func(dst, src io.ReadWriteCloser, n *int64) {
+		defer wg.Done()
+		*n, _ = io.Copy(dst, &activityReader{r: src, timer: timer})
+		// 一个方向结束后关闭两端，使另一个方向的复制也结束
+		client.Close()
+		backend.Close()
+	}
+	go copyDir(backend, client, &up)
+	go copyDir(client, backend, &down)
+	wg.Wait()
+	return up, down
+}
+
+// idleTimer 在连续idle时间没有数据时调用onIdle
+type idleTimer struct {
+	idle  time.Duration
+	timer *time.Timer
+}
+
+func newIdleTimer(idle time.Duration, onIdle func()) *idleTimer {
+	return &idleTimer{idle: idle, timer: time.AfterFunc(idle, onIdle)}
+}
+
+// touch 有数据时重新计时
+func (t *idleTimer) touch() {
+	if t != nil {
+		t.timer.Reset(t.idle)
+	}
+}
+
+func (t *idleTimer) stop() {
+	t.timer.Stop()
+}
+
+// activityReader 每次读到数据时重新计算空闲时间
+type activityReader struct {
+	r     io.Reader
+	timer *idleTimer
+}
+
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.timer.touch()
+	}
+	return n, err
+}
+
+// idleConn 协议升级（如WebSocket）或CONNECT后接管的客户端连接，双向都超过idle没有数据时关闭连接，
+// ReverseProxy随之结束转发并关闭后端连接
+type idleConn struct {
+	net.Conn
+	timer *idleTimer
+}
+
+func newIdleConn(conn net.Conn, idle time.Duration) *idleConn {
+	c := &idleConn{Conn: conn}
+	c.timer = newIdleTimer(idle, func() {
+		logger.Infof("Closing tunnel connection from %s after %s without traffic", conn.RemoteAddr(), idle)
+		conn.Close()
+	})
+	return c
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.timer.touch()
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.timer.touch()
+	}
+	return n, err
+}
+
+func (c *idleConn) Close() error {
+	c.timer.stop()
+	return c.Conn.Close()
+}
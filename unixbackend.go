@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unixBackendPrefix Unix域套接字后端地址的前缀，如 unix:///var/run/app.sock 或 unix:///var/run/app.sock:/api/
+const unixBackendPrefix = "unix://"
+
+// unixHostSuffix 代表Unix域套接字后端的主机名后缀，连接时据此改为连接套接字文件
+const unixHostSuffix = ".unix"
+
+// unixSockets 后端主机名 -> 套接字文件路径
+var unixSockets sync.Map
+
+// parseUnixBackend 解析 unix:///path/app.sock[:/base/] 形式的后端地址，冒号后为后端基础路径，未写时为/。
+// 返回的URL使用http协议和由套接字路径生成的主机名，转发、健康检查和连接池都与TCP后端相同，只在建立连接时连接套接字文件
+func parseUnixBackend(raw string) (*url.URL, error) {
+	socket, base, _ := strings.Cut(strings.TrimPrefix(raw, unixBackendPrefix), ":")
+	if !strings.HasPrefix(socket, "/") {
+		return nil, fmt.Errorf("invalid backend URL %q: socket path must be absolute, expected unix:///path/app.sock[:/base/]", raw)
+	}
+	if base == "" {
+		base = "/"
+	}
+	if !strings.HasPrefix(base, "/") {
+		return nil, fmt.Errorf("invalid backend URL %q: base path must start with /", raw)
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend URL %q: %w", raw, err)
+	}
+	socket = filepath.Clean(socket)
+	host := unixSocketHost(socket)
+	if prev, loaded := unixSockets.LoadOrStore(host, socket); loaded && prev.(string) != socket {
+		return nil, fmt.Errorf("backend socket %s conflicts with %s, rename one of them", socket, prev)
+	}
+	u.Scheme, u.Host = "http", host
+	return u, nil
+}
+
+// unixSocketHost 由套接字路径生成主机名，如 /var/run/app.sock -> var-run-app.sock.unix，出现在日志和指标中
+func unixSocketHost(path string) string {
+	var b strings.Builder
+	for _, c := range strings.TrimPrefix(path, "/") {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-':
+			b.WriteRune(c)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	return b.String() + unixHostSuffix
+}
+
+// unixSocketPath 返回后端主机对应的套接字文件路径，不是Unix域套接字后端时返回false
+func unixSocketPath(host string) (string, bool) {
+	if !strings.HasSuffix(host, unixHostSuffix) {
+		return "", false
+	}
+	path, ok := unixSockets.Load(host)
+	if !ok {
+		return "", false
+	}
+	return path.(string), true
+}
+
+// unixSocketDialer 包装DialContext，Unix域套接字后端改为连接套接字文件
+func unixSocketDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		if path, ok := unixSocketPath(host); ok {
+			return dial(ctx, "unix", path)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// dialBackend 建立到后端的TCP或Unix域套接字连接，用于不发送请求的健康检查和就绪检查
+func dialBackend(backend *url.URL, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return backendDial(&net.Dialer{})(ctx, "tcp", hostWithPort(backend))
+}
+
+// backendHostHeader 返回发给后端的Host头，Unix域套接字后端使用localhost，由DNS展开的后端使用原始主机
+func backendHostHeader(backend *url.URL) string {
+	if _, ok := unixSocketPath(backend.Hostname()); ok {
+		return "localhost"
+	}
+	if original, ok := discovery.originalHost(backend.Host); ok {
+		return original
+	}
+	return backend.Host
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// upgradeType 返回请求或响应要求切换的协议（如websocket），不是协议升级时返回空字符串
+func upgradeType(h http.Header) string {
+	for _, value := range h.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "Upgrade") {
+				return strings.ToLower(h.Get("Upgrade"))
+			}
+		}
+	}
+	return ""
+}
+
+// isUpgradeRequest 判断请求是否为协议升级请求（如WebSocket握手）或CONNECT隧道请求，
+// 这类请求接管连接后双向转发，不压缩、不限速、不重试
+func isUpgradeRequest(r *http.Request) bool {
+	return r.Method == http.MethodConnect || upgradeType(r.Header) != ""
+}
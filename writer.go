@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// earlyHintsFilter 在未启用早期提示透传时丢弃后端发来的103响应，
+// 其余1xx（如100 Continue）和最终响应照常写出
+type earlyHintsFilter struct {
+	http.ResponseWriter
+}
+
+func (w *earlyHintsFilter) WriteHeader(code int) {
+	if code == http.StatusEarlyHints {
+		// ReverseProxy不会自动清理1xx响应的头，这里同样清理避免带入最终响应
+		for k := range w.Header() {
+			w.Header().Del(k)
+		}
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap 供http.ResponseController访问底层ResponseWriter（Flush、Hijack等）
+func (w *earlyHintsFilter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// responseRecorder 记录实际写给客户端的状态码和字节数，
+// 包括ErrorHandler改写后的502/503/504等ModifyResponse看不到的状态
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	// 1xx为临时响应（101协议切换除外），不作为最终状态码
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap 供http.ResponseController访问底层ResponseWriter（Flush、Hijack等）
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack 接管客户端连接（用于WebSocket等协议升级和CONNECT隧道），ReverseProxy直接向连接写出101响应，
+// 因此在这里记录状态码；设置了-tunnel-idle-timeout时接管的连接空闲超时后关闭
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return conn, rw, err
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	if tunnelIdleTimeout > 0 {
+		conn = newIdleConn(conn, tunnelIdleTimeout)
+	}
+	return conn, rw, nil
+}
+
+// statusCode 返回最终状态码，未写出任何内容时按200处理
+func (w *responseRecorder) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"sync"
+	"testing"
+)
+
+// TestEarlyHints 后端先发103再发200：启用-early-hints时客户端收到103，未启用时103被丢弃且不影响最终响应
+func TestEarlyHints(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", "</app.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	for _, tc := range []struct {
+		name      string
+		flag      string
+		wantHints int
+	}{
+		{"enabled", "-early-hints=true", 1},
+		{"disabled", "-early-hints=false", 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := startProxy(t, "-backend", backend.URL+"/", "-prefix", "/api/", tc.flag)
+
+			var mu sync.Mutex
+			var hints []textproto.MIMEHeader
+			trace := &httptrace.ClientTrace{
+				Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+					if code == http.StatusEarlyHints {
+						mu.Lock()
+						hints = append(hints, header)
+						mu.Unlock()
+					}
+					return nil
+				},
+			}
+			ctx := httptrace.WithClientTrace(context.Background(), trace)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/api/page", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+				t.Fatalf("final response = %d %q, want 200 \"ok\"", resp.StatusCode, body)
+			}
+			if got := resp.Header.Get("Link"); got != "" {
+				t.Errorf("final response carries early hint header Link: %q", got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(hints) != tc.wantHints {
+				t.Fatalf("got %d 103 responses, want %d", len(hints), tc.wantHints)
+			}
+			if tc.wantHints > 0 && hints[0].Get("Link") != "</app.css>; rel=preload; as=style" {
+				t.Errorf("103 Link = %q", hints[0].Get("Link"))
+			}
+		})
+	}
+}